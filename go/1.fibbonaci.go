@@ -1,37 +1,326 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"math/big"
+	"math/bits"
+	"math/rand"
+	"os"
 	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/fib"
+	"github.com/python-memory-research/go/gmp"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/sysx"
+	"github.com/python-memory-research/go/tracing"
+	"github.com/python-memory-research/go/verbosity"
 )
 
-func measureExecutionTime(name string, fn func()) {
+func measureExecutionTime(name string, fn func()) time.Duration {
 	start := time.Now()
 	fn()
 	elapsed := time.Since(start)
 	fmt.Printf("%s took %.4f seconds.\n", name, elapsed.Seconds())
+	return elapsed
+}
+
+// heapAllocs is the heap allocation made during a run: objects and bytes.
+type heapAllocs struct {
+	count, bytes uint64
+}
+
+// measureAllocs reads MemStats around fn and returns what it allocated.
+func measureAllocs(fn func()) heapAllocs {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return heapAllocs{after.Mallocs - before.Mallocs, after.TotalAlloc - before.TotalAlloc}
+}
+
+// metrics records a in m under the names -benchformat prints as allocs/op
+// and B/op. One run is one op, as in its ns/op.
+func (a heapAllocs) metrics(m map[string]float64) map[string]float64 {
+	m["allocs_per_op"] = float64(a.count)
+	m["bytes_per_op"] = float64(a.bytes)
+	return m
+}
+
+// fibStart returns F(0), F(1) and the scratch value fib.Step needs.
+func fibStart() (a, b, temp *big.Int) {
+	return big.NewInt(0), big.NewInt(1), new(big.Int)
+}
+
+// fibStartPrealloc is fibStart with all three backed by word slices allocated
+// up front at F(n)'s final size, estimated by fibBitLength, so none of the
+// first n steps has to grow them.
+func fibStartPrealloc(n int) (a, b, temp *big.Int) {
+	// One spare word for the estimate and one for Add's carry.
+	words := fibBitLength(n)/bits.UintSize + 2
+	a = new(big.Int).SetBits(make([]big.Word, 0, words))
+	b = new(big.Int).SetBits(make([]big.Word, 0, words))
+	temp = new(big.Int).SetBits(make([]big.Word, 0, words))
+	b.SetInt64(1)
+	return a, b, temp
 }
 
 func computeFibonacci(n int) *big.Int {
-	a := big.NewInt(0)
-	b := big.NewInt(1)
-	temp := new(big.Int)
+	a, b, temp := fibStart()
+	for i := 0; i < n; i++ {
+		fib.Step(a, b, temp)
+	}
+	return a
+}
+
+// computeFibonacciPrealloc is computeFibonacci starting from
+// fibStartPrealloc, so the loop never reallocates.
+func computeFibonacciPrealloc(n int) *big.Int {
+	a, b, temp := fibStartPrealloc(n)
+	for i := 0; i < n; i++ {
+		fib.Step(a, b, temp)
+	}
+	return a
+}
 
+// stepContext takes n steps from (a, b) and returns a, or stops early with
+// ctx.Err() once ctx is done. The context is checked every 1024 steps, which
+// costs nothing measurable next to the big.Int additions.
+func stepContext(ctx context.Context, n int, a, b, temp *big.Int) (*big.Int, error) {
+	for i := 0; i < n; i++ {
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		fib.Step(a, b, temp)
+	}
+	return a, nil
+}
+
+// computeFibonacciContext is computeFibonacci that gives up once ctx is done.
+func computeFibonacciContext(ctx context.Context, n int) (*big.Int, error) {
+	a, b, temp := fibStart()
+	return stepContext(ctx, n, a, b, temp)
+}
+
+// computeFibonacciPreallocContext is computeFibonacciPrealloc that gives up
+// once ctx is done.
+func computeFibonacciPreallocContext(ctx context.Context, n int) (*big.Int, error) {
+	a, b, temp := fibStartPrealloc(n)
+	return stepContext(ctx, n, a, b, temp)
+}
 
+// computeLucas returns L(n), which follows the Fibonacci recurrence from
+// L(0) = 2, L(1) = 1.
+func computeLucas(n int) *big.Int {
+	a, b, temp := big.NewInt(2), big.NewInt(1), new(big.Int)
 	for i := 0; i < n; i++ {
-		temp.Set(a)
-		a.Set(b)
-		b.Add(temp, b)
+		fib.Step(a, b, temp)
 	}
 	return a
 }
 
+// computeTerm is the sequence the benchmarks run; -sequence lucas switches
+// it to computeLucas and -reuse prealloc to computeFibonacciPrealloc.
+var computeTerm = computeFibonacci
+
+// computeTermContext is computeTerm for -per-task-timeout, which only runs
+// Fibonacci; -reuse prealloc switches it along with computeTerm.
+var computeTermContext = computeFibonacciContext
+
+// lucasIdentityHolds checks L(n) = F(n-1) + F(n+1) for n >= 1.
+func lucasIdentityHolds(n int) bool {
+	sum := new(big.Int).Add(computeFibonacci(n-1), computeFibonacci(n+1))
+	return computeLucas(n).Cmp(sum) == 0
+}
+
+// Identities accepted by -identity.
+const (
+	identitySum   = "sum"   // F(0) + ... + F(n) = F(n+2) - 1
+	identitySumSq = "sumsq" // F(0)² + ... + F(n)² = F(n)·F(n+1)
+)
+
+// fibIdentityHolds accumulates the sum (or sum of squares) of F(0)..F(n)
+// term by term and compares it with the closed form for that identity.
+func fibIdentityHolds(identity string, n int) bool {
+	a, b, temp := fibStart()
+	sum := new(big.Int)
+
+	for i := 0; i <= n; i++ {
+		if identity == identitySumSq {
+			sum.Add(sum, temp.Mul(a, a))
+		} else {
+			sum.Add(sum, a)
+		}
+		fib.Step(a, b, temp)
+	}
+
+	var want *big.Int
+	if identity == identitySumSq {
+		want = new(big.Int).Mul(computeFibonacci(n), computeFibonacci(n+1))
+	} else {
+		want = new(big.Int).Sub(computeFibonacci(n+2), big.NewInt(1))
+	}
+	return sum.Cmp(want) == 0
+}
+
+// computeFibonacciBatch returns F(n) for every distinct index in nums. All
+// indices share one pass of the recurrence up to the largest of them, so a
+// duplicate or a smaller index costs only a copy of the value at that step.
+func computeFibonacciBatch(nums []int) map[int]*big.Int {
+	out := make(map[int]*big.Int, len(nums))
+	maxN := 0
+	for _, n := range nums {
+		out[n] = nil
+		if n > maxN {
+			maxN = n
+		}
+	}
+
+	a, b, temp := fibStart()
+	for i := 0; i <= maxN; i++ {
+		if _, ok := out[i]; ok {
+			out[i] = new(big.Int).Set(a)
+		}
+		fib.Step(a, b, temp)
+	}
+	return out
+}
+
+// parseIndices parses a comma-separated list of non-negative Fibonacci indices.
+func parseIndices(s string) ([]int, error) {
+	var nums []int
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid index %q", f)
+		}
+		nums = append(nums, n)
+	}
+	if len(nums) == 0 {
+		return nil, fmt.Errorf("no indices given")
+	}
+	return nums, nil
+}
+
+// fibDigitCount returns the number of decimal digits of F(n) without computing it.
+// It uses Binet's formula in log space: log10 F(n) ≈ n·log10(φ) − log10(√5).
+// The dropped ψ^n term is added back as a correction, which matters for small n
+// where the plain approximation lands on the wrong side of a power of ten.
+func fibDigitCount(n int) int {
+	if n < 3 {
+		// F(0) = 0, F(1) = F(2) = 1; log10(1) = 0 sits exactly on a digit boundary.
+		return 1
+	}
+
+	phi := (1 + math.Sqrt(5)) / 2
+	x := float64(n)*math.Log10(phi) - math.Log10(math.Sqrt(5))
+
+	// F(n) = φ^n/√5 · (1 − (ψ/φ)^n), with ψ/φ = −1/φ².
+	ratio := math.Pow(-1/(phi*phi), float64(n))
+	x += math.Log1p(-ratio) / math.Ln10
+
+	return int(math.Floor(x)) + 1
+}
+
+// maxBinetN is the largest n for which fibBinet is exact. F(78) is the last
+// term below 2^53, but φ^n carries a relative rounding error of a few ulps,
+// which is already more than 1/2 for n = 76..78.
+const maxBinetN = 75
+
+// fibBinet returns F(n) from Binet's formula, F(n) = round(φ^n/√5), in
+// float64 without touching big.Int. The ψ^n term is below 1/2 for n ≥ 0, so
+// rounding drops it. Above maxBinetN the result is no longer exact and
+// fibBinet returns an error instead.
+func fibBinet(n int) (uint64, error) {
+	if n < 0 || n > maxBinetN {
+		return 0, fmt.Errorf("binet: n must be between 0 and %d, got %d", maxBinetN, n)
+	}
+	phi := (1 + math.Sqrt(5)) / 2
+	return uint64(math.Round(math.Pow(phi, float64(n)) / math.Sqrt(5))), nil
+}
+
+// fibSmall returns F(n) through the fibBinet fast path where it is exact and
+// falls back to computeFibonacci above maxBinetN.
+func fibSmall(n int) *big.Int {
+	if v, err := fibBinet(n); err == nil {
+		return new(big.Int).SetUint64(v)
+	}
+	return computeFibonacci(n)
+}
+
+// formatFibonacci renders F(n) in the given base (2–36), or only its digit count
+// in that base when digitsOnly is set.
+func formatFibonacci(n, base int, digitsOnly bool) (string, error) {
+	if base < 2 || base > 36 {
+		return "", fmt.Errorf("base must be between 2 and 36, got %d", base)
+	}
+	text := computeTerm(n).Text(base)
+	if digitsOnly {
+		return fmt.Sprintf("%d", len(text)), nil
+	}
+	return text, nil
+}
+
+// peekDigits shortens a decimal string to its first and last k digits,
+// e.g. "354...075" for F(100) with k=3. Strings of at most 2k digits are
+// returned whole.
+func peekDigits(s string, k int) string {
+	if len(s) <= 2*k {
+		return s
+	}
+	return s[:k] + "..." + s[len(s)-k:]
+}
+
+// timeBinet returns the mean cost in ns of fibBinet(n) and of
+// computeFibonacci(n) over reps calls each.
+func timeBinet(n, reps int) (binetNs, bigNs float64) {
+	var sink uint64
+	start := time.Now()
+	for i := 0; i < reps; i++ {
+		v, _ := fibBinet(n)
+		sink += v
+	}
+	binetNs = float64(time.Since(start).Nanoseconds()) / float64(reps)
+
+	start = time.Now()
+	for i := 0; i < reps; i++ {
+		sink += computeFibonacci(n).Uint64()
+	}
+	bigNs = float64(time.Since(start).Nanoseconds()) / float64(reps)
+	_ = sink
+	return binetNs, bigNs
+}
+
+// startThreadSampling starts sysx.SampleThreads when enabled; otherwise the
+// returned stop function reports zeros.
+func startThreadSampling(enabled bool) (stop func() (threads, goroutines int)) {
+	if !enabled {
+		return func() (int, int) { return 0, 0 }
+	}
+	return sysx.SampleThreads(time.Millisecond)
+}
+
 func runSingleThreaded(nums []int) {
 	for _, num := range nums {
-		computeFibonacci(num)
+		start := time.Now()
+		computeTerm(num)
+		verbosity.Detailf("  n=%d: %.4f seconds\n", num, time.Since(start).Seconds())
 	}
 }
 
@@ -42,34 +331,717 @@ func runMultiThreaded(nums []int) {
 	for _, num := range nums {
 		go func(n int) {
 			defer wg.Done()
-			computeFibonacci(n)
+			computeTerm(n)
 		}(num)
 	}
 
 	wg.Wait()
 }
 
+// fibWithin reports whether F(n) finished computing within timeout.
+func fibWithin(n int, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := computeTermContext(ctx, n)
+	return err == nil
+}
+
+// runSingleThreadedTimeout is runSingleThreaded with each index given its own
+// timeout. It returns the indices that finished and those that ran out of
+// time, each in input order.
+func runSingleThreadedTimeout(nums []int, timeout time.Duration) (completed, timedOut []int) {
+	done := make([]bool, len(nums))
+	for i, n := range nums {
+		done[i] = fibWithin(n, timeout)
+	}
+	return splitByDone(nums, done)
+}
+
+// runMultiThreadedTimeout is runMultiThreaded with each index given its own
+// timeout, returning indices like runSingleThreadedTimeout.
+func runMultiThreadedTimeout(nums []int, timeout time.Duration) (completed, timedOut []int) {
+	done := make([]bool, len(nums))
+	var wg sync.WaitGroup
+	wg.Add(len(nums))
+
+	for i, num := range nums {
+		go func(i, n int) {
+			defer wg.Done()
+			done[i] = fibWithin(n, timeout)
+		}(i, num)
+	}
+
+	wg.Wait()
+	return splitByDone(nums, done)
+}
+
+func splitByDone(nums []int, done []bool) (completed, timedOut []int) {
+	for i, n := range nums {
+		if done[i] {
+			completed = append(completed, n)
+		} else {
+			timedOut = append(timedOut, n)
+		}
+	}
+	return completed, timedOut
+}
+
+const hashChunkSize = 4096
+
+// fibHash computes F(n) and reduces it to a 64-bit FNV-1a hash of its
+// big-endian bytes, fed in chunks. Only the hash outlives the call, so a
+// batch of large indices doesn't keep every full result alive.
+func fibHash(n int) uint64 {
+	return hashTerm(computeTerm(n))
+}
+
+func hashTerm(x *big.Int) uint64 {
+	b := x.Bytes()
+	h := fnv.New64a()
+	for off := 0; off < len(b); off += hashChunkSize {
+		h.Write(b[off:min(off+hashChunkSize, len(b))])
+	}
+	return h.Sum64()
+}
+
+// Reductions for -reduce: what runMultiThreadedCollect keeps of each result.
+const (
+	reduceNone     = "none"     // the full big.Int
+	reduceDigits   = "digits"   // decimal digit count
+	reduceHash     = "hash"     // FNV-1a hash of the big-endian bytes, as fibHash
+	reduceLastWord = "lastword" // lowest 64 bits, i.e. the value mod 2^64
+)
+
+// reducedTerm is one collected result: the full value with reduceNone,
+// otherwise just the derived number.
+type reducedTerm struct {
+	full  *big.Int
+	value uint64
+}
+
+func (r reducedTerm) String() string {
+	if r.full != nil {
+		return r.full.String()
+	}
+	return strconv.FormatUint(r.value, 10)
+}
+
+// reduceTerm keeps what mode asks for of x. The digit count converts x to
+// decimal, which costs far more than computing it did for large n.
+func reduceTerm(x *big.Int, mode string) reducedTerm {
+	switch mode {
+	case reduceDigits:
+		return reducedTerm{value: uint64(len(x.Text(10)))}
+	case reduceHash:
+		return reducedTerm{value: hashTerm(x)}
+	case reduceLastWord:
+		low := new(big.Int).And(x, new(big.Int).SetUint64(math.MaxUint64))
+		return reducedTerm{value: low.Uint64()}
+	}
+	return reducedTerm{full: x}
+}
+
+// runMultiThreadedCollect is runMultiThreaded keeping reduceTerm of each
+// result, so only what mode asks for outlives its goroutine.
+func runMultiThreadedCollect(nums []int, mode string) []reducedTerm {
+	results := make([]reducedTerm, len(nums))
+	var wg sync.WaitGroup
+	wg.Add(len(nums))
+
+	for i, num := range nums {
+		go func(i, n int) {
+			defer wg.Done()
+			results[i] = reduceTerm(computeTerm(n), mode)
+		}(i, num)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// memLimitRun is one hashed multi-threaded batch for -soft-memory-limit.
+type memLimitRun struct {
+	elapsed  time.Duration
+	gcCycles uint32
+	allocs   heapAllocs
+	hashes   []reducedTerm
+}
+
+// runHashedBatch runs runMultiThreadedCollect in hash mode with the soft
+// memory limit set to limit bytes (math.MaxInt64 for none), then restores
+// the previous limit. Near the limit the GC runs more often than GOGC alone
+// would ask for, trading CPU time for a smaller heap.
+func runHashedBatch(nums []int, limit int64) memLimitRun {
+	runtime.GC()
+	prev := debug.SetMemoryLimit(limit)
+	defer debug.SetMemoryLimit(prev)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	hashes := runMultiThreadedCollect(nums, reduceHash)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return memLimitRun{
+		elapsed:  elapsed,
+		gcCycles: after.NumGC - before.NumGC,
+		allocs:   heapAllocs{after.Mallocs - before.Mallocs, after.TotalAlloc - before.TotalAlloc},
+		hashes:   hashes,
+	}
+}
+
+// sameHashes reports whether two hashed batches produced identical results.
+func sameHashes(a, b []reducedTerm) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].value != b[i].value {
+			return false
+		}
+	}
+	return true
+}
+
+// fibBitLength estimates the bit length of F(n) the same way fibDigitCount
+// estimates its decimal length.
+func fibBitLength(n int) int {
+	if n < 3 {
+		return 1
+	}
+	phi := (1 + math.Sqrt(5)) / 2
+	return int(float64(n)*math.Log2(phi)-math.Log2(math.Sqrt(5))) + 1
+}
+
+type mulTiming struct {
+	n    int
+	bits int
+	ns   float64
+}
+
+// timeMultiplication returns the fastest of reps timings of one x*y with
+// random bits-long operands. The minimum filters out scheduler and GC noise.
+func timeMultiplication(bits, reps int) float64 {
+	rng := rand.New(rand.NewSource(int64(bits)))
+	x := new(big.Int).Rand(rng, new(big.Int).Lsh(big.NewInt(1), uint(bits)))
+	y := new(big.Int).Rand(rng, new(big.Int).Lsh(big.NewInt(1), uint(bits)))
+	x.SetBit(x, bits-1, 1)
+	y.SetBit(y, bits-1, 1)
+	z := new(big.Int)
+
+	best := math.Inf(1)
+	for i := 0; i < reps; i++ {
+		start := time.Now()
+		z.Mul(x, y)
+		if ns := float64(time.Since(start).Nanoseconds()); ns < best {
+			best = ns
+		}
+	}
+	return best
+}
+
+// runMulScaling times one multiplication at the operand size of F(n) for
+// each n, exposing where math/big switches from schoolbook to Karatsuba.
+func runMulScaling(indices []int) []mulTiming {
+	timings := make([]mulTiming, 0, len(indices))
+	for _, n := range indices {
+		bits := fibBitLength(n)
+		reps := max(5, 2_000_000/bits)
+		timings = append(timings, mulTiming{n: n, bits: bits, ns: timeMultiplication(bits, reps)})
+	}
+	return timings
+}
+
+func printMulScaling(timings []mulTiming) {
+	fmt.Printf("%-10s %-10s %-14s %s\n", "n", "bits", "ns/mul", "slope")
+	for i, t := range timings {
+		slope := ""
+		if i > 0 {
+			prev := timings[i-1]
+			// Exponent k in time ~ bits^k between consecutive points:
+			// ~2 for schoolbook, ~1.58 for Karatsuba.
+			k := math.Log(t.ns/prev.ns) / math.Log(float64(t.bits)/float64(prev.bits))
+			slope = fmt.Sprintf("%.2f", k)
+		}
+		fmt.Printf("%-10d %-10d %-14.0f %s\n", t.n, t.bits, t.ns, slope)
+	}
+}
+
+// logLogSlope fits log(y) = k·log(x) + c by least squares and returns k, the
+// exponent of the power law y ~ x^k. It is NaN with fewer than two distinct x.
+func logLogSlope(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		lx, ly := math.Log(xs[i]), math.Log(ys[i])
+		sx += lx
+		sy += ly
+		sxx += lx * lx
+		sxy += lx * ly
+	}
+	den := n*sxx - sx*sx
+	if len(xs) < 2 || den == 0 {
+		return math.NaN()
+	}
+	return (n*sxy - sx*sy) / den
+}
+
+// geometricIndices returns 1000, 2000, 4000, ... up to and including maxN.
+func geometricIndices(maxN int) []int {
+	var indices []int
+	for n := 1000; n <= maxN; n *= 2 {
+		indices = append(indices, n)
+	}
+	return indices
+}
+
+// runGeometric times computeTerm at each index, best of three, and returns
+// the timings in seconds.
+func runGeometric(indices []int) []float64 {
+	seconds := make([]float64, len(indices))
+	for i, n := range indices {
+		best := math.Inf(1)
+		for r := 0; r < 3; r++ {
+			start := time.Now()
+			computeTerm(n)
+			best = min(best, time.Since(start).Seconds())
+		}
+		seconds[i] = best
+	}
+	return seconds
+}
+
+// reuseRun is one computeFibonacci variant's cost at a single n.
+type reuseRun struct {
+	seconds float64 // best of three
+	allocs  uint64  // heap allocations in one call
+	value   *big.Int
+}
+
+// timeReuse measures fn(n) for -compare-reuse.
+func timeReuse(fn func(int) *big.Int, n int) reuseRun {
+	run := reuseRun{seconds: math.Inf(1)}
+	for r := 0; r < 3; r++ {
+		start := time.Now()
+		run.value = fn(n)
+		run.seconds = min(run.seconds, time.Since(start).Seconds())
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn(n)
+	runtime.ReadMemStats(&after)
+	run.allocs = after.Mallocs - before.Mallocs
+	return run
+}
+
+// gmpIndices are the n that -gmp compares at.
+var gmpIndices = []int{10000, 100000, 300000}
+
+// compareGMP times F(n) as a decimal string from gmp.Fibonacci and from
+// computeFibonacci, and reports whether the two strings are equal.
+func compareGMP(n int) (gmpSeconds, bigSeconds float64, match bool) {
+	start := time.Now()
+	viaGMP := gmp.Fibonacci(n)
+	gmpSeconds = time.Since(start).Seconds()
+
+	start = time.Now()
+	viaBig := computeFibonacci(n).Text(10)
+	bigSeconds = time.Since(start).Seconds()
+	return gmpSeconds, bigSeconds, viaGMP == viaBig
+}
+
 func main() {
+	printResult := flag.Bool("print", false, "Print F(n) after the benchmark")
+	base := flag.Int("base", 10, "Radix (2-36) used when printing F(n)")
+	digitsOnly := flag.Bool("digits", false, "Print only the digit count of F(n) instead of the number")
+	hashOnly := flag.Bool("hash-only", false, "In the multi-threaded run keep only an FNV-64a hash of each F(n) and report it (same as -reduce hash)")
+	reduce := flag.String("reduce", "", "In the multi-threaded run keep only this of each result and report it: none, digits, hash, or lastword (low 64 bits)")
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	indices := flag.String("indices", "", "Comma-separated Fibonacci indices to benchmark, e.g. 10,20,300000 (default: ten copies of 300000)")
+	seq := flag.String("sequence", "fib", "Sequence to compute: fib or lucas")
+	verify := flag.Bool("verify", false, "Check L(n) = F(n-1) + F(n+1) for n = 1..30 and the benchmarked indices")
+	identity := flag.String("identity", "", "Check an aggregate identity for each index, then exit: sum (ΣF(i) = F(n+2)-1) or sumsq (ΣF(i)² = F(n)·F(n+1))")
+	priority := flag.Int("priority", 0, "Experimental: set the process niceness to this before the timed runs, e.g. -10 (lower needs privileges; 0 = unchanged)")
+	perTaskTimeout := flag.Duration("per-task-timeout", 0, "In the multi-threaded run, give up on any index that takes longer than this (0 = no limit; fib only)")
+	tracePath := flag.String("trace", "", tracing.FlagUsage)
+	threads := flag.Bool("threads", false, "Sample OS threads and goroutines during each run and report the peaks")
+	peek := flag.Int("peek", 0, "Print only the first and last k decimal digits of the result")
+	mulScaling := flag.Bool("mul-scaling", false, "Time one big.Int multiplication at the size of F(n) for growing n, then exit")
+	softMemLimit := flag.Int64("soft-memory-limit", 0, "Also rerun the multi-threaded batch with debug.SetMemoryLimit set to this many bytes and compare GC cycles and time (0 = off)")
+	geometric := flag.Int("geometric", 0, "Time n = 1000, 2000, 4000, ... up to this, fit the power-law exponent of the runtime, then exit (0 = off)")
+	reuse := flag.String("reuse", "temp", "big.Int reuse in the fib loop: temp (one shared temp, grown as needed) or prealloc (all three sized for F(n) up front)")
+	compareReuse := flag.Bool("compare-reuse", false, "Time the temp and prealloc fib loops against each other for n = 10000, 100000 and 300000, then exit")
+	useGMP := flag.Bool("gmp", false, "Compare F(n) from GNU MP (build with -tags gmp) against math/big for n = 10000, 100000 and 300000, then exit")
+	binet := flag.Bool("binet", false, fmt.Sprintf("Compare the float64 Binet formula against big.Int for n up to %d, then exit", maxBinetN))
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+
+	if *base < 2 || *base > 36 {
+		fmt.Fprintf(os.Stderr, "Invalid -base %d: must be between 2 and 36\n", *base)
+		os.Exit(1)
+	}
+
+	sym, benchName := "F", "fibonacci"
+	switch *seq {
+	case "fib":
+	case "lucas":
+		computeTerm = computeLucas
+		sym, benchName = "L", "lucas"
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -sequence %q: must be fib or lucas\n", *seq)
+		os.Exit(1)
+	}
+	switch *reuse {
+	case "temp":
+	case "prealloc":
+		if *seq != "fib" {
+			fmt.Fprintln(os.Stderr, "-reuse prealloc needs -sequence fib")
+			os.Exit(1)
+		}
+		computeTerm, computeTermContext = computeFibonacciPrealloc, computeFibonacciPreallocContext
+		benchName = "fibonacci_prealloc"
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -reuse %q: must be temp or prealloc\n", *reuse)
+		os.Exit(1)
+	}
+	if *identity != "" && *identity != identitySum && *identity != identitySumSq {
+		fmt.Fprintf(os.Stderr, "Invalid -identity %q: must be sum or sumsq\n", *identity)
+		os.Exit(1)
+	}
+	if *hashOnly {
+		if *reduce != "" && *reduce != reduceHash {
+			fmt.Fprintln(os.Stderr, "-hash-only conflicts with -reduce "+*reduce)
+			os.Exit(1)
+		}
+		*reduce = reduceHash
+	}
+	switch *reduce {
+	case "", reduceNone, reduceDigits, reduceHash, reduceLastWord:
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -reduce %q: must be none, digits, hash, or lastword\n", *reduce)
+		os.Exit(1)
+	}
+	if *softMemLimit < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -soft-memory-limit %d: must be >= 0\n", *softMemLimit)
+		os.Exit(1)
+	}
+	if *perTaskTimeout > 0 && (*seq != "fib" || *reduce != "") {
+		fmt.Fprintln(os.Stderr, "-per-task-timeout needs -sequence fib and no -hash-only or -reduce")
+		os.Exit(1)
+	}
+
 	fmt.Printf("Go Version: %s\n", runtime.Version())
 	fmt.Printf("GOMAXPROCS: %d (number of CPUs available)\n", runtime.GOMAXPROCS(0))
 	fmt.Printf("NumCPU: %d\n", runtime.NumCPU())
 
-	fmt.Println("\nNote: Go has no GIL - goroutines execute in true parallelism")
+	if *mulScaling {
+		fmt.Println("\nbig.Int multiplication scaling (operands sized like F(n)):")
+		var indices []int
+		for n := 1000; n <= 1024000; n *= 2 {
+			indices = append(indices, n)
+		}
+		printMulScaling(runMulScaling(indices))
+		return
+	}
+
+	if *binet {
+		fmt.Printf("\nBinet (float64) vs big.Int, n <= %d:\n", maxBinetN)
+		fmt.Printf("%-6s %-12s %-12s %s\n", "n", "binet ns", "big.Int ns", "exact")
+		for n := 10; n <= maxBinetN; n += 10 {
+			v, _ := fibBinet(n)
+			binetNs, bigNs := timeBinet(n, 100000)
+			fmt.Printf("%-6d %-12.1f %-12.1f %t\n", n, binetNs, bigNs, computeFibonacci(n).Uint64() == v)
+		}
+		if _, err := fibBinet(maxBinetN + 1); err != nil {
+			fmt.Printf("n=%d: %v; big.Int fallback gives %s\n", maxBinetN+1, err, fibSmall(maxBinetN+1))
+		}
+		return
+	}
+
+	if *compareReuse {
+		fmt.Println("\nF(n) loop, temp vs prealloc (best of 3):")
+		fmt.Printf("%-8s %-12s %-12s %-9s %-14s %s\n", "n", "temp s", "prealloc s", "speedup", "allocs", "match")
+		ok := true
+		for _, n := range gmpIndices {
+			naive, pre := timeReuse(computeFibonacci, n), timeReuse(computeFibonacciPrealloc, n)
+			match := naive.value.Cmp(pre.value) == 0
+			ok = ok && match
+			fmt.Printf("%-8d %-12.6f %-12.6f %-9s %-14s %t\n", n, naive.seconds, pre.seconds,
+				fmt.Sprintf("%.2fx", naive.seconds/pre.seconds), fmt.Sprintf("%d -> %d", naive.allocs, pre.allocs), match)
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "prealloc loop disagrees with computeFibonacci")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *useGMP {
+		fmt.Printf("\nF(n) via %s vs math/big, decimal string included:\n", gmp.Backend())
+		if !gmp.Available {
+			fmt.Println("(built without -tags gmp: the gmp column is the pure-Go fallback)")
+		}
+		fmt.Printf("%-8s %-12s %-12s %-9s %s\n", "n", "gmp s", "big s", "speedup", "match")
+		ok := true
+		for _, n := range gmpIndices {
+			gmpSeconds, bigSeconds, match := compareGMP(n)
+			ok = ok && match
+			fmt.Printf("%-8d %-12.6f %-12.6f %-9s %t\n", n, gmpSeconds, bigSeconds,
+				fmt.Sprintf("%.0fx", bigSeconds/gmpSeconds), match)
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "GMP and math/big disagree")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *geometric > 0 {
+		indices := geometricIndices(*geometric)
+		if len(indices) < 2 {
+			fmt.Fprintf(os.Stderr, "Invalid -geometric %d: need at least 2000 for two points\n", *geometric)
+			os.Exit(1)
+		}
+		fmt.Printf("\n%s(n) scaling, best of 3:\n", sym)
+		fmt.Printf("%-10s %-12s %s\n", "n", "seconds", "slope")
+		seconds := runGeometric(indices)
+		xs := make([]float64, len(indices))
+		for i, n := range indices {
+			xs[i] = float64(n)
+			slope := ""
+			if i > 0 {
+				slope = fmt.Sprintf("%.2f", logLogSlope(xs[i-1:i+1], seconds[i-1:i+1]))
+			}
+			fmt.Printf("%-10d %-12.6f %s\n", n, seconds[i], slope)
+		}
+		// n additions of numbers up to ~0.69n bits each: expect about 2.
+		fmt.Printf("fitted exponent: %.2f (time ~ n^k)\n", logLogSlope(xs, seconds))
+		return
+	}
+
+	if *priority != 0 {
+		if err := sysx.SetNice(*priority); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not set niceness %d: %v (continuing at default priority)\n", *priority, err)
+		}
+		if nice, err := sysx.Nice(); err == nil {
+			fmt.Printf("Niceness: %d\n", nice)
+		}
+	}
+
+	verbosity.Notef("\nNote: Go has no GIL - goroutines execute in true parallelism\n")
 
 	nums := make([]int, 10)
 	for i := range nums {
 		nums[i] = 300000
 	}
+	if *indices != "" {
+		var err error
+		if nums, err = parseIndices(*indices); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -indices: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *verify {
+		checked, failed := 0, 0
+		seen := map[int]bool{}
+		for n := 1; n <= 30; n++ {
+			seen[n] = true
+		}
+		for _, n := range nums {
+			if n >= 1 {
+				seen[n] = true
+			}
+		}
+		for n := range seen {
+			checked++
+			if !lucasIdentityHolds(n) {
+				fmt.Printf("identity FAILED at n=%d\n", n)
+				failed++
+			}
+		}
+		fmt.Printf("\nL(n) = F(n-1) + F(n+1): %d/%d indices ok\n", checked-failed, checked)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	}
+
+	if *identity != "" {
+		fmt.Println()
+		failed := 0
+		for _, n := range nums {
+			var ok bool
+			measureExecutionTime(fmt.Sprintf("%s identity at n=%d", *identity, n), func() {
+				ok = fibIdentityHolds(*identity, n)
+			})
+			if !ok {
+				fmt.Printf("identity FAILED at n=%d\n", n)
+				failed++
+			}
+		}
+		fmt.Printf("%s identity: %d/%d indices ok\n", *identity, len(nums)-failed, len(nums))
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	stopTrace, err := tracing.Start(*tracePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Trace error: %v\n", err)
+		os.Exit(1)
+	}
 
 	fmt.Println("\nRunning Single-Threaded Task:")
-	measureExecutionTime("runSingleThreaded", func() {
-		runSingleThreaded(nums)
+	var singleTimedOut []int
+	stopSampling := startThreadSampling(*threads)
+	var single time.Duration
+	singleAllocs := measureAllocs(func() {
+		single = measureExecutionTime("runSingleThreaded", func() {
+			if *perTaskTimeout > 0 {
+				_, singleTimedOut = runSingleThreadedTimeout(nums, *perTaskTimeout)
+			} else {
+				runSingleThreaded(nums)
+			}
+		})
 	})
+	singleThreads, singleGoroutines := stopSampling()
+	if *perTaskTimeout > 0 {
+		fmt.Printf("timed out: %d %v\n", len(singleTimedOut), singleTimedOut)
+	}
 
 	fmt.Println("\nRunning Multi-Threaded Task (Goroutines):")
-	measureExecutionTime("runMultiThreaded", func() {
-		runMultiThreaded(nums)
+	var collected []reducedTerm
+	var completed, timedOut []int
+	stopSampling = startThreadSampling(*threads)
+	var multi time.Duration
+	multiAllocs := measureAllocs(func() {
+		multi = measureExecutionTime("runMultiThreaded", func() {
+			switch {
+			case *reduce != "":
+				collected = runMultiThreadedCollect(nums, *reduce)
+			case *perTaskTimeout > 0:
+				completed, timedOut = runMultiThreadedTimeout(nums, *perTaskTimeout)
+			default:
+				runMultiThreaded(nums)
+			}
+		})
 	})
+	multiThreads, multiGoroutines := stopSampling()
+	switch *reduce {
+	case "":
+	case reduceHash:
+		fmt.Printf("%s(%d) fnv64a: %016x\n", sym, nums[0], collected[0].value)
+	default:
+		fmt.Printf("%s(%d) %s: %s\n", sym, nums[0], *reduce, peekDigits(collected[0].String(), 20))
+	}
+	if *reduce != "" {
+		runtime.GC()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		fmt.Printf("heap with %d collected results: %.1fKiB\n", len(collected), float64(ms.HeapAlloc)/1024)
+		runtime.KeepAlive(collected)
+	}
+	if *threads {
+		fmt.Printf("\npeak OS threads: single %d, multi %d\n", singleThreads, multiThreads)
+		fmt.Printf("peak goroutines: single %d, multi %d\n", singleGoroutines, multiGoroutines)
+	}
+	if *perTaskTimeout > 0 {
+		fmt.Printf("completed within %s: %d %v\n", *perTaskTimeout, len(completed), completed)
+		fmt.Printf("timed out: %d %v\n", len(timedOut), timedOut)
+	}
+
+	var unlimited, limited memLimitRun
+	if *softMemLimit > 0 {
+		fmt.Printf("\nSoft memory limit %.1fMiB (multi-threaded, keeping only hashes):\n", float64(*softMemLimit)/(1024*1024))
+		unlimited = runHashedBatch(nums, math.MaxInt64)
+		limited = runHashedBatch(nums, *softMemLimit)
+		fmt.Printf("  no limit: %.4f seconds, %d GC cycles\n", unlimited.elapsed.Seconds(), unlimited.gcCycles)
+		fmt.Printf("  limited:  %.4f seconds, %d GC cycles (%.2fx the time)\n",
+			limited.elapsed.Seconds(), limited.gcCycles, limited.elapsed.Seconds()/unlimited.elapsed.Seconds())
+		if !sameHashes(unlimited.hashes, limited.hashes) {
+			fmt.Fprintln(os.Stderr, "Results differ under the memory limit")
+			os.Exit(1)
+		}
+	}
 
-	fmt.Println("\nNote: Go goroutines already provide true parallelism (no separate multiprocessing needed)")
+	if err := stopTrace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Trace error: %v\n", err)
+		os.Exit(1)
+	}
+	if *tracePath != "" {
+		fmt.Printf("Wrote trace to %s\n", *tracePath)
+	}
+
+	if *indices != "" && *seq == "fib" {
+		batchNums := nums
+		if *perTaskTimeout > 0 {
+			// Indices that timed out would make the batch just as slow.
+			batchNums = completed
+		}
+		var batch map[int]*big.Int
+		measureExecutionTime("computeFibonacciBatch", func() {
+			batch = computeFibonacciBatch(batchNums)
+		})
+		keys := make([]int, 0, len(batch))
+		for n := range batch {
+			keys = append(keys, n)
+		}
+		sort.Ints(keys)
+		for _, n := range keys {
+			fmt.Printf("  F(%d): %d bits, %d decimal digits\n", n, batch[n].BitLen(), fibDigitCount(n))
+		}
+	}
+
+	if *seq == "fib" {
+		verbosity.Detailf("\nF(%d) has %d decimal digits (closed form, no big.Int)\n", nums[0], fibDigitCount(nums[0]))
+	}
+
+	if *peek > 0 {
+		fmt.Printf("\n%s(%d): %s\n", sym, nums[0], peekDigits(computeTerm(nums[0]).Text(10), *peek))
+	}
+
+	if *printResult || *digitsOnly {
+		out, _ := formatFibonacci(nums[0], *base, *digitsOnly)
+		if *digitsOnly {
+			fmt.Printf("\n%s(%d) has %s digits in base %d\n", sym, nums[0], out, *base)
+		} else {
+			fmt.Printf("\n%s(%d) in base %d:\n%s\n", sym, nums[0], *base, out)
+		}
+	}
+
+	verbosity.Notef("\nNote: Go goroutines already provide true parallelism (no separate multiprocessing needed)\n")
+
+	if outputs.Enabled() {
+		singleMetrics := singleAllocs.metrics(map[string]float64{"seconds": single.Seconds()})
+		multiMetrics := multiAllocs.metrics(map[string]float64{"seconds": multi.Seconds()})
+		if *perTaskTimeout > 0 {
+			singleMetrics["timed_out"] = float64(len(singleTimedOut))
+			multiMetrics["timed_out"] = float64(len(timedOut))
+		}
+		if *threads {
+			singleMetrics["peak_threads"] = float64(singleThreads)
+			singleMetrics["peak_goroutines"] = float64(singleGoroutines)
+			multiMetrics["peak_threads"] = float64(multiThreads)
+			multiMetrics["peak_goroutines"] = float64(multiGoroutines)
+		}
+		results := []report.Result{
+			{Benchmark: benchName, Name: "single_threaded", Metrics: singleMetrics},
+			{Benchmark: benchName, Name: "multi_threaded", Metrics: multiMetrics},
+		}
+		if *softMemLimit > 0 {
+			results = append(results, report.Result{Benchmark: benchName, Name: "multi_threaded_memlimit", Metrics: limited.allocs.metrics(map[string]float64{
+				"limit_bytes":         float64(*softMemLimit),
+				"seconds":             limited.elapsed.Seconds(),
+				"gc_cycles":           float64(limited.gcCycles),
+				"unlimited_seconds":   unlimited.elapsed.Seconds(),
+				"unlimited_gc_cycles": float64(unlimited.gcCycles),
+			})})
+		}
+		outputs.WriteOrExit(results...)
+	}
 }
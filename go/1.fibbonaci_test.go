@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"math/bits"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestFibDigitCount checks the closed form against the decimal length of
+// every F(n) up to 10000, which covers n = 0, 1 and 2 and the small indices
+// where the uncorrected approximation lands on the wrong side of a power of
+// ten.
+func TestFibDigitCount(t *testing.T) {
+	a, b := big.NewInt(0), big.NewInt(1)
+	for n := 0; n <= 10000; n++ {
+		if got, want := fibDigitCount(n), len(a.Text(10)); got != want {
+			t.Errorf("fibDigitCount(%d) = %d, want %d", n, got, want)
+		}
+		a.Add(a, b)
+		a, b = b, a
+	}
+	for _, n := range []int{0, 1, 7, 12, 45, 1000} {
+		if got, want := fibDigitCount(n), len(computeFibonacci(n).Text(10)); got != want {
+			t.Errorf("fibDigitCount(%d) = %d, want len(computeFibonacci(%d).Text(10)) = %d", n, got, n, want)
+		}
+	}
+}
+
+func TestFormatFibonacci(t *testing.T) {
+	for _, tc := range []struct {
+		base       int
+		digitsOnly bool
+		want       string
+	}{
+		{10, false, "55"},
+		{2, false, "110111"},
+		{16, false, "37"},
+		{10, true, "2"},
+		{2, true, "6"},
+	} {
+		got, err := formatFibonacci(10, tc.base, tc.digitsOnly)
+		if err != nil {
+			t.Fatalf("formatFibonacci(10, %d, %v): %v", tc.base, tc.digitsOnly, err)
+		}
+		if got != tc.want {
+			t.Errorf("formatFibonacci(10, %d, %v) = %q, want %q", tc.base, tc.digitsOnly, got, tc.want)
+		}
+	}
+	for _, base := range []int{1, 37} {
+		if _, err := formatFibonacci(10, base, false); err == nil {
+			t.Errorf("formatFibonacci(10, %d, false) accepted an out-of-range base", base)
+		}
+	}
+}
+
+// TestFibHashStable pins fibHash to fixed values, so a change in how F(n) is
+// chunked or hashed shows up as a failure rather than as a silent change in
+// reported hashes between runs. F(100000) spans several hash chunks.
+func TestFibHashStable(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want uint64
+	}{
+		{10, 0xaf63aa4c86019796},
+		{1000, 0xfb12ae4e8f257c6c},
+		{100000, 0xfa9387967ff09d63},
+	} {
+		for run := 0; run < 2; run++ {
+			if got := fibHash(tc.n); got != tc.want {
+				t.Errorf("run %d: fibHash(%d) = %#016x, want %#016x", run, tc.n, got, tc.want)
+			}
+		}
+	}
+
+	nums := []int{10, 1000, 10, 100000}
+	hashed := runMultiThreadedCollect(nums, reduceHash)
+	for i, n := range nums {
+		if hashed[i].value != fibHash(n) {
+			t.Errorf("collected hash of F(%d) = %#016x, want fibHash = %#016x", n, hashed[i].value, fibHash(n))
+		}
+	}
+}
+
+// TestMulScalingMonotonic uses indices a factor of four apart, far enough
+// that each multiplication is clearly slower than the last despite timer
+// noise.
+func TestMulScalingMonotonic(t *testing.T) {
+	timings := runMulScaling([]int{10_000, 40_000, 160_000, 640_000})
+	for i := 1; i < len(timings); i++ {
+		prev, cur := timings[i-1], timings[i]
+		if cur.bits <= prev.bits {
+			t.Errorf("F(%d) has %d bits, not more than F(%d)'s %d", cur.n, cur.bits, prev.n, prev.bits)
+		}
+		if cur.ns < prev.ns {
+			t.Errorf("%d-bit multiply took %.0fns, less than %d-bit's %.0fns", cur.bits, cur.ns, prev.bits, prev.ns)
+		}
+	}
+}
+
+func TestLogLogSlope(t *testing.T) {
+	xs := []float64{1, 2, 4, 8, 16}
+	ys := make([]float64, len(xs))
+	for i, x := range xs {
+		ys[i] = 3 * math.Pow(x, 1.58)
+	}
+	if k := logLogSlope(xs, ys); math.Abs(k-1.58) > 1e-9 {
+		t.Errorf("slope = %v, want 1.58", k)
+	}
+	if k := logLogSlope(xs[:1], ys[:1]); !math.IsNaN(k) {
+		t.Errorf("slope of one point = %v, want NaN", k)
+	}
+}
+
+func TestComputeFibonacciBatch(t *testing.T) {
+	got := computeFibonacciBatch([]int{10, 0, 300, 10, 1, 300})
+	want := map[int]string{0: "0", 1: "1", 10: "55", 300: computeFibonacci(300).String()}
+	if len(got) != len(want) {
+		t.Errorf("got %d keys, want %d distinct indices", len(got), len(want))
+	}
+	for n, w := range want {
+		if v, ok := got[n]; !ok || v.String() != w {
+			t.Errorf("batch[%d] = %v, want %s", n, v, w)
+		}
+	}
+	if got[10] == got[300] {
+		t.Error("batch entries share one big.Int")
+	}
+}
+
+func TestParseIndices(t *testing.T) {
+	nums, err := parseIndices(" 10,20, 300000 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nums) != 3 || nums[0] != 10 || nums[1] != 20 || nums[2] != 300000 {
+		t.Errorf("parseIndices = %v, want [10 20 300000]", nums)
+	}
+	for _, bad := range []string{"-1", "x", "1,,y"} {
+		if _, err := parseIndices(bad); err == nil {
+			t.Errorf("parseIndices(%q) accepted bad input", bad)
+		}
+	}
+}
+
+func TestComputeLucas(t *testing.T) {
+	for n, want := range map[int]int64{0: 2, 1: 1, 2: 3, 5: 11, 10: 123} {
+		if got := computeLucas(n); got.Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("L(%d) = %s, want %d", n, got, want)
+		}
+	}
+	for _, n := range []int{1, 2, 7, 50, 1000} {
+		if !lucasIdentityHolds(n) {
+			t.Errorf("L(%d) != F(%d) + F(%d)", n, n-1, n+1)
+		}
+	}
+}
+
+func TestPerTaskTimeout(t *testing.T) {
+	nums := []int{10, 50_000_000, 100}
+	for name, run := range map[string]func([]int, time.Duration) ([]int, []int){
+		"single": runSingleThreadedTimeout,
+		"multi":  runMultiThreadedTimeout,
+	} {
+		completed, timedOut := run(nums, 20*time.Millisecond)
+		if !slices.Equal(completed, []int{10, 100}) || !slices.Equal(timedOut, []int{50_000_000}) {
+			t.Errorf("%s: completed %v, timed out %v; want [10 100] and [50000000]", name, completed, timedOut)
+		}
+	}
+}
+
+func TestPeekDigits(t *testing.T) {
+	if got := peekDigits(computeFibonacci(100).Text(10), 3); got != "354...075" {
+		t.Errorf("peek of F(100) = %q, want %q", got, "354...075")
+	}
+	for _, tt := range []struct {
+		s    string
+		k    int
+		want string
+	}{
+		{"123456", 3, "123456"},
+		{"1234567", 3, "123...567"},
+		{"55", 3, "55"},
+	} {
+		if got := peekDigits(tt.s, tt.k); got != tt.want {
+			t.Errorf("peekDigits(%q, %d) = %q, want %q", tt.s, tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestFibBinet(t *testing.T) {
+	for n := 0; n <= maxBinetN; n++ {
+		got, err := fibBinet(n)
+		if err != nil {
+			t.Fatalf("fibBinet(%d): %v", n, err)
+		}
+		if want := computeFibonacci(n); !want.IsUint64() || got != want.Uint64() {
+			t.Errorf("fibBinet(%d) = %d, want %s", n, got, want)
+		}
+	}
+	for _, n := range []int{-1, maxBinetN + 1, 78, 100} {
+		if _, err := fibBinet(n); err == nil {
+			t.Errorf("fibBinet(%d) returned no error", n)
+		}
+	}
+	for _, n := range []int{0, 1, maxBinetN, maxBinetN + 1, 78, 100, 1000} {
+		if got, want := fibSmall(n), computeFibonacci(n); got.Cmp(want) != 0 {
+			t.Errorf("fibSmall(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestFibIdentities(t *testing.T) {
+	indices := []int{1000, 2500, 5000}
+	for n := 0; n <= 100; n++ {
+		indices = append(indices, n)
+	}
+	for _, identity := range []string{identitySum, identitySumSq} {
+		for _, n := range indices {
+			if !fibIdentityHolds(identity, n) {
+				t.Errorf("%s identity fails for n=%d", identity, n)
+			}
+		}
+	}
+}
+
+func TestReduceTerm(t *testing.T) {
+	f100 := computeFibonacci(100) // 354224848179261915075
+	for _, tt := range []struct {
+		mode string
+		want string
+	}{
+		{reduceNone, "354224848179261915075"},
+		{reduceDigits, "21"},
+		{reduceHash, "4629372380068054968"},     // 0x403ed5a6f6f607b8, FNV-1a of the 9 big-endian bytes
+		{reduceLastWord, "3736710778780434371"}, // F(100) mod 2^64
+	} {
+		if got := reduceTerm(f100, tt.mode).String(); got != tt.want {
+			t.Errorf("reduceTerm(F(100), %s) = %s, want %s", tt.mode, got, tt.want)
+		}
+	}
+	if got := runMultiThreadedCollect([]int{100}, reduceLastWord); got[0].String() != "3736710778780434371" {
+		t.Errorf("collected lastword of F(100) = %s", got[0])
+	}
+}
+
+func TestSoftMemoryLimit(t *testing.T) {
+	nums := slices.Repeat([]int{5000}, 200)
+	unlimited := runHashedBatch(nums, math.MaxInt64)
+	// 1 byte is below any live heap, so the GC runs about as often as it can.
+	limited := runHashedBatch(nums, 1)
+	if !sameHashes(unlimited.hashes, limited.hashes) {
+		t.Error("results differ under the memory limit")
+	}
+	if want := hashTerm(computeFibonacci(5000)); limited.hashes[0].value != want {
+		t.Errorf("limited F(5000) hash %d, want %d", limited.hashes[0].value, want)
+	}
+	if limited.gcCycles <= unlimited.gcCycles {
+		t.Errorf("%d GC cycles under a 1-byte limit, %d without; want more under the limit", limited.gcCycles, unlimited.gcCycles)
+	}
+}
+
+func TestGeometricScaling(t *testing.T) {
+	indices := geometricIndices(10000)
+	if want := []int{1000, 2000, 4000, 8000}; !slices.Equal(indices, want) {
+		t.Fatalf("geometricIndices(10000) = %v, want %v", indices, want)
+	}
+
+	// Quadratic timings with a constant and ±5% noise, as real runs have.
+	noise := []float64{1.05, 0.95, 1.02, 0.98}
+	xs := make([]float64, len(indices))
+	ys := make([]float64, len(indices))
+	for i, n := range indices {
+		xs[i] = float64(n)
+		ys[i] = (2e-9*xs[i]*xs[i] + 1e-4) * noise[i]
+	}
+	if k := logLogSlope(xs, ys); math.Abs(k-2) > 0.1 {
+		t.Errorf("slope of O(n²) timings = %.3f, want about 2", k)
+	}
+}
+
+func TestPreallocMatchesNaive(t *testing.T) {
+	// Every n up to 2000, then every n beyond that where F(n) fills a
+	// 64th word exactly, and the n after it, where an off-by-one in the size
+	// estimate would show, on the way to 100000.
+	var nums []int
+	for n := 0; n <= 100_000; n++ {
+		switch {
+		case n <= 2000, n == 100_000:
+			nums = append(nums, n)
+		case fibBitLength(n)%(64*bits.UintSize) == 0:
+			nums = append(nums, n, n+1)
+		}
+	}
+
+	want := computeFibonacciBatch(nums)
+	for _, n := range nums {
+		got := computeFibonacciPrealloc(n)
+		if got.Cmp(want[n]) != 0 {
+			t.Fatalf("prealloc F(%d) differs from the naive loop", n)
+		}
+		// The words allocated up front must have been enough: a loop that
+		// had to grow them would have a larger capacity.
+		if words := fibBitLength(n)/bits.UintSize + 2; cap(got.Bits()) != words {
+			t.Errorf("F(%d) ended with capacity for %d words, allocated %d", n, cap(got.Bits()), words)
+		}
+	}
+
+	// -per-task-timeout runs the prealloc loop too when -reuse prealloc.
+	got, err := computeFibonacciPreallocContext(context.Background(), 100_000)
+	if err != nil || got.Cmp(want[100_000]) != 0 {
+		t.Errorf("prealloc context F(100000): err %v, match %v", err, err == nil && got.Cmp(want[100_000]) == 0)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := computeFibonacciPreallocContext(ctx, 100_000); err != context.Canceled {
+		t.Errorf("cancelled prealloc context: err %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestMeasureAllocs checks that a run's allocations land in the metrics
+// -benchformat prints as B/op and allocs/op.
+func TestMeasureAllocs(t *testing.T) {
+	var sink []byte
+	a := measureAllocs(func() { sink = make([]byte, 1<<20) })
+	_ = sink
+	m := a.metrics(map[string]float64{"seconds": 1})
+	if m["allocs_per_op"] < 1 {
+		t.Errorf("allocs_per_op = %v, want at least 1", m["allocs_per_op"])
+	}
+	if m["bytes_per_op"] < 1<<20 {
+		t.Errorf("bytes_per_op = %v, want at least %d", m["bytes_per_op"], 1<<20)
+	}
+	if m["seconds"] != 1 {
+		t.Error("metrics dropped an existing entry")
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestNoDroppedMessages(t *testing.T) {
+	const n = 10000
+	for _, tc := range []struct{ producers, consumers, capacity int }{
+		{1, 1, 0},
+		{4, 4, 0},
+		{4, 2, 64},
+		{3, 5, 1},
+	} {
+		if got := runChannel(n, tc.producers, tc.consumers, tc.capacity); got != n {
+			t.Errorf("runChannel(%d, %d, %d, %d) received %d", n, tc.producers, tc.consumers, tc.capacity, got)
+		}
+		if got := runMutexQueue(n, tc.producers, tc.consumers); got != n {
+			t.Errorf("runMutexQueue(%d, %d, %d) received %d", n, tc.producers, tc.consumers, got)
+		}
+	}
+}
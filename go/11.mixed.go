@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/fib"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/verbosity"
+)
+
+func computeFibonacci(n int) *big.Int {
+	a, b, temp := big.NewInt(0), big.NewInt(1), new(big.Int)
+	for i := 0; i < n; i++ {
+		fib.Step(a, b, temp)
+	}
+	return a
+}
+
+// newStubServer answers every request after ioDelay, standing in for a
+// downstream service the task has to wait on.
+func newStubServer(ioDelay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(ioDelay)
+		w.Write([]byte("ok"))
+	}))
+}
+
+type taskResult struct {
+	cpu time.Duration
+	io  time.Duration
+	err error
+}
+
+// mixedTask does fibN additions of CPU work, then one HTTP call to url.
+func mixedTask(client *http.Client, url string, fibN int) taskResult {
+	var res taskResult
+
+	start := time.Now()
+	computeFibonacci(fibN)
+	res.cpu = time.Since(start)
+
+	start = time.Now()
+	resp, err := client.Get(url)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	res.io = time.Since(start)
+
+	return res
+}
+
+type mixedSummary struct {
+	completed int
+	failed    int
+	cpuTotal  time.Duration
+	ioTotal   time.Duration
+	wall      time.Duration
+}
+
+func summarize(results []taskResult, wall time.Duration) mixedSummary {
+	s := mixedSummary{wall: wall}
+	for _, r := range results {
+		if r.err != nil {
+			s.failed++
+			continue
+		}
+		s.completed++
+		s.cpuTotal += r.cpu
+		s.ioTotal += r.io
+	}
+	return s
+}
+
+func runSequential(client *http.Client, url string, numTasks, fibN int) mixedSummary {
+	results := make([]taskResult, numTasks)
+	start := time.Now()
+	for i := range results {
+		results[i] = mixedTask(client, url, fibN)
+	}
+	return summarize(results, time.Since(start))
+}
+
+func runGoroutines(client *http.Client, url string, numTasks, fibN int) mixedSummary {
+	results := make([]taskResult, numTasks)
+	var wg sync.WaitGroup
+	wg.Add(numTasks)
+
+	start := time.Now()
+	for i := range results {
+		go func(i int) {
+			defer wg.Done()
+			results[i] = mixedTask(client, url, fibN)
+		}(i)
+	}
+	wg.Wait()
+	return summarize(results, time.Since(start))
+}
+
+func (s mixedSummary) metrics() map[string]float64 {
+	return map[string]float64{
+		"completed":   float64(s.completed),
+		"failed":      float64(s.failed),
+		"seconds":     s.wall.Seconds(),
+		"cpu_seconds": s.cpuTotal.Seconds(),
+		"io_seconds":  s.ioTotal.Seconds(),
+	}
+}
+
+func printSummary(name string, s mixedSummary) {
+	fmt.Printf("%s:\n", name)
+	fmt.Printf("  tasks: %d completed, %d failed\n", s.completed, s.failed)
+	fmt.Printf("  wall: %dms\n", s.wall.Milliseconds())
+	fmt.Printf("  cpu phase (sum): %dms\n", s.cpuTotal.Milliseconds())
+	fmt.Printf("  io phase (sum): %dms\n", s.ioTotal.Milliseconds())
+	if s.wall > 0 {
+		fmt.Printf("  overlap: %.2fx\n", float64(s.cpuTotal+s.ioTotal)/float64(s.wall))
+	}
+}
+
+func main() {
+	numTasks := flag.Int("tasks", 50, "Number of tasks")
+	fibN := flag.Int("fib-n", 20000, "Fibonacci index computed per task (CPU work)")
+	ioDelay := flag.Duration("io-delay", 20*time.Millisecond, "Stub server latency per call (IO work)")
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+
+	fmt.Printf("Go Version: %s\n", runtime.Version())
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Printf("tasks=%d fib_n=%d io_delay=%s\n\n", *numTasks, *fibN, *ioDelay)
+
+	stub := newStubServer(*ioDelay)
+	defer stub.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{MaxIdleConnsPerHost: *numTasks},
+		Timeout:   10 * time.Second,
+	}
+
+	seq := runSequential(client, stub.URL, *numTasks, *fibN)
+	printSummary("sequential", seq)
+	fmt.Println()
+	par := runGoroutines(client, stub.URL, *numTasks, *fibN)
+	printSummary("goroutines", par)
+
+	verbosity.Notef("\nNote: overlap > 1 means CPU and IO phases of different tasks ran at the same time;\n")
+	verbosity.Notef("while one goroutine waits on the network, the scheduler runs another's computation.\n")
+
+	results := []report.Result{
+		{Benchmark: "mixed", Name: "sequential", Metrics: seq.metrics()},
+		{Benchmark: "mixed", Name: "goroutines", Metrics: par.metrics()},
+	}
+	outputs.WriteOrExit(results...)
+}
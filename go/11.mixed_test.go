@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMixedTasksCompleteBothPhases(t *testing.T) {
+	stub := newStubServer(5 * time.Millisecond)
+	defer stub.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	res := mixedTask(client, stub.URL, 1000)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.cpu <= 0 || res.io < 5*time.Millisecond {
+		t.Errorf("task phases: cpu %s, io %s; want cpu > 0 and io >= the stub delay", res.cpu, res.io)
+	}
+
+	const numTasks = 20
+	for name, run := range map[string]func(*http.Client, string, int, int) mixedSummary{
+		"sequential": runSequential,
+		"goroutines": runGoroutines,
+	} {
+		s := run(client, stub.URL, numTasks, 1000)
+		if s.completed != numTasks || s.failed != 0 {
+			t.Errorf("%s: %d completed, %d failed; want %d, 0", name, s.completed, s.failed, numTasks)
+		}
+		if s.cpuTotal <= 0 || s.ioTotal < numTasks*5*time.Millisecond {
+			t.Errorf("%s: cpu %s, io %s; want both phases counted for every task", name, s.cpuTotal, s.ioTotal)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestCountersLoseNoUpdates(t *testing.T) {
+	const goroutines, iterations = 16, 5000
+	for name, count := range map[string]func(int, int) int64{
+		"mutex":   countMutex,
+		"atomic":  countAtomic,
+		"channel": countChannel,
+	} {
+		if got := count(goroutines, iterations); got != goroutines*iterations {
+			t.Errorf("%s: final count %d, want %d", name, got, goroutines*iterations)
+		}
+	}
+}
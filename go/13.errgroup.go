@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/verbosity"
+	"golang.org/x/sync/errgroup"
+)
+
+func timed(fn func()) time.Duration {
+	start := time.Now()
+	fn()
+	return time.Since(start)
+}
+
+// newStubServer answers /ok after delay and /fail immediately with a 500,
+// standing in for a downstream service where one call goes wrong.
+func newStubServer(delay time.Duration) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			w.Write([]byte("ok"))
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	return httptest.NewServer(mux)
+}
+
+// batchOutcome counts how a batch of tasks ended.
+type batchOutcome struct {
+	completed int64 // tasks that got a successful response
+	failed    int64 // tasks that got an error response
+	canceled  int64 // tasks cut short because another task had failed
+	err       error // the error the coordinator returned
+	wall      time.Duration
+}
+
+// fetchTask GETs url and fails on any non-200 status.
+func fetchTask(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// taskURL returns the URL task i should fetch: /fail for failAt, /ok otherwise.
+func taskURL(base string, i, failAt int) string {
+	if i == failAt {
+		return base + "/fail"
+	}
+	return base + "/ok"
+}
+
+func (o *batchOutcome) record(ctx context.Context, err error) {
+	switch {
+	case err == nil:
+		atomic.AddInt64(&o.completed, 1)
+	case ctx.Err() != nil:
+		atomic.AddInt64(&o.canceled, 1)
+	default:
+		atomic.AddInt64(&o.failed, 1)
+	}
+}
+
+// runWaitGroup coordinates the batch by hand: every task runs to the end,
+// errors are collected under a mutex, and the caller sees all of them.
+func runWaitGroup(client *http.Client, base string, numTasks, failAt int) batchOutcome {
+	var out batchOutcome
+	var mu sync.Mutex
+	var errs []error
+
+	out.wall = timed(func() {
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		wg.Add(numTasks)
+		for i := 0; i < numTasks; i++ {
+			go func(i int) {
+				defer wg.Done()
+				err := fetchTask(ctx, client, taskURL(base, i, failAt))
+				out.record(ctx, err)
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+	out.err = errors.Join(errs...)
+	return out
+}
+
+// runErrgroup uses errgroup.WithContext: the first failure cancels the
+// shared context, so tasks still waiting on the stub give up immediately.
+func runErrgroup(client *http.Client, base string, numTasks, failAt int) batchOutcome {
+	var out batchOutcome
+	out.wall = timed(func() {
+		g, ctx := errgroup.WithContext(context.Background())
+		for i := 0; i < numTasks; i++ {
+			g.Go(func() error {
+				err := fetchTask(ctx, client, taskURL(base, i, failAt))
+				out.record(ctx, err)
+				return err
+			})
+		}
+		out.err = g.Wait()
+	})
+	return out
+}
+
+// coordinationOverhead times n no-op tasks under each coordinator, so the
+// bookkeeping cost is visible without any I/O.
+func coordinationOverhead(n int) (wg, eg time.Duration) {
+	wg = timed(func() {
+		var w sync.WaitGroup
+		w.Add(n)
+		for i := 0; i < n; i++ {
+			go func() { w.Done() }()
+		}
+		w.Wait()
+	})
+	eg = timed(func() {
+		var g errgroup.Group
+		for i := 0; i < n; i++ {
+			g.Go(func() error { return nil })
+		}
+		g.Wait()
+	})
+	return wg, eg
+}
+
+func (o batchOutcome) metrics() map[string]float64 {
+	return map[string]float64{
+		"seconds":   o.wall.Seconds(),
+		"completed": float64(o.completed),
+		"failed":    float64(o.failed),
+		"canceled":  float64(o.canceled),
+	}
+}
+
+func printOutcome(name string, o batchOutcome) {
+	fmt.Printf("%s:\n", name)
+	fmt.Printf("  wall: %dms\n", o.wall.Milliseconds())
+	fmt.Printf("  tasks: %d completed, %d failed, %d canceled\n", o.completed, o.failed, o.canceled)
+	if o.err != nil {
+		fmt.Printf("  error: %v\n", o.err)
+	}
+}
+
+func main() {
+	numTasks := flag.Int("tasks", 50, "Number of fetch tasks per batch")
+	delay := flag.Duration("delay", 100*time.Millisecond, "Stub server latency for successful fetches")
+	failAt := flag.Int("fail-at", 0, "Index of the task that fails (-1 = none)")
+	overheadN := flag.Int("overhead-n", 100000, "No-op tasks used to time coordination overhead")
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+
+	fmt.Printf("Go Version: %s\n", runtime.Version())
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Printf("tasks=%d delay=%s fail_at=%d\n\n", *numTasks, *delay, *failAt)
+
+	stub := newStubServer(*delay)
+	defer stub.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{MaxIdleConnsPerHost: *numTasks},
+		Timeout:   10 * time.Second,
+	}
+
+	wg := runWaitGroup(client, stub.URL, *numTasks, *failAt)
+	printOutcome("waitgroup", wg)
+	fmt.Println()
+	eg := runErrgroup(client, stub.URL, *numTasks, *failAt)
+	printOutcome("errgroup", eg)
+
+	wgOverhead, egOverhead := coordinationOverhead(*overheadN)
+	fmt.Printf("\noverhead (%d no-op tasks):\n", *overheadN)
+	fmt.Printf("  waitgroup: %dus\n", wgOverhead.Microseconds())
+	fmt.Printf("  errgroup: %dus\n", egOverhead.Microseconds())
+
+	verbosity.Notef("\nNote: a WaitGroup waits for every task and leaves error handling to you;\n")
+	verbosity.Notef("errgroup returns the first error and, with WithContext, cancels the rest.\n")
+
+	results := []report.Result{
+		{Benchmark: "errgroup", Name: "waitgroup", Metrics: wg.metrics()},
+		{Benchmark: "errgroup", Name: "errgroup", Metrics: eg.metrics()},
+		{Benchmark: "errgroup", Name: "overhead", Metrics: map[string]float64{
+			"waitgroup_seconds": wgOverhead.Seconds(),
+			"errgroup_seconds":  egOverhead.Seconds(),
+		}},
+	}
+	outputs.WriteOrExit(results...)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestErrgroupCancelsRemainingTasks(t *testing.T) {
+	const numTasks = 10
+	stub := newStubServer(5 * time.Second)
+	defer stub.Close()
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: numTasks}}
+
+	out := runErrgroup(client, stub.URL, numTasks, 0)
+	if out.err == nil {
+		t.Fatal("errgroup returned no error for a batch with a failing task")
+	}
+	if out.failed != 1 || out.completed != 0 || out.canceled != numTasks-1 {
+		t.Errorf("%d completed, %d failed, %d canceled; want 0, 1, %d", out.completed, out.failed, out.canceled, numTasks-1)
+	}
+	if out.wall >= time.Second {
+		t.Errorf("batch took %s; the failure should have cut the 5s fetches short", out.wall)
+	}
+}
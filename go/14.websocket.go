@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/verbosity"
+	"golang.org/x/net/websocket"
+)
+
+// echoHandler sends every message it receives straight back.
+func echoHandler(ws *websocket.Conn) {
+	var msg []byte
+	for {
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+		if err := websocket.Message.Send(ws, msg); err != nil {
+			return
+		}
+	}
+}
+
+func newEchoServer() *httptest.Server {
+	return httptest.NewServer(websocket.Handler(echoHandler))
+}
+
+type echoResult struct {
+	connections int
+	messages    int
+	mismatches  int // echoes that didn't match what was sent
+	errors      int // connections that failed before sending all messages
+	elapsed     time.Duration
+	latencies   []time.Duration // sorted ascending
+}
+
+func (r echoResult) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	idx := int(p / 100 * float64(len(r.latencies)-1))
+	return r.latencies[idx]
+}
+
+func (r echoResult) msgsPerSecond() float64 {
+	return float64(len(r.latencies)) / r.elapsed.Seconds()
+}
+
+func (r echoResult) metrics() map[string]float64 {
+	return map[string]float64{
+		"connections": float64(r.connections),
+		"messages":    float64(len(r.latencies)),
+		"errors":      float64(r.errors),
+		"mismatches":  float64(r.mismatches),
+		"seconds":     r.elapsed.Seconds(),
+		"msgs_per_s":  r.msgsPerSecond(),
+		"p50_ms":      float64(r.percentile(50).Microseconds()) / 1000,
+		"p99_ms":      float64(r.percentile(99).Microseconds()) / 1000,
+	}
+}
+
+// echoClient sends n messages of size bytes over one connection, waiting for
+// each echo before the next send, and returns every round-trip time.
+func echoClient(wsURL, origin string, n, size int) (latencies []time.Duration, mismatches int, err error) {
+	ws, err := websocket.Dial(wsURL, "", origin)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer ws.Close()
+
+	payload := bytes.Repeat([]byte{'x'}, size)
+	var reply []byte
+	latencies = make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		if err := websocket.Message.Send(ws, payload); err != nil {
+			return latencies, mismatches, err
+		}
+		if err := websocket.Message.Receive(ws, &reply); err != nil {
+			return latencies, mismatches, err
+		}
+		latencies = append(latencies, time.Since(start))
+		if !bytes.Equal(reply, payload) {
+			mismatches++
+		}
+	}
+	return latencies, mismatches, nil
+}
+
+// runEcho opens concurrency connections to srv and runs echoClient on each.
+func runEcho(srv *httptest.Server, concurrency, n, size int) echoResult {
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	res := echoResult{connections: concurrency, messages: concurrency * n}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for c := 0; c < concurrency; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lat, mism, err := echoClient(wsURL, srv.URL, n, size)
+			mu.Lock()
+			res.latencies = append(res.latencies, lat...)
+			res.mismatches += mism
+			if err != nil {
+				res.errors++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+
+	sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+	return res
+}
+
+func main() {
+	concurrency := flag.Int("c", 10, "Number of concurrent connections")
+	numMessages := flag.Int("n", 1000, "Messages per connection")
+	size := flag.Int("size", 64, "Message size in bytes")
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+
+	fmt.Printf("Go Version: %s\n", runtime.Version())
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Printf("connections=%d messages=%d size=%dB\n\n", *concurrency, *numMessages, *size)
+
+	srv := newEchoServer()
+	defer srv.Close()
+
+	res := runEcho(srv, *concurrency, *numMessages, *size)
+
+	fmt.Printf("messages: %d of %d\n", len(res.latencies), res.messages)
+	fmt.Printf("time: %dms\n", res.elapsed.Milliseconds())
+	fmt.Printf("throughput: %.0f msgs/s\n", res.msgsPerSecond())
+	for _, p := range []float64{50, 90, 99} {
+		fmt.Printf("p%.0f: %.3fms\n", p, float64(res.percentile(p).Microseconds())/1000)
+	}
+	if res.errors > 0 || res.mismatches > 0 {
+		fmt.Printf("errors: %d connections, %d mismatched echoes\n", res.errors, res.mismatches)
+	}
+
+	verbosity.Notef("\nNote: each round trip reuses one open connection, so there is no per-message\n")
+	verbosity.Notef("handshake or header parsing as in the request/response load test.\n")
+
+	results := []report.Result{{Benchmark: "websocket", Name: "echo", Metrics: res.metrics()}}
+	outputs.WriteOrExit(results...)
+}
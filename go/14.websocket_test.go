@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestEchoRoundTrip(t *testing.T) {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := websocket.Message.Send(ws, "hello, echo"); err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := websocket.Message.Receive(ws, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "hello, echo" {
+		t.Errorf("echo %q, want %q", reply, "hello, echo")
+	}
+
+	res := runEcho(srv, 1, 1, 64)
+	if res.errors != 0 || res.mismatches != 0 || len(res.latencies) != 1 {
+		t.Errorf("runEcho: %d errors, %d mismatches, %d round trips; want 0, 0, 1", res.errors, res.mismatches, len(res.latencies))
+	}
+}
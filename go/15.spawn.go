@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/memstat"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/verbosity"
+)
+
+// memSource selects what getRSSMiB reads; set from -mem-source in main.
+var memSource = memstat.Default
+
+func getRSSMiB() float64 {
+	return memSource.Reader()()
+}
+
+type spawnResult struct {
+	n         int
+	completed int64
+	spawn     time.Duration // until every goroutine was running
+	join      time.Duration // from release until every goroutine had exited
+	stackMiB  float64       // goroutine stack memory while all were alive
+	memDelta  float64       // memSource growth while all were alive
+}
+
+// spawnAndJoin starts n goroutines that each bump a counter and then park on
+// a shared channel, so all n are alive at once when memory is sampled. It
+// then releases them and waits for every one to exit.
+func spawnAndJoin(n int) spawnResult {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stackBefore := ms.StackInuse
+	memBefore := getRSSMiB()
+
+	var counter atomic.Int64
+	var started, done sync.WaitGroup
+	release := make(chan struct{})
+	started.Add(n)
+	done.Add(n)
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		go func() {
+			defer done.Done()
+			counter.Add(1)
+			started.Done()
+			<-release
+		}()
+	}
+	started.Wait()
+	spawn := time.Since(start)
+
+	runtime.ReadMemStats(&ms)
+	res := spawnResult{
+		n:        n,
+		spawn:    spawn,
+		stackMiB: float64(ms.StackInuse-stackBefore) / (1024 * 1024),
+		memDelta: getRSSMiB() - memBefore,
+	}
+
+	start = time.Now()
+	close(release)
+	done.Wait()
+	res.join = time.Since(start)
+	res.completed = counter.Load()
+	return res
+}
+
+func (r spawnResult) perGoroutine(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / float64(r.n)
+}
+
+func (r spawnResult) metrics() map[string]float64 {
+	return map[string]float64{
+		"goroutines":    float64(r.n),
+		"spawn_seconds": r.spawn.Seconds(),
+		"join_seconds":  r.join.Seconds(),
+		"spawn_ns_each": r.perGoroutine(r.spawn),
+		"stack_mb":      r.stackMiB,
+		"stack_kb_each": r.stackMiB * 1024 / float64(r.n),
+		"mem_delta_mb":  r.memDelta,
+		"completed":     float64(r.completed),
+	}
+}
+
+func main() {
+	maxN := flag.Int("max", 1000000, "Largest goroutine count; runs 1k, 10k, ... up to this")
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	flag.Var(&memSource, "mem-source", memstat.FlagUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+
+	fmt.Printf("Go Version: %s\n", runtime.Version())
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Printf("mem_source: %s\n\n", memSource)
+
+	fmt.Printf("%-10s %-10s %-12s %-10s %-12s %-12s\n", "n", "spawn", "ns/spawn", "join", "stack", memSource.Label()+" delta")
+	var results []report.Result
+	for n := 1000; n <= *maxN; n *= 10 {
+		res := spawnAndJoin(n)
+		fmt.Printf("%-10d %-10s %-12.0f %-10s %-12s %-12s\n", n,
+			fmt.Sprintf("%dms", res.spawn.Milliseconds()),
+			res.perGoroutine(res.spawn),
+			fmt.Sprintf("%dms", res.join.Milliseconds()),
+			fmt.Sprintf("%.1fMiB", res.stackMiB),
+			fmt.Sprintf("%.1fMiB", res.memDelta))
+		if res.completed != int64(n) {
+			fmt.Printf("  only %d of %d goroutines ran\n", res.completed, n)
+		}
+		results = append(results, report.Result{Benchmark: "spawn", Name: fmt.Sprintf("n%d", n), Metrics: res.metrics()})
+	}
+
+	verbosity.Notef("\nNote: a goroutine starts with a few KB of stack that grows on demand, so a\n")
+	verbosity.Notef("million of them fit in a few GB at most; an OS thread reserves megabytes each.\n")
+
+	outputs.WriteOrExit(results...)
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestSpawnAndJoinCompletesAll(t *testing.T) {
+	for _, n := range []int{1, 1000, 10000} {
+		res := spawnAndJoin(n)
+		if res.completed != int64(n) {
+			t.Errorf("spawnAndJoin(%d): %d goroutines completed", n, res.completed)
+		}
+		if res.spawn <= 0 || res.join < 0 {
+			t.Errorf("spawnAndJoin(%d): spawn %s, join %s", n, res.spawn, res.join)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/verbosity"
+)
+
+// store is the map interface every contender implements.
+type store interface {
+	Load(k int) (int, bool)
+	Store(k, v int)
+}
+
+type syncMapStore struct{ m sync.Map }
+
+func (s *syncMapStore) Load(k int) (int, bool) {
+	v, ok := s.m.Load(k)
+	if !ok {
+		return 0, false
+	}
+	return v.(int), true
+}
+
+func (s *syncMapStore) Store(k, v int) { s.m.Store(k, v) }
+
+type rwMapStore struct {
+	mu sync.RWMutex
+	m  map[int]int
+}
+
+func newRWMapStore() *rwMapStore { return &rwMapStore{m: make(map[int]int)} }
+
+func (s *rwMapStore) Load(k int) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.m[k]
+	return v, ok
+}
+
+func (s *rwMapStore) Store(k, v int) {
+	s.mu.Lock()
+	s.m[k] = v
+	s.mu.Unlock()
+}
+
+// shardedStore spreads keys over independent maps, each behind its own
+// mutex, so goroutines touching different shards never contend.
+type shardedStore struct {
+	shards []shard
+}
+
+type shard struct {
+	mu sync.Mutex
+	m  map[int]int
+	_  [48]byte // pad to 64 bytes: keep neighbouring shards' mutexes off one cache line
+}
+
+func newShardedStore(n int) *shardedStore {
+	s := &shardedStore{shards: make([]shard, n)}
+	for i := range s.shards {
+		s.shards[i].m = make(map[int]int)
+	}
+	return s
+}
+
+func (s *shardedStore) shard(k int) *shard {
+	return &s.shards[uint(k)%uint(len(s.shards))]
+}
+
+func (s *shardedStore) Load(k int) (int, bool) {
+	sh := s.shard(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	v, ok := sh.m[k]
+	return v, ok
+}
+
+func (s *shardedStore) Store(k, v int) {
+	sh := s.shard(k)
+	sh.mu.Lock()
+	sh.m[k] = v
+	sh.mu.Unlock()
+}
+
+// valueFor is the only value ever stored under k, so any other value read
+// back means the map handed out a torn or misplaced entry.
+func valueFor(k int) int { return k*31 + 7 }
+
+type workloadResult struct {
+	ops        int
+	elapsed    time.Duration
+	mismatches int64 // loads that returned something other than valueFor(k)
+}
+
+func (r workloadResult) opsPerSecond() float64 {
+	return float64(r.ops) / r.elapsed.Seconds()
+}
+
+func (r workloadResult) metrics() map[string]float64 {
+	return map[string]float64{
+		"ops":        float64(r.ops),
+		"seconds":    r.elapsed.Seconds(),
+		"ops_per_s":  r.opsPerSecond(),
+		"mismatches": float64(r.mismatches),
+	}
+}
+
+// runWorkload has goroutines each perform opsPer random operations on s, a
+// readPct share of them loads and the rest stores, over keys distinct keys.
+// A final pass checks that every key now holds valueFor(k).
+func runWorkload(s store, goroutines, opsPer, readPct, keys int) workloadResult {
+	var mismatches atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+
+	start := time.Now()
+	for g := 0; g < goroutines; g++ {
+		go func(seed uint64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(seed, seed))
+			for i := 0; i < opsPer; i++ {
+				k := rng.IntN(keys)
+				if rng.IntN(100) < readPct {
+					if v, ok := s.Load(k); ok && v != valueFor(k) {
+						mismatches.Add(1)
+					}
+				} else {
+					s.Store(k, valueFor(k))
+				}
+			}
+		}(uint64(g))
+	}
+	wg.Wait()
+	res := workloadResult{ops: goroutines * opsPer, elapsed: time.Since(start)}
+
+	for k := 0; k < keys; k++ {
+		s.Store(k, valueFor(k))
+	}
+	for k := 0; k < keys; k++ {
+		if v, ok := s.Load(k); !ok || v != valueFor(k) {
+			mismatches.Add(1)
+		}
+	}
+	res.mismatches = mismatches.Load()
+	return res
+}
+
+func main() {
+	goroutines := flag.Int("g", 0, "Number of goroutines hitting the map (0 = 4 per GOMAXPROCS)")
+	opsPer := flag.Int("ops", 200000, "Operations per goroutine")
+	readPct := flag.Int("read-pct", 90, "Percentage of operations that are reads (0-100)")
+	keys := flag.Int("keys", 1024, "Number of distinct keys")
+	shards := flag.Int("shards", 32, "Buckets in the sharded map")
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+
+	if *readPct < 0 || *readPct > 100 {
+		fmt.Fprintf(os.Stderr, "Invalid -read-pct %d: must be between 0 and 100\n", *readPct)
+		os.Exit(1)
+	}
+	if *goroutines <= 0 {
+		*goroutines = runtime.GOMAXPROCS(0) * 4
+	}
+	if *keys < 1 || *shards < 1 {
+		fmt.Fprintln(os.Stderr, "-keys and -shards must be at least 1")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Go Version: %s\n", runtime.Version())
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Printf("goroutines=%d ops=%d read=%d%% keys=%d shards=%d\n\n", *goroutines, *opsPer, *readPct, *keys, *shards)
+
+	contenders := []struct {
+		name string
+		s    store
+	}{
+		{"sync_map", &syncMapStore{}},
+		{"rwmutex_map", newRWMapStore()},
+		{"sharded_map", newShardedStore(*shards)},
+	}
+
+	fmt.Printf("%-12s %-10s %-14s %s\n", "map", "time", "ops/s", "mismatches")
+	var results []report.Result
+	for _, c := range contenders {
+		res := runWorkload(c.s, *goroutines, *opsPer, *readPct, *keys)
+		fmt.Printf("%-12s %-10s %-14.0f %d\n", c.name,
+			fmt.Sprintf("%dms", res.elapsed.Milliseconds()), res.opsPerSecond(), res.mismatches)
+		results = append(results, report.Result{Benchmark: "maps", Name: c.name, Metrics: res.metrics()})
+	}
+
+	verbosity.Notef("\nNote: sync.Map is tuned for read-mostly keys that are written once; under\n")
+	verbosity.Notef("frequent writes a sharded map usually wins by splitting the lock.\n")
+
+	outputs.WriteOrExit(results...)
+}
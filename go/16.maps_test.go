@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStoresConsistentUnderConcurrency(t *testing.T) {
+	stores := map[string]func() store{
+		"sync.Map": func() store { return &syncMapStore{} },
+		"rwmutex":  func() store { return newRWMapStore() },
+		"sharded":  func() store { return newShardedStore(8) },
+	}
+	for name, newStore := range stores {
+		if res := runWorkload(newStore(), 8, 5000, 50, 256); res.mismatches != 0 {
+			t.Errorf("%s: %d mismatched loads", name, res.mismatches)
+		}
+
+		// Each writer owns a key range and overwrites it a few times while
+		// readers hammer the same keys; the last write must win.
+		const writers, perWriter = 4, 200
+		s := newStore()
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(2)
+			go func(w int) {
+				defer wg.Done()
+				for round := 0; round < 3; round++ {
+					for k := w * perWriter; k < (w+1)*perWriter; k++ {
+						s.Store(k, k*10+round)
+					}
+				}
+			}(w)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < writers*perWriter; k++ {
+					s.Load(k)
+				}
+			}()
+		}
+		wg.Wait()
+		for k := 0; k < writers*perWriter; k++ {
+			if v, ok := s.Load(k); !ok || v != k*10+2 {
+				t.Fatalf("%s: key %d holds %d (present %v), want %d", name, k, v, ok, k*10+2)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/verbosity"
+)
+
+func timed(fn func()) time.Duration {
+	start := time.Now()
+	fn()
+	return time.Since(start)
+}
+
+// startProducers spreads messages 0..numMessages-1 round-robin over n
+// channels, each fed by its own goroutine and closed when its share is sent.
+func startProducers(n, numMessages, capacity int) []chan int {
+	chans := make([]chan int, n)
+	for i := range chans {
+		chans[i] = make(chan int, capacity)
+		go func(i int) {
+			defer close(chans[i])
+			for m := i; m < numMessages; m += n {
+				chans[i] <- m
+			}
+		}(i)
+	}
+	return chans
+}
+
+// fanInResult is what a consumer saw: the message count and the sum of the
+// messages, which together catch both drops and duplicates.
+type fanInResult struct {
+	received int64
+	sum      int64
+}
+
+// expected is the fanInResult of receiving 0..numMessages-1 exactly once.
+func expected(numMessages int) fanInResult {
+	n := int64(numMessages)
+	return fanInResult{received: n, sum: n * (n - 1) / 2}
+}
+
+// selectFanIn receives from every channel with one select over all of them.
+// A select's case count is fixed at compile time, so a variable N needs
+// reflect.Select, which scans every case on each call; closed channels are
+// swapped for nil ones, which a select never picks.
+func selectFanIn(chans []chan int) fanInResult {
+	cases := make([]reflect.SelectCase, len(chans))
+	for i, ch := range chans {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
+	}
+	var nilChan chan int
+
+	var res fanInResult
+	for open := len(chans); open > 0; {
+		i, v, ok := reflect.Select(cases)
+		if !ok {
+			cases[i].Chan = reflect.ValueOf(nilChan)
+			open--
+			continue
+		}
+		res.received++
+		res.sum += v.Int()
+	}
+	return res
+}
+
+// mergeFanIn forwards every channel into one merged channel, a goroutine per
+// source, and receives from that alone.
+func mergeFanIn(chans []chan int, capacity int) fanInResult {
+	merged := make(chan int, capacity)
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, ch := range chans {
+		go func(ch chan int) {
+			defer wg.Done()
+			for m := range ch {
+				merged <- m
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	var res fanInResult
+	for m := range merged {
+		res.received++
+		res.sum += int64(m)
+	}
+	return res
+}
+
+func printRow(n int, name string, numMessages int, res fanInResult, elapsed time.Duration) report.Result {
+	rate := float64(res.received) / elapsed.Seconds()
+	fmt.Printf("%-6d %-8s %-10s %-14.0f", n, name, fmt.Sprintf("%dms", elapsed.Milliseconds()), rate)
+	if res != expected(numMessages) {
+		fmt.Printf(" MISMATCH: received %d, sum %d", res.received, res.sum)
+	}
+	fmt.Println()
+	return report.Result{Benchmark: "select", Name: fmt.Sprintf("%s_n%d", name, n), Metrics: map[string]float64{
+		"channels":   float64(n),
+		"seconds":    elapsed.Seconds(),
+		"msgs_per_s": rate,
+		"received":   float64(res.received),
+	}}
+}
+
+func main() {
+	numMessages := flag.Int("n", 200000, "Messages per run, spread over all channels")
+	maxN := flag.Int("max-n", 256, "Largest channel count; runs 1, 2, 4, ... up to this")
+	capacity := flag.Int("buffer", 64, "Capacity of every source channel and of the merged channel")
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+	if *maxN < 1 || *capacity < 0 {
+		fmt.Fprintln(os.Stderr, "-max-n must be at least 1 and -buffer at least 0")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Go Version: %s\n", runtime.Version())
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Printf("messages=%d buffer=%d\n\n", *numMessages, *capacity)
+
+	fmt.Printf("%-6s %-8s %-10s %-14s\n", "n", "fan-in", "time", "msgs/s")
+	var results []report.Result
+	for n := 1; n <= *maxN; n *= 2 {
+		var res fanInResult
+		elapsed := timed(func() {
+			res = selectFanIn(startProducers(n, *numMessages, *capacity))
+		})
+		results = append(results, printRow(n, "select", *numMessages, res, elapsed))
+
+		elapsed = timed(func() {
+			res = mergeFanIn(startProducers(n, *numMessages, *capacity), *capacity)
+		})
+		results = append(results, printRow(n, "merge", *numMessages, res, elapsed))
+	}
+
+	verbosity.Notef("\nNote: every select call locks and scans all N channels, so its cost grows with N;\n")
+	verbosity.Notef("merging pays one extra hop per message but the consumer waits on a single channel.\n")
+
+	outputs.WriteOrExit(results...)
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestFanInReceivesEveryMessage(t *testing.T) {
+	const numMessages = 1000
+	for _, n := range []int{1, 3, 8} {
+		for _, capacity := range []int{0, 16} {
+			if got, want := selectFanIn(startProducers(n, numMessages, capacity)), expected(numMessages); got != want {
+				t.Errorf("select, n=%d cap=%d: %+v, want %+v", n, capacity, got, want)
+			}
+			if got, want := mergeFanIn(startProducers(n, numMessages, capacity), capacity), expected(numMessages); got != want {
+				t.Errorf("merge, n=%d cap=%d: %+v, want %+v", n, capacity, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/verbosity"
+)
+
+// treeSize is the number of goroutines in a tree of the given depth and
+// width: one root plus width^d at each level d = 1..depth. It stops counting
+// once the total passes limit, which is all callers need to know.
+func treeSize(depth, width, limit int) int {
+	total, level := 1, 1
+	for d := 0; d < depth && total <= limit; d++ {
+		level *= width
+		total += level
+	}
+	return total
+}
+
+// cancelTree is the shared state of one tree of goroutines, each waiting on
+// its own context derived from its parent's.
+type cancelTree struct {
+	ready    sync.WaitGroup // every node has derived its context and started its children
+	done     sync.WaitGroup // every node has seen ctx.Done
+	observed atomic.Int64   // nodes that have seen ctx.Done
+
+	cancelAt    time.Time       // set before the root is cancelled
+	leafLatency []time.Duration // time from cancelAt until each leaf woke
+	leaves      atomic.Int64    // next free slot in leafLatency
+}
+
+// spawn starts a node that derives a context from ctx, starts width children
+// below it while depth > 0, and then waits for cancellation. Leaves record
+// how long after cancelAt they woke; cancelAt was written before cancel(), so
+// reading it after ctx.Done is race-free.
+func (t *cancelTree) spawn(ctx context.Context, depth, width int) {
+	go func() {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		if depth > 0 {
+			for range width {
+				t.spawn(ctx, depth-1, width)
+			}
+		}
+		t.ready.Done()
+
+		<-ctx.Done()
+		if depth == 0 {
+			t.leafLatency[t.leaves.Add(1)-1] = time.Since(t.cancelAt)
+		}
+		t.observed.Add(1)
+		t.done.Done()
+	}()
+}
+
+type cancelResult struct {
+	depth, width int
+	goroutines   int
+	observed     int64
+	timedOut     bool
+
+	cancelCall  time.Duration // how long cancel() itself took
+	leafP50     time.Duration
+	leafMax     time.Duration
+	allObserved time.Duration // until every node had seen ctx.Done
+}
+
+// runTree builds a tree below a cancellable parent context, cancels the
+// parent once every node is running, and times how long the cancellation
+// takes to reach the leaves. A tree that hasn't fully woken within deadline
+// comes back with timedOut set and no latencies.
+func runTree(depth, width int, deadline time.Duration) cancelResult {
+	n := treeSize(depth, width, int(^uint(0)>>1))
+	leaves := 1
+	for range depth {
+		leaves *= width
+	}
+	t := &cancelTree{leafLatency: make([]time.Duration, leaves)}
+	t.ready.Add(n)
+	t.done.Add(n)
+
+	parent, cancel := context.WithCancel(context.Background())
+	t.spawn(parent, depth, width)
+	t.ready.Wait()
+
+	res := cancelResult{depth: depth, width: width, goroutines: n}
+	t.cancelAt = time.Now()
+	cancel()
+	res.cancelCall = time.Since(t.cancelAt)
+
+	finished := make(chan struct{})
+	go func() {
+		t.done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(deadline):
+		res.timedOut = true
+		res.observed = t.observed.Load()
+		return res
+	}
+	res.allObserved = time.Since(t.cancelAt)
+	res.observed = t.observed.Load()
+
+	slices.Sort(t.leafLatency)
+	res.leafP50 = t.leafLatency[len(t.leafLatency)/2]
+	res.leafMax = t.leafLatency[len(t.leafLatency)-1]
+	return res
+}
+
+func micros(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1000
+}
+
+func (r cancelResult) metrics() map[string]float64 {
+	return map[string]float64{
+		"depth":       float64(r.depth),
+		"width":       float64(r.width),
+		"goroutines":  float64(r.goroutines),
+		"observed":    float64(r.observed),
+		"cancel_us":   micros(r.cancelCall),
+		"leaf_p50_us": micros(r.leafP50),
+		"leaf_max_us": micros(r.leafMax),
+		"all_us":      micros(r.allObserved),
+	}
+}
+
+func main() {
+	maxDepth := flag.Int("max-depth", 16, "Deepest tree; runs depths 1, 2, 4, ... up to this")
+	maxWidth := flag.Int("max-width", 8, "Widest tree; runs widths 1, 2, 4, ... up to this")
+	maxGoroutines := flag.Int("max-goroutines", 100000, "Skip trees with more goroutines than this")
+	deadline := flag.Duration("deadline", 10*time.Second, "Fail if any tree hasn't fully observed cancellation within this")
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+	if *maxDepth < 1 || *maxWidth < 1 {
+		fmt.Fprintln(os.Stderr, "-max-depth and -max-width must be at least 1")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Go Version: %s\n", runtime.Version())
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Printf("max goroutines per tree: %d\n\n", *maxGoroutines)
+
+	fmt.Printf("%-6s %-6s %-11s %-12s %-12s %-12s %-12s\n", "depth", "width", "goroutines", "cancel()", "leaf p50", "leaf max", "all")
+	var results []report.Result
+	for depth := 1; depth <= *maxDepth; depth *= 2 {
+		for width := 1; width <= *maxWidth; width *= 2 {
+			if treeSize(depth, width, *maxGoroutines) > *maxGoroutines {
+				continue
+			}
+			res := runTree(depth, width, *deadline)
+			if res.timedOut || res.observed != int64(res.goroutines) {
+				fmt.Fprintf(os.Stderr, "depth %d width %d: only %d of %d goroutines observed cancellation within %s\n",
+					depth, width, res.observed, res.goroutines, *deadline)
+				os.Exit(1)
+			}
+			fmt.Printf("%-6d %-6d %-11d %-12s %-12s %-12s %-12s\n", depth, width, res.goroutines,
+				fmt.Sprintf("%.1fµs", micros(res.cancelCall)),
+				fmt.Sprintf("%.1fµs", micros(res.leafP50)),
+				fmt.Sprintf("%.1fµs", micros(res.leafMax)),
+				fmt.Sprintf("%.1fµs", micros(res.allObserved)))
+			results = append(results, report.Result{Benchmark: "cancel", Name: fmt.Sprintf("d%d_w%d", depth, width), Metrics: res.metrics()})
+		}
+	}
+
+	verbosity.Notef("\nNote: cancel() closes every descendant's Done channel itself, walking the tree\n")
+	verbosity.Notef("under each context's lock, so its cost grows with the goroutine count; what follows\n")
+	verbosity.Notef("is the scheduler waking each parked goroutine, not any per-level hand-off.\n")
+
+	outputs.WriteOrExit(results...)
+}
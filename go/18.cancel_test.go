@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCancelReachesEveryGoroutine(t *testing.T) {
+	if got := treeSize(3, 4, 1000); got != 1+4+16+64 {
+		t.Errorf("treeSize(3, 4) = %d, want 85", got)
+	}
+	for _, tt := range []struct{ depth, width int }{{0, 1}, {1, 8}, {3, 4}, {10, 2}} {
+		res := runTree(tt.depth, tt.width, 5*time.Second)
+		if res.timedOut || res.observed != int64(res.goroutines) {
+			t.Errorf("depth %d width %d: %d of %d goroutines saw the cancel (timed out %v)",
+				tt.depth, tt.width, res.observed, res.goroutines, res.timedOut)
+			continue
+		}
+		if res.leafMax > res.allObserved || res.leafP50 > res.leafMax {
+			t.Errorf("depth %d width %d: leaf p50 %s, max %s, all %s out of order",
+				tt.depth, tt.width, res.leafP50, res.leafMax, res.allObserved)
+		}
+	}
+}
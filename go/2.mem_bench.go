@@ -1,39 +1,196 @@
 package main
 
 import (
+	"bufio"
+	"encoding/csv"
+	"flag"
 	"fmt"
+	"math"
+	"math/rand/v2"
 	"os"
 	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
-	"syscall"
 	"time"
+	"unicode"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/memstat"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/sysx"
+	"github.com/python-memory-research/go/verbosity"
 )
 
+// memSource selects what getRSSMB reads; set from -mem-source in main.
+var memSource = memstat.Default
+
 func getRSSMB() float64 {
-	var rusage syscall.Rusage
-	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err != nil {
-		return 0
+	return memSource.Reader()()
+}
+
+// peakGauge keeps the highest value raised so far and is safe for concurrent
+// use. The tracker's sampler raises it every few milliseconds, so the
+// implementations are benchmarked against each other by -bench-peak.
+type peakGauge interface {
+	store(v float64)
+	raise(v float64)
+	load() float64
+}
+
+// Peak gauge implementations, selected with -peak-impl.
+const (
+	peakValue = "value" // atomic.Value holding a boxed float64, CAS loop
+	peakBits  = "bits"  // atomic.Uint64 holding math.Float64bits, CAS loop
+	peakMutex = "mutex" // plain float64 behind a sync.Mutex
+)
+
+// peakImpl is the gauge new trackers use; set from -peak-impl in main.
+var peakImpl = peakValue
+
+func newPeakGauge(impl string) peakGauge {
+	var g peakGauge
+	switch impl {
+	case peakBits:
+		g = &bitsPeak{}
+	case peakMutex:
+		g = &mutexPeak{}
+	default:
+		g = &valuePeak{}
 	}
+	g.store(0)
+	return g
+}
+
+type valuePeak struct{ v atomic.Value }
+
+func (p *valuePeak) store(v float64) { p.v.Store(v) }
+func (p *valuePeak) load() float64   { return p.v.Load().(float64) }
+
+// raise boxes v into an interface on every successful swap, and
+// CompareAndSwap compares the boxed values, not the floats' bits.
+func (p *valuePeak) raise(v float64) {
+	for {
+		old := p.v.Load().(float64)
+		if v <= old || p.v.CompareAndSwap(old, v) {
+			return
+		}
+	}
+}
+
+type bitsPeak struct{ bits atomic.Uint64 }
+
+func (p *bitsPeak) store(v float64) { p.bits.Store(math.Float64bits(v)) }
+func (p *bitsPeak) load() float64   { return math.Float64frombits(p.bits.Load()) }
+
+func (p *bitsPeak) raise(v float64) {
+	for {
+		old := p.bits.Load()
+		if v <= math.Float64frombits(old) || p.bits.CompareAndSwap(old, math.Float64bits(v)) {
+			return
+		}
+	}
+}
+
+type mutexPeak struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (p *mutexPeak) store(v float64) {
+	p.mu.Lock()
+	p.v = v
+	p.mu.Unlock()
+}
+
+func (p *mutexPeak) load() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.v
+}
 
-	rss := float64(rusage.Maxrss)
+func (p *mutexPeak) raise(v float64) {
+	p.mu.Lock()
+	p.v = max(p.v, v)
+	p.mu.Unlock()
+}
 
-	// ru_maxrss units:
-	// - macOS (darwin): bytes
-	// - Linux: kilobytes
-	// - BSDs: often kilobytes (varies), but Linux rule works for most non-darwin here.
-	if runtime.GOOS == "darwin" {
-		return rss / (1024 * 1024) // bytes -> MB
+// peakSamples returns n pseudo-random readings between 0 and 1000 MB, the
+// same for every call, so each gauge sees an identical sequence. As with real
+// RSS readings, most fall below the peak already seen.
+func peakSamples(n int) []float64 {
+	rng := rand.New(rand.NewPCG(1, 1))
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = rng.Float64() * 1000
 	}
-	return rss / 1024 // KB -> MB
+	return samples
 }
 
+// benchPeak has goroutines each raise a fresh gauge with every sample and
+// returns the mean nanoseconds per raise and the resulting peak.
+func benchPeak(impl string, samples []float64, goroutines int) (float64, float64) {
+	g := newPeakGauge(impl)
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	start := time.Now()
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for _, s := range samples {
+				g.raise(s)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	return float64(elapsed.Nanoseconds()) / float64(goroutines*len(samples)), g.load()
+}
+
+// runPeakBench times every gauge on the same samples and reports whether they
+// agree on the peak.
+func runPeakBench(n int) ([]report.Result, bool) {
+	samples := peakSamples(n)
+	want := slices.Max(samples)
+	goroutines := runtime.GOMAXPROCS(0)
+
+	fmt.Printf("\nPeak gauge: %d samples x %d goroutines, expected peak %.4f\n", n, goroutines, want)
+	fmt.Printf("%-8s %-12s %s\n", "impl", "ns/raise", "peak")
+	var results []report.Result
+	agree := true
+	for _, impl := range []string{peakValue, peakBits, peakMutex} {
+		nsPerRaise, peak := benchPeak(impl, samples, goroutines)
+		fmt.Printf("%-8s %-12.2f %.4f", impl, nsPerRaise, peak)
+		if peak != want {
+			fmt.Print(" MISMATCH")
+			agree = false
+		}
+		fmt.Println()
+		results = append(results, report.Result{Benchmark: "mem_bench", Name: "peak_gauge_" + impl, Metrics: map[string]float64{
+			"ns_per_raise": nsPerRaise,
+			"goroutines":   float64(goroutines),
+		}})
+	}
+	return results, agree
+}
 
 type PeakMemoryTracker struct {
-	peakRSS  atomic.Value
+	peakRSS  peakGauge
 	stopChan chan struct{}
 	wg       sync.WaitGroup
 	interval time.Duration
+
+	record  bool
+	started time.Time
+	samples []MemorySample // written only by the sampling goroutine
+}
+
+// MemorySample is one RSS reading taken by a recording tracker.
+type MemorySample struct {
+	Elapsed time.Duration // since Start
+	RSSMB   float64
 }
 
 func NewPeakMemoryTracker(interval time.Duration) *PeakMemoryTracker {
@@ -41,12 +198,25 @@ func NewPeakMemoryTracker(interval time.Duration) *PeakMemoryTracker {
 		stopChan: make(chan struct{}),
 		interval: interval,
 	}
-	t.peakRSS.Store(float64(0))
+	t.peakRSS = newPeakGauge(peakImpl)
+	return t
+}
+
+// NewRecordingMemoryTracker is like NewPeakMemoryTracker but also keeps
+// every sample, available from Samples after Stop.
+func NewRecordingMemoryTracker(interval time.Duration) *PeakMemoryTracker {
+	t := NewPeakMemoryTracker(interval)
+	t.record = true
 	return t
 }
 
 func (t *PeakMemoryTracker) Start() {
-	t.peakRSS.Store(getRSSMB())
+	t.started = time.Now()
+	first := getRSSMB()
+	t.peakRSS.store(first)
+	if t.record {
+		t.samples = append(t.samples, MemorySample{RSSMB: first})
+	}
 	t.wg.Add(1)
 
 	go func() {
@@ -58,15 +228,10 @@ func (t *PeakMemoryTracker) Start() {
 			select {
 			case <-ticker.C:
 				current := getRSSMB()
-				for {
-					old := t.peakRSS.Load().(float64)
-					if current <= old {
-						break
-					}
-					if t.peakRSS.CompareAndSwap(old, current) {
-						break
-					}
+				if t.record {
+					t.samples = append(t.samples, MemorySample{Elapsed: time.Since(t.started), RSSMB: current})
 				}
+				t.peakRSS.raise(current)
 			case <-t.stopChan:
 				return
 			}
@@ -77,20 +242,88 @@ func (t *PeakMemoryTracker) Start() {
 func (t *PeakMemoryTracker) Stop() float64 {
 	close(t.stopChan)
 	t.wg.Wait()
-	return t.peakRSS.Load().(float64)
+	return t.peakRSS.load()
 }
 
-// memoryIntensiveTask allocates ~sizeMB and TOUCHES EACH PAGE so RSS reflects committed memory.
-// This matches the "touch per page" fix used in the Python benchmark.
+// Samples returns the recorded samples. Only valid after Stop.
+func (t *PeakMemoryTracker) Samples() []MemorySample {
+	return t.samples
+}
+
+// Touch modes for touchPages.
+const (
+	touchFirstByte = "first-byte" // read-modify-write one byte per page
+	touchFullPage  = "full-page"  // write every byte
+	touchRandom    = "random"     // one write per page's worth of memory, at random offsets
+)
+
+// touchMode selects how tasks dirty their buffer; set from -touch-mode in main.
+var touchMode = touchFirstByte
+
+// Fill modes: what value each touch writes.
+const (
+	fillIncr   = "incr"   // the touch mode's own pattern (increment or byte index)
+	fillZero   = "zero"   // always 0, so every page stays identical
+	fillRandom = "random" // xorshift output, so no two pages are alike
+)
+
+// fillMode selects the values touchPages writes; set from -fill in main.
+var fillMode = fillIncr
+
+// filler produces the values for one touchPages call.
+type filler struct {
+	mode  string
+	state uint64 // xorshift64 state; never 0
+}
+
+// next returns the byte to write where the incr pattern would write v.
+func (f *filler) next(v byte) byte {
+	switch f.mode {
+	case fillZero:
+		return 0
+	case fillRandom:
+		f.state ^= f.state << 13
+		f.state ^= f.state >> 7
+		f.state ^= f.state << 17
+		return byte(f.state)
+	}
+	return v
+}
+
+// touchPages writes to data according to mode. first-byte commits every page
+// with the fewest writes, full-page dirties all of it, and random makes as
+// many writes as first-byte but at random offsets, so some pages are hit
+// twice and others never get committed. fillMode picks the values written:
+// identical zero pages are what the OS can deduplicate or compress, random
+// ones keep RSS honest.
+func touchPages(data []byte, mode string) {
+	page := os.Getpagesize()
+	f := filler{mode: fillMode, state: rand.Uint64() | 1}
+	switch mode {
+	case touchFullPage:
+		for i := range data {
+			data[i] = f.next(byte(i))
+		}
+	case touchRandom:
+		for n := 0; n < len(data); n += page {
+			i := rand.IntN(len(data))
+			data[i] = f.next(data[i] + 1)
+		}
+	default:
+		for i := 0; i < len(data); i += page {
+			data[i] = f.next(data[i] + 1)
+		}
+	}
+}
+
+// memoryIntensiveTask allocates ~sizeMB and TOUCHES EACH PAGE (as touchMode
+// says) so RSS reflects committed memory.
 func memoryIntensiveTask(sizeMB int) int64 {
 	numBytes := sizeMB * 1024 * 1024
 	data := make([]byte, numBytes)
 
-	// Touch each OS page to force physical commitment and make RSS meaningful.
-	page := os.Getpagesize()
-	for i := 0; i < len(data); i += page {
-		data[i] = byte((int(data[i]) + 1) & 0xFF)
-	}
+	// Touch the buffer to force physical commitment and make RSS meaningful.
+	touchPages(data, touchMode)
 
 	// Keep it around briefly so the peak sampler sees it.
 	time.Sleep(200 * time.Millisecond)
@@ -101,14 +334,237 @@ func memoryIntensiveTask(sizeMB int) int64 {
 	total += int64(data[len(data)/2])
 	total += int64(data[len(data)-1])
 
+	if releasePages {
+		if err := sysx.Release(data); err != nil {
+			fmt.Fprintf(os.Stderr, "madvise: %v\n", err)
+		}
+	}
+
 	runtime.KeepAlive(data)
 	return total
 }
 
+// releasePages makes memoryIntensiveTask madvise(MADV_DONTNEED) its buffer
+// before returning, so RSS drops without waiting for the GC and scavenger;
+// set from -madvise in main.
+var releasePages bool
+
+// mmapTask is memoryIntensiveTask on an anonymous mapping instead of the Go
+// heap: the pages count toward RSS but never toward HeapAlloc or the GC's
+// pacing, and munmap returns them to the OS immediately.
+func mmapTask(sizeMB int) int64 {
+	data, err := sysx.MapAnon(sizeMB * 1024 * 1024)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mmap: %v\n", err)
+		return 0
+	}
+	defer sysx.Unmap(data)
+
+	touchPages(data, touchMode)
+
+	time.Sleep(200 * time.Millisecond)
+
+	return int64(data[0]) + int64(data[len(data)/2]) + int64(data[len(data)-1])
+}
+
+// task is the allocation each run performs; -mmap switches it to mmapTask.
+var task = memoryIntensiveTask
+
+// targetChunkMB is how much fillToTarget allocates per step.
+const targetChunkMB = 10
+
+// targetResult is what it took fillToTarget to reach its target.
+type targetResult struct {
+	targetMB    float64
+	baselineMB  float64 // reading before the first chunk
+	reachedMB   float64 // first reading at or above the target
+	allocatedMB int     // chunk bytes held when it was reached
+	chunks      int
+	elapsed     time.Duration
+	reached     bool
+	allocs      uint64 // heap objects allocated while filling
+	allocBytes  uint64
+}
+
+// overheadMB is the growth in the reading beyond what the chunks account
+// for: allocator, GC and page-table costs, or negative if some of the chunks
+// were never counted.
+func (r targetResult) overheadMB() float64 {
+	return r.reachedMB - r.baselineMB - float64(r.allocatedMB)
+}
+
+func (r targetResult) metrics() map[string]float64 {
+	reached := 0.0
+	if r.reached {
+		reached = 1
+	}
+	return map[string]float64{
+		"seconds":      r.elapsed.Seconds(),
+		"target_mb":    r.targetMB,
+		"baseline_mb":  r.baselineMB,
+		"reached_mb":   r.reachedMB,
+		"allocated_mb": float64(r.allocatedMB),
+		"overhead_mb":  r.overheadMB(),
+		"reached":      reached,
+		// One fill is one op, as in -benchformat's ns/op.
+		"allocs_per_op": float64(r.allocs),
+		"bytes_per_op":  float64(r.allocBytes),
+	}
+}
+
+// fillToTarget allocates and touches targetChunkMB chunks, keeping every one
+// alive, until read reports at least targetMB. It gives up once the chunks
+// alone add up to twice the target, which means read isn't seeing them.
+func fillToTarget(targetMB float64, read func() float64) targetResult {
+	res := targetResult{targetMB: targetMB, baselineMB: read()}
+	var chunks [][]byte
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	current := res.baselineMB
+	for current < targetMB && float64(res.allocatedMB) < 2*targetMB {
+		chunk := make([]byte, targetChunkMB*1024*1024)
+		touchPages(chunk, touchMode)
+		chunks = append(chunks, chunk)
+		res.allocatedMB += targetChunkMB
+		current = read()
+		verbosity.Detailf("  chunk %d: %s %.2f MB\n", len(chunks), memSource.Label(), current)
+	}
+	res.elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	res.allocs = after.Mallocs - before.Mallocs
+	res.allocBytes = after.TotalAlloc - before.TotalAlloc
+	res.reachedMB = current
+	res.chunks = len(chunks)
+	res.reached = current >= targetMB
+
+	runtime.KeepAlive(chunks)
+	return res
+}
+
+// patternStep is one line of a -pattern file: allocate and touch sizeMB,
+// hold it for hold, then let it go.
+type patternStep struct {
+	sizeMB int
+	hold   time.Duration
+}
+
+// readPattern parses a -pattern file with one "sizeMB holdMs" step per line,
+// separated by whitespace or a comma. Blank lines and lines starting with #
+// are skipped.
+func readPattern(path string) ([]patternStep, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var steps []patternStep
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(c rune) bool { return c == ',' || unicode.IsSpace(c) })
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"sizeMB holdMs\", got %q", path, line, text)
+		}
+		size, err := strconv.Atoi(fields[0])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("%s:%d: size %q must be a positive number of MB", path, line, fields[0])
+		}
+		hold, err := strconv.Atoi(fields[1])
+		if err != nil || hold < 0 {
+			return nil, fmt.Errorf("%s:%d: hold %q must be a non-negative number of ms", path, line, fields[1])
+		}
+		steps = append(steps, patternStep{sizeMB: size, hold: time.Duration(hold) * time.Millisecond})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%s: no steps", path)
+	}
+	return steps, nil
+}
+
+// patternResult is what replayPattern saw while running a pattern.
+type patternResult struct {
+	steps      []patternStep
+	heldMB     []float64 // reading at the end of each step's hold
+	baselineMB float64
+	peakMB     float64
+	elapsed    time.Duration
+	allocs     uint64 // heap objects allocated during the replay
+	allocBytes uint64
+}
+
+// largestMB is the biggest single step, which is what the peak growth should
+// come close to: the steps run one at a time.
+func (r patternResult) largestMB() int {
+	largest := 0
+	for _, s := range r.steps {
+		largest = max(largest, s.sizeMB)
+	}
+	return largest
+}
+
+func (r patternResult) metrics() map[string]float64 {
+	allocated := 0
+	for _, s := range r.steps[:len(r.heldMB)] {
+		allocated += s.sizeMB
+	}
+	return map[string]float64{
+		"seconds":      r.elapsed.Seconds(),
+		"steps":        float64(len(r.heldMB)),
+		"baseline_mb":  r.baselineMB,
+		"peak_mb":      r.peakMB,
+		"growth_mb":    r.peakMB - r.baselineMB,
+		"largest_mb":   float64(r.largestMB()),
+		"allocated_mb": float64(allocated),
+		// One replay is one op, as in -benchformat's ns/op.
+		"allocs_per_op": float64(r.allocs),
+		"bytes_per_op":  float64(r.allocBytes),
+	}
+}
+
+// replayPattern runs steps in order under a peak tracker. Each step's buffer
+// is dropped and collected before the next one starts, so the heap can reuse
+// it and the peak reflects the largest step rather than the running total.
+func replayPattern(steps []patternStep) patternResult {
+	res := patternResult{steps: steps, baselineMB: getRSSMB()}
+	tracker := NewPeakMemoryTracker(5 * time.Millisecond)
+	tracker.Start()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i, s := range steps {
+		data := make([]byte, s.sizeMB*1024*1024)
+		touchPages(data, touchMode)
+		time.Sleep(s.hold)
+		held := getRSSMB()
+		res.heldMB = append(res.heldMB, held)
+		verbosity.Detailf("  step %d: %d MB for %s, %s %.2f MB\n", i+1, s.sizeMB, s.hold, memSource.Label(), held)
+		runtime.KeepAlive(data)
+		runtime.GC()
+	}
+	res.elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	res.allocs = after.Mallocs - before.Mallocs
+	res.allocBytes = after.TotalAlloc - before.TotalAlloc
+	res.peakMB = tracker.Stop()
+	return res
+}
+
 func runSingleThreaded(numTasks, sizeMB int) {
 	for i := 0; i < numTasks; i++ {
-		memoryIntensiveTask(sizeMB)
+		start := time.Now()
+		task(sizeMB)
 		runtime.GC()
+		verbosity.Detailf("  task %d: %.4f seconds, %s %.2f MB\n", i, time.Since(start).Seconds(), memSource.Label(), getRSSMB())
 	}
 }
 
@@ -119,53 +575,370 @@ func runMultiThreaded(numTasks, sizeMB int) {
 	for i := 0; i < numTasks; i++ {
 		go func() {
 			defer wg.Done()
-			memoryIntensiveTask(sizeMB)
+			task(sizeMB)
 		}()
 	}
 	wg.Wait()
 }
 
-func measureMemory(name string, fn func(int, int), numTasks, sizeMB int) float64 {
+type memoryResult struct {
+	name       string
+	samples    []MemorySample // only when recording
+	elapsed    time.Duration
+	rssBefore  float64
+	rssPeak    float64
+	rssAfter   float64
+	gcCycles   uint32
+	allocs     uint64 // heap objects allocated during the run
+	allocBytes uint64
+
+	peakThreads    int // only with -threads
+	peakGoroutines int
+}
+
+func (r memoryResult) metrics() map[string]float64 {
+	m := map[string]float64{
+		"seconds":       r.elapsed.Seconds(),
+		"rss_before_mb": r.rssBefore,
+		"rss_peak_mb":   r.rssPeak,
+		"rss_after_mb":  r.rssAfter,
+		"rss_delta_mb":  r.rssPeak - r.rssBefore,
+		"gc_cycles":     float64(r.gcCycles),
+		// One run of all the tasks is one op, as in -benchformat's ns/op.
+		"allocs_per_op": float64(r.allocs),
+		"bytes_per_op":  float64(r.allocBytes),
+	}
+	if sampleThreads {
+		m["peak_threads"] = float64(r.peakThreads)
+		m["peak_goroutines"] = float64(r.peakGoroutines)
+	}
+	return m
+}
+
+// sampleThreads makes measureMemory track the peak OS thread and goroutine
+// counts of each run; set from -threads in main.
+var sampleThreads bool
+
+// peakComparison relates the sequential and parallel runs' peak growth
+// (peak - before). With every goroutine allocating at once the parallel peak
+// should be several times the sequential one; a ratio near 1 means the tasks
+// didn't actually overlap.
+type peakComparison struct {
+	sequentialPeakMB float64
+	parallelPeakMB   float64
+	ratio            float64 // parallel / sequential; 0 if sequential grew by under 1 MB
+	minRatio         float64
+}
+
+// minMeasurablePeakMB is the smallest sequential growth worth dividing by;
+// below it the ratio would only compare sampling noise.
+const minMeasurablePeakMB = 1.0
+
+func comparePeaks(single, multi memoryResult, minRatio float64) peakComparison {
+	c := peakComparison{
+		sequentialPeakMB: single.rssPeak - single.rssBefore,
+		parallelPeakMB:   multi.rssPeak - multi.rssBefore,
+		minRatio:         minRatio,
+	}
+	if c.sequentialPeakMB >= minMeasurablePeakMB {
+		c.ratio = c.parallelPeakMB / c.sequentialPeakMB
+	}
+	return c
+}
+
+// ok reports whether the parallel peak was at least minRatio times the
+// sequential one.
+func (c peakComparison) ok() bool {
+	return c.ratio >= c.minRatio
+}
+
+func (c peakComparison) metrics() map[string]float64 {
+	return map[string]float64{
+		"sequential_peak_mb": c.sequentialPeakMB,
+		"parallel_peak_mb":   c.parallelPeakMB,
+		"ratio":              c.ratio,
+	}
+}
+
+func measureMemory(name string, fn func(int, int), numTasks, sizeMB int, record bool) memoryResult {
 	runtime.GC()
 	time.Sleep(50 * time.Millisecond)
 
 	rssBefore := getRSSMB()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	gcBefore := ms.NumGC
 
 	tracker := NewPeakMemoryTracker(5 * time.Millisecond)
+	if record {
+		tracker = NewRecordingMemoryTracker(5 * time.Millisecond)
+	}
 	tracker.Start()
+	var stopThreads func() (int, int)
+	if sampleThreads {
+		stopThreads = sysx.SampleThreads(time.Millisecond)
+	}
 
+	var allocsBefore, allocsAfter runtime.MemStats
+	runtime.ReadMemStats(&allocsBefore)
 	start := time.Now()
 	fn(numTasks, sizeMB)
 	elapsed := time.Since(start)
+	runtime.ReadMemStats(&allocsAfter)
 
+	var threads, goroutines int
+	if stopThreads != nil {
+		threads, goroutines = stopThreads()
+	}
 	peakRSS := tracker.Stop()
 	rssAfter := getRSSMB()
+	runtime.ReadMemStats(&ms)
+	gcCycles := ms.NumGC - gcBefore
 
 	fmt.Printf("  Time: %.4f seconds\n", elapsed.Seconds())
-	fmt.Printf("  RSS before: %.2f MB\n", rssBefore)
-	fmt.Printf("  RSS peak: %.2f MB\n", peakRSS)
-	fmt.Printf("  RSS after: %.2f MB\n", rssAfter)
-	fmt.Printf("  RSS delta (peak - before): %.2f MB\n", peakRSS-rssBefore)
+	label := memSource.Label()
+	fmt.Printf("  %s before: %.2f MB\n", label, rssBefore)
+	fmt.Printf("  %s peak: %.2f MB\n", label, peakRSS)
+	fmt.Printf("  %s after: %.2f MB\n", label, rssAfter)
+	fmt.Printf("  %s delta (peak - before): %.2f MB\n", label, peakRSS-rssBefore)
+	fmt.Printf("  GC cycles: %d\n", gcCycles)
+	if sampleThreads {
+		fmt.Printf("  Peak OS threads: %d\n", threads)
+		fmt.Printf("  Peak goroutines: %d\n", goroutines)
+	}
 
-	return peakRSS
+	return memoryResult{
+		name:      name,
+		samples:   tracker.Samples(),
+		elapsed:   elapsed,
+		rssBefore: rssBefore,
+		rssPeak:   peakRSS,
+		rssAfter:  rssAfter,
+		gcCycles:  gcCycles,
+
+		allocs:     allocsAfter.Mallocs - allocsBefore.Mallocs,
+		allocBytes: allocsAfter.TotalAlloc - allocsBefore.TotalAlloc,
+
+		peakThreads:    threads,
+		peakGoroutines: goroutines,
+	}
+}
+
+// writeSamplesCSV writes every recorded sample of each run as
+// phase,elapsed_ms,rss_mb rows.
+func writeSamplesCSV(path string, runs ...memoryResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"phase", "elapsed_ms", "rss_mb"})
+	for _, r := range runs {
+		for _, s := range r.samples {
+			w.Write([]string{
+				r.name,
+				fmt.Sprintf("%.3f", float64(s.Elapsed.Microseconds())/1000),
+				fmt.Sprintf("%.2f", s.RSSMB),
+			})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 func main() {
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	traceMem := flag.String("trace-mem", "", "Write every RSS sample to this CSV file")
+	flag.StringVar(&touchMode, "touch-mode", touchFirstByte, "How tasks dirty their buffer: first-byte, full-page, or random")
+	flag.StringVar(&fillMode, "fill", fillIncr, "Values each touch writes: incr, zero (dedupable), or random (xorshift)")
+	minPeakRatio := flag.Float64("min-peak-ratio", 1.5, "Warn unless the parallel peak growth is at least this multiple of the sequential one")
+	useMmap := flag.Bool("mmap", false, "Allocate with anonymous mmap (off-heap) instead of make (Unix only)")
+	flag.BoolVar(&releasePages, "madvise", false, "Release each task's buffer with madvise(MADV_DONTNEED) before it returns (Linux only)")
+	flag.BoolVar(&sampleThreads, "threads", false, "Sample OS threads and goroutines during each run and report the peaks")
+	flag.StringVar(&peakImpl, "peak-impl", peakValue, "Peak tracker storage: value (atomic.Value), bits (atomic.Uint64) or mutex")
+	benchPeakN := flag.Int("bench-peak", 0, "Instead of the memory benchmark, time each peak tracker implementation over this many samples")
+	targetRSS := flag.Float64("target-rss", 0, fmt.Sprintf("Instead of the fixed tasks, allocate %d MB chunks until the memory source reads this many MB (0 = off)", targetChunkMB))
+	pattern := flag.String("pattern", "", "Instead of the fixed tasks, replay the \"sizeMB holdMs\" steps in this file, one allocation at a time")
+	flag.Var(&memSource, "mem-source", memstat.FlagUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+
+	suffix := ""
+	switch touchMode {
+	case touchFirstByte:
+	case touchFullPage, touchRandom:
+		suffix = "_" + touchMode
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -touch-mode %q: must be first-byte, full-page, or random\n", touchMode)
+		os.Exit(1)
+	}
+	switch fillMode {
+	case fillIncr:
+	case fillZero, fillRandom:
+		suffix += "_fill-" + fillMode
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -fill %q: must be incr, zero, or random\n", fillMode)
+		os.Exit(1)
+	}
+	if *useMmap {
+		probe, err := sysx.MapAnon(os.Getpagesize())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-mmap unavailable: %v\n", err)
+			os.Exit(1)
+		}
+		sysx.Unmap(probe)
+		task = mmapTask
+		suffix += "_mmap"
+	}
+	if releasePages {
+		if *useMmap {
+			fmt.Fprintln(os.Stderr, "-madvise has no effect with -mmap, which already unmaps each buffer")
+			os.Exit(1)
+		}
+		if err := sysx.Release(make([]byte, os.Getpagesize())); err != nil {
+			fmt.Fprintf(os.Stderr, "-madvise unavailable: %v\n", err)
+			os.Exit(1)
+		}
+		suffix += "_madvise"
+		if memSource == memstat.RSS {
+			verbosity.Notef("Note: -mem-source rss is the peak and never drops; use rss-now to see pages come back\n")
+		}
+	}
+
+	switch peakImpl {
+	case peakValue, peakBits, peakMutex:
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -peak-impl %q: must be value, bits, or mutex\n", peakImpl)
+		os.Exit(1)
+	}
+	if *targetRSS < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -target-rss %g: must be >= 0\n", *targetRSS)
+		os.Exit(1)
+	}
+	if *targetRSS > 0 && (*useMmap || releasePages) {
+		fmt.Fprintln(os.Stderr, "-target-rss keeps every chunk on the Go heap and can't be combined with -mmap or -madvise")
+		os.Exit(1)
+	}
+	var steps []patternStep
+	if *pattern != "" {
+		if *targetRSS > 0 || *useMmap || releasePages {
+			fmt.Fprintln(os.Stderr, "-pattern allocates on the Go heap and can't be combined with -target-rss, -mmap or -madvise")
+			os.Exit(1)
+		}
+		var err error
+		if steps, err = readPattern(*pattern); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -pattern: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Printf("Go Version: %s\n", runtime.Version())
 	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
 	fmt.Printf("NumCPU: %d\n", runtime.NumCPU())
 	fmt.Printf("PID: %d\n", os.Getpid())
 
-	fmt.Println("\nNote: Go has no GIL - goroutines share memory and can run in parallel")
+	if *benchPeakN > 0 {
+		results, agree := runPeakBench(*benchPeakN)
+		if !agree {
+			fmt.Fprintln(os.Stderr, "Peak tracker implementations disagree")
+			os.Exit(1)
+		}
+		outputs.WriteOrExit(results...)
+		return
+	}
+
+	if *targetRSS > 0 {
+		runtime.GC()
+		time.Sleep(100 * time.Millisecond)
+
+		fmt.Println("\n============================================================")
+		fmt.Printf("FILL TO TARGET (%s-based)\n", memSource.Label())
+		fmt.Println("============================================================")
+		fmt.Printf("  Touch mode: %s\n", touchMode)
+		fmt.Printf("  Fill: %s\n", fillMode)
+		fmt.Printf("  Chunk size: %d MB\n", targetChunkMB)
+		fmt.Printf("  Target %s: %.2f MB\n\n", memSource.Label(), *targetRSS)
+
+		res := fillToTarget(*targetRSS, getRSSMB)
+		label := memSource.Label()
+		fmt.Printf("  Time: %.4f seconds\n", res.elapsed.Seconds())
+		fmt.Printf("  %s before: %.2f MB\n", label, res.baselineMB)
+		fmt.Printf("  %s reached: %.2f MB\n", label, res.reachedMB)
+		fmt.Printf("  Allocated: %d MB in %d chunks\n", res.allocatedMB, res.chunks)
+		fmt.Printf("  Overhead (growth - allocated): %.2f MB\n", res.overheadMB())
+		if !res.reached {
+			fmt.Printf("WARNING: gave up after %d MB without reaching the target; %s doesn't see\n", res.allocatedMB, label)
+			fmt.Println("these allocations, or the pages were deduplicated (try -fill random).")
+		}
+
+		outputs.WriteOrExit(report.Result{Benchmark: "mem_bench", Name: "target_rss" + suffix, Metrics: res.metrics()})
+		return
+	}
+
+	if steps != nil {
+		runtime.GC()
+		time.Sleep(100 * time.Millisecond)
+
+		fmt.Println("\n============================================================")
+		fmt.Printf("REPLAY PATTERN (%s-based)\n", memSource.Label())
+		fmt.Println("============================================================")
+		fmt.Printf("  Pattern: %s (%d steps)\n", *pattern, len(steps))
+		fmt.Printf("  Touch mode: %s\n", touchMode)
+		fmt.Printf("  Fill: %s\n", fillMode)
+		if memSource == memstat.RSS {
+			verbosity.Notef("Note: -mem-source rss is the peak and never drops, so steps below its earlier\n")
+			verbosity.Notef("high-water mark don't show; use rss-now or runtime to see each step come and go\n")
+		}
+		fmt.Println()
+
+		res := replayPattern(steps)
+		label := memSource.Label()
+		fmt.Printf("  %-6s %-10s %-10s %s\n", "step", "size", "hold", label+" held")
+		for i, held := range res.heldMB {
+			s := res.steps[i]
+			fmt.Printf("  %-6d %-10s %-10s %.2f MB\n", i+1, fmt.Sprintf("%d MB", s.sizeMB), s.hold, held)
+		}
+		fmt.Printf("\n  Time: %.4f seconds\n", res.elapsed.Seconds())
+		fmt.Printf("  %s before: %.2f MB\n", label, res.baselineMB)
+		fmt.Printf("  %s peak: %.2f MB\n", label, res.peakMB)
+		fmt.Printf("  %s delta (peak - before): %.2f MB\n", label, res.peakMB-res.baselineMB)
+		fmt.Printf("  Largest step: %d MB\n", res.largestMB())
+		if memSource != memstat.RSS && res.peakMB-res.baselineMB < float64(res.largestMB()) {
+			fmt.Printf("WARNING: peak growth is below the largest step; %s doesn't see these\n", label)
+			fmt.Println("allocations, or the pages were deduplicated (try -fill random).")
+		}
+
+		outputs.WriteOrExit(report.Result{Benchmark: "mem_bench", Name: "pattern" + suffix, Metrics: res.metrics()})
+		return
+	}
+
+	verbosity.Notef("\nNote: Go has no GIL - goroutines share memory and can run in parallel\n")
 
 	fmt.Println("\n============================================================")
-	fmt.Println("MEMORY BENCHMARK (RSS-based)")
+	fmt.Printf("MEMORY BENCHMARK (%s-based)\n", memSource.Label())
 	fmt.Println("============================================================")
 
 	numTasks := 4
 	sizeMB := 50
 
 	fmt.Printf("\nConfiguration:\n")
+	if *useMmap {
+		fmt.Println("  Allocation: anonymous mmap (off the Go heap)")
+	}
+	if releasePages {
+		fmt.Println("  Release: madvise(MADV_DONTNEED) after each task")
+	}
+	fmt.Printf("  Touch mode: %s\n", touchMode)
+	fmt.Printf("  Fill: %s\n", fillMode)
 	fmt.Printf("  Number of tasks: %d\n", numTasks)
 	fmt.Printf("  Memory per task: ~%d MB\n", sizeMB)
 	fmt.Printf("  Expected peak (sequential): ~%d MB\n", sizeMB)
@@ -174,13 +947,13 @@ func main() {
 	runtime.GC()
 	time.Sleep(100 * time.Millisecond)
 	baselineRSS := getRSSMB()
-	fmt.Printf("\nBaseline RSS: %.2f MB\n", baselineRSS)
+	fmt.Printf("\nBaseline %s: %.2f MB\n", memSource.Label(), baselineRSS)
 
 	fmt.Println("\n------------------------------------------------------------")
 	fmt.Println("SINGLE-THREADED (Sequential)")
 	fmt.Println("------------------------------------------------------------")
-	fmt.Println("Note: Memory reused between tasks, GC runs between iterations")
-	measureMemory("single_threaded", runSingleThreaded, numTasks, sizeMB)
+	verbosity.Notef("Note: Memory reused between tasks, GC runs between iterations\n")
+	single := measureMemory("single_threaded"+suffix, runSingleThreaded, numTasks, sizeMB, *traceMem != "")
 
 	runtime.GC()
 	time.Sleep(100 * time.Millisecond)
@@ -188,13 +961,13 @@ func main() {
 	fmt.Println("\n------------------------------------------------------------")
 	fmt.Println("MULTI-THREADED (Goroutines - Shared Memory)")
 	fmt.Println("------------------------------------------------------------")
-	fmt.Println("Note: All goroutines share memory space, run concurrently")
-	measureMemory("multi_threaded", runMultiThreaded, numTasks, sizeMB)
+	verbosity.Notef("Note: All goroutines share memory space, run concurrently\n")
+	multi := measureMemory("multi_threaded"+suffix, runMultiThreaded, numTasks, sizeMB, *traceMem != "")
 
 	fmt.Println("\n============================================================")
 	fmt.Println("SUMMARY")
 	fmt.Println("============================================================")
-	fmt.Printf(`
+	verbosity.Notef(`
 Expected results:
 - Single-threaded: ~%d MB peak (one task at a time, GC between)
 - Multi-threaded: ~%d MB peak (all goroutines run in parallel)
@@ -202,4 +975,26 @@ Expected results:
 Note: Go doesn't need multiprocessing for CPU parallelism;
 goroutines already provide it without per-process interpreter overhead.
 `, sizeMB, numTasks*sizeMB)
+
+	peaks := comparePeaks(single, multi, *minPeakRatio)
+	fmt.Printf("\nMeasured peak growth: sequential %.2f MB, parallel %.2f MB (%.2fx)\n",
+		peaks.sequentialPeakMB, peaks.parallelPeakMB, peaks.ratio)
+	if !peaks.ok() {
+		fmt.Printf("WARNING: parallel peak is below %.1fx the sequential peak; the tasks probably\n", peaks.minRatio)
+		fmt.Printf("finished too quickly to overlap, or %s doesn't see this allocation.\n", memSource.Label())
+	}
+
+	if *traceMem != "" {
+		if err := writeSamplesCSV(*traceMem, single, multi); err != nil {
+			fmt.Fprintf(os.Stderr, "Trace error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nWrote %d samples to %s\n", len(single.samples)+len(multi.samples), *traceMem)
+	}
+
+	outputs.WriteOrExit(
+		report.Result{Benchmark: "mem_bench", Name: single.name, Metrics: single.metrics()},
+		report.Result{Benchmark: "mem_bench", Name: multi.name, Metrics: multi.metrics()},
+		report.Result{Benchmark: "mem_bench", Name: "peak_comparison" + suffix, Metrics: peaks.metrics()},
+	)
 }
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/python-memory-research/go/memstat"
+)
+
+func TestSamplesCSV(t *testing.T) {
+	tracker := NewRecordingMemoryTracker(5 * time.Millisecond)
+	tracker.Start()
+	time.Sleep(60 * time.Millisecond)
+	tracker.Stop()
+	run := memoryResult{name: "single_threaded", samples: tracker.Samples()}
+
+	path := filepath.Join(t.TempDir(), "mem.csv")
+	if err := writeSamplesCSV(path, run); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) == 0 || !slices.Equal(rows[0], []string{"phase", "elapsed_ms", "rss_mb"}) {
+		t.Fatalf("missing header, got %q", rows)
+	}
+	if n := len(rows) - 1; n < 4 {
+		t.Errorf("%d sample rows over 12 sample intervals, want at least 4", n)
+	}
+	for _, row := range rows[1:] {
+		if row[0] != run.name {
+			t.Errorf("row %q: phase %q, want %q", row, row[0], run.name)
+		}
+	}
+}
+
+func TestTouchModes(t *testing.T) {
+	page := os.Getpagesize()
+	data := make([]byte, 1024*1024)
+	pages := len(data) / page
+
+	touchPages(data, touchFirstByte)
+	for i, b := range data {
+		want := byte(0)
+		if i%page == 0 {
+			want = 1
+		}
+		if b != want {
+			t.Fatalf("first-byte: data[%d] = %d, want %d", i, b, want)
+		}
+	}
+
+	clear(data)
+	touchPages(data, touchFullPage)
+	for i, b := range data {
+		if b != byte(i) {
+			t.Fatalf("full-page: data[%d] = %d, want %d", i, b, byte(i))
+		}
+	}
+
+	clear(data)
+	touchPages(data, touchRandom)
+	dirty := 0
+	for _, b := range data {
+		if b != 0 {
+			dirty++
+		}
+	}
+	if dirty == 0 || dirty > pages {
+		t.Errorf("random: %d bytes written, want 1..%d", dirty, pages)
+	}
+
+	// The checksum is data[0] + data[len/2] + data[len-1] after touching.
+	defer func(m string) { touchMode = m }(touchMode)
+	for mode, want := range map[string]int64{touchFirstByte: 2, touchFullPage: 255, touchRandom: -1} {
+		touchMode = mode
+		got := memoryIntensiveTask(1)
+		if want >= 0 && got != want {
+			t.Errorf("%s: checksum %d, want %d", mode, got, want)
+		}
+		if got < 0 || got > 3*255 {
+			t.Errorf("%s: checksum %d out of range", mode, got)
+		}
+	}
+}
+
+func TestComparePeaks(t *testing.T) {
+	for _, tt := range []struct {
+		seqBefore, seqPeak, parBefore, parPeak float64
+		wantRatio                              float64
+		wantOK                                 bool
+	}{
+		{100, 200, 100, 500, 4, true},    // tasks overlapped
+		{100, 200, 100, 250, 1.5, true},  // exactly at the threshold
+		{100, 200, 120, 220, 1, false},   // parallel no higher: no overlap
+		{100, 100.5, 100, 400, 0, false}, // sequential growth too small to divide by
+		{100, 200, 100, 90, -0.1, false}, // parallel reading fell
+	} {
+		single := memoryResult{rssBefore: tt.seqBefore, rssPeak: tt.seqPeak}
+		multi := memoryResult{rssBefore: tt.parBefore, rssPeak: tt.parPeak}
+		c := comparePeaks(single, multi, 1.5)
+		if math.Abs(c.ratio-tt.wantRatio) > 1e-9 || c.ok() != tt.wantOK {
+			t.Errorf("comparePeaks(%v->%v, %v->%v): ratio %v ok %v, want %v %v",
+				tt.seqBefore, tt.seqPeak, tt.parBefore, tt.parPeak, c.ratio, c.ok(), tt.wantRatio, tt.wantOK)
+		}
+		if c.sequentialPeakMB != tt.seqPeak-tt.seqBefore || c.parallelPeakMB != tt.parPeak-tt.parBefore {
+			t.Errorf("peaks %v/%v, want %v/%v", c.sequentialPeakMB, c.parallelPeakMB, tt.seqPeak-tt.seqBefore, tt.parPeak-tt.parBefore)
+		}
+	}
+}
+
+func TestFillModes(t *testing.T) {
+	page := os.Getpagesize()
+	const pages = 256
+	defer func(m string) { fillMode = m }(fillMode)
+
+	firstBytes := func(mode string) map[byte]int {
+		fillMode = mode
+		data := make([]byte, pages*page)
+		touchPages(data, touchFirstByte)
+		seen := map[byte]int{}
+		for off := 0; off < len(data); off += page {
+			seen[data[off]]++
+		}
+		return seen
+	}
+
+	if seen := firstBytes(fillZero); len(seen) != 1 || seen[0] != pages {
+		t.Errorf("zero fill wrote %v, want only zeros", seen)
+	}
+	if seen := firstBytes(fillIncr); len(seen) != 1 || seen[1] != pages {
+		t.Errorf("incr fill wrote %v, want only ones", seen)
+	}
+	// 256 random bytes cover about 160 distinct values; a stuck generator
+	// would cover one.
+	if seen := firstBytes(fillRandom); len(seen) < 100 {
+		t.Errorf("random fill wrote %d distinct values across %d pages", len(seen), pages)
+	}
+}
+
+func TestFillToTargetStopsAtTarget(t *testing.T) {
+	// A reader that rises by a chunk per read, as RSS would, crosses 25 MB on
+	// the third chunk.
+	reading := 1.0
+	rising := func() float64 {
+		r := reading
+		reading += targetChunkMB
+		return r
+	}
+	res := fillToTarget(25, rising)
+	if !res.reached || res.chunks != 3 || res.allocatedMB != 3*targetChunkMB || res.reachedMB != 31 {
+		t.Errorf("rising reader: %+v, want the target reached at 31 MB after 3 chunks", res)
+	}
+	if m := res.metrics(); m["bytes_per_op"] < 3*targetChunkMB*1024*1024 || m["allocs_per_op"] < 3 {
+		t.Errorf("rising reader: %v B/op, %v allocs/op; want at least the 3 chunks", m["bytes_per_op"], m["allocs_per_op"])
+	}
+
+	// A reader that never moves must not loop forever.
+	res = fillToTarget(15, func() float64 { return 1 })
+	if res.reached || res.allocatedMB < 2*15 {
+		t.Errorf("flat reader: %+v, want it to give up after 30 MB", res)
+	}
+}
+
+func TestPeakGaugesAgree(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	samples := peakSamples(10000)
+	want := slices.Max(samples)
+	for _, impl := range []string{peakValue, peakBits, peakMutex} {
+		g := newPeakGauge(impl)
+		if got := g.load(); got != 0 {
+			t.Errorf("%s: new gauge reads %v, want 0", impl, got)
+		}
+		for _, s := range samples {
+			g.raise(s)
+		}
+		if got := g.load(); got != want {
+			t.Errorf("%s: peak %v, want %v", impl, got, want)
+		}
+		if _, got := benchPeak(impl, samples, 4); got != want {
+			t.Errorf("%s: concurrent peak %v, want %v", impl, got, want)
+		}
+	}
+}
+
+func TestPatternRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pattern.txt")
+	if err := os.WriteFile(path, []byte("# sizeMB holdMs\n8 30\n\n32, 30\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := readPattern(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []patternStep{{8, 30 * time.Millisecond}, {32, 30 * time.Millisecond}}
+	if !slices.Equal(steps, want) {
+		t.Fatalf("readPattern = %v, want %v", steps, want)
+	}
+	for _, bad := range []string{"8\n", "0 10\n", "8 -1\n", "8 10 3\n", "# only a comment\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readPattern(path); err == nil {
+			t.Errorf("readPattern accepted %q", bad)
+		}
+	}
+
+	// The Go heap, unlike peak RSS, drops when a step's buffer is collected,
+	// so both steps and the peak show up on their own.
+	defer func(orig memstat.Source) { memSource = orig }(memSource)
+	memSource = memstat.Runtime
+	runtime.GC() // as main does, so other tests' garbage isn't in the baseline
+	res := replayPattern(steps)
+	if len(res.heldMB) != 2 {
+		t.Fatalf("%d steps ran, want 2", len(res.heldMB))
+	}
+	for i, s := range steps {
+		if grew := res.heldMB[i] - res.baselineMB; grew < float64(s.sizeMB)*0.9 {
+			t.Errorf("step %d: heap grew %.1f MB while holding %d MB", i+1, grew, s.sizeMB)
+		}
+	}
+	// The 8 MB step is gone before the 32 MB one starts, so the peak is the
+	// larger step alone, not their 40 MB sum.
+	if growth := res.peakMB - res.baselineMB; growth < 32*0.9 || growth > 36 {
+		t.Errorf("peak grew %.1f MB, want about the largest step's 32 MB", growth)
+	}
+}
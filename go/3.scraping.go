@@ -1,72 +1,752 @@
 package main
 
 import (
-	"io/ioutil"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/httpx"
+	"github.com/python-memory-research/go/verbosity"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/time/rate"
 )
 
 var urls = []string{
-       "https://www.python.org/doc/",
-    "https://golang.org/doc/",
-    "https://docs.djangoproject.com/en/stable/",
-    "https://flask.palletsprojects.com/en/stable/",
-    "https://fastapi.tiangolo.com/",
-    "https://pandas.pydata.org/docs/",
-    "https://numpy.org/doc/",
-    "https://scikit-learn.org/stable/documentation.html",
-    "https://matplotlib.org/stable/contents.html",
-    "https://developer.mozilla.org/en-US/docs/Web",
-    "https://news.ycombinator.com/",
-    "https://www.theguardian.com/international",
-    "https://www.reuters.com/",
-    "https://www.cnn.com/world",
-    "https://www.nytimes.com/international/",
-}
-
-func fetch(url string, wg *sync.WaitGroup, ch chan<- string) {
-    defer wg.Done()
-    resp, err := http.Get(url)
-    if err != nil {
-        return
-    }
-    defer resp.Body.Close()
-    body, _ := ioutil.ReadAll(resp.Body)
-    text := extractText(string(body))
-    ch <- text
-}
-
-func extractText(htmlStr string) string {
-    doc, _ := html.Parse(strings.NewReader(htmlStr))
-    var f func(*html.Node) string
-    f = func(n *html.Node) string {
-        if n.Type == html.TextNode {
-            return n.Data + " "
-        }
-        result := ""
-        for c := n.FirstChild; c != nil; c = c.NextSibling {
-            result += f(c)
-	 }
-	 return result
-    }
-    return f(doc)
-}
-
-func fetchURLs() chan string {
-    ch := make(chan string, len(urls))
-    var wg sync.WaitGroup
-    for _, u := range urls {
-        wg.Add(1)
-	 go fetch(u, &wg, ch)
-    }
-    wg.Wait()
-    close(ch)
-    return ch
+	"https://www.python.org/doc/",
+	"https://golang.org/doc/",
+	"https://docs.djangoproject.com/en/stable/",
+	"https://flask.palletsprojects.com/en/stable/",
+	"https://fastapi.tiangolo.com/",
+	"https://pandas.pydata.org/docs/",
+	"https://numpy.org/doc/",
+	"https://scikit-learn.org/stable/documentation.html",
+	"https://matplotlib.org/stable/contents.html",
+	"https://developer.mozilla.org/en-US/docs/Web",
+	"https://news.ycombinator.com/",
+	"https://www.theguardian.com/international",
+	"https://www.reuters.com/",
+	"https://www.cnn.com/world",
+	"https://www.nytimes.com/international/",
+}
+
+type fetchResult struct {
+	url   string
+	text  string
+	err   error
+	trace *connTrace // nil unless tracing is enabled
+
+	// parseWarning is set when a non-empty body produced no text at all,
+	// which usually means the HTML was too malformed to parse usefully.
+	parseWarning bool
+
+	// truncated is set when the body was longer than -max-bytes and only
+	// the first maxBytes were read and parsed.
+	truncated bool
+}
+
+// connTiming is one request's connection timings.
+type connTiming struct {
+	dns     time.Duration
+	connect time.Duration
+	tls     time.Duration
+	reused  bool
+}
+
+// connTrace collects a connTiming via httptrace. The hooks can run on several
+// goroutines at once (Happy Eyeballs dials addresses in parallel, and an
+// abandoned dial may finish after the request returns), so every access goes
+// through mu.
+type connTrace struct {
+	mu sync.Mutex
+	t  connTiming
+}
+
+// snapshot returns the timings recorded so far.
+func (ct *connTrace) snapshot() connTiming {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.t
+}
+
+// withConnTrace records into ct. Connect time is that of the first dial to
+// succeed, timed from its own ConnectStart.
+func withConnTrace(ctx context.Context, ct *connTrace) context.Context {
+	var dnsStart, tlsStart time.Time
+	connectStart := make(map[string]time.Time) // by network and address
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.t.dns = time.Since(dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			connectStart[network+" "+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			if err == nil && ct.t.connect == 0 {
+				ct.t.connect = time.Since(connectStart[network+" "+addr])
+			}
+		},
+		TLSHandshakeStart: func() {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.t.tls = time.Since(tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.t.reused = info.Reused
+		},
+	})
+}
+
+// scraper fetches pages and remembers each normalized URL it has already
+// requested in this run, so duplicates in the input cost one request.
+type scraper struct {
+	cfg     scrapeConfig
+	client  *httpx.Client
+	limiter *rate.Limiter // shared by every fetch; nil when unlimited
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+
+	hostMu sync.Mutex
+	hosts  map[string]chan struct{} // per-host semaphores, created on first use
+
+	sendWait atomic.Int64 // total ns fetchers spent blocked handing off results
+
+	extractTime  atomic.Int64 // total ns spent in extract, over every pass
+	extractPages atomic.Int64 // pages extracted, each counted once however many passes
+
+	breakerMu sync.Mutex
+	circuits  map[string]*circuit // per-host failure state, created on first result
+}
+
+// circuit is one host's breaker state.
+type circuit struct {
+	failures  int       // consecutive transport errors and 5xx responses
+	openUntil time.Time // requests before this are short-circuited
+}
+
+// errCircuitOpen is returned for URLs skipped because their host's circuit
+// is open.
+var errCircuitOpen = errors.New("circuit open")
+
+// cacheEntry is filled in by the first fetch of a URL; concurrent fetches of
+// the same URL wait on done instead of issuing their own request.
+type cacheEntry struct {
+	done chan struct{}
+	res  fetchResult
+	err  error
+}
+
+type scrapeConfig struct {
+	trace   bool
+	retries int
+	timeout time.Duration
+	buffer  int     // result channel capacity (0 = unbuffered)
+	rps     float64 // global request rate across all hosts (0 = unlimited)
+	perHost int     // concurrent requests per host (0 = unlimited)
+
+	breakAfter    int           // consecutive failures that open a host's circuit (0 = never)
+	breakCooldown time.Duration // how long an open circuit short-circuits requests
+
+	traversal string // DOM walk used by extractText: iter or recursive
+	maxBytes  int64  // bytes read from any one body (0 = unlimited)
+	passes    int    // extract runs per page; only the first result is kept
+}
+
+func newScraper(cfg scrapeConfig) *scraper {
+	client := httpx.New(cfg.timeout)
+	client.Retries = cfg.retries
+	s := &scraper{
+		cfg:    cfg,
+		client: client,
+		cache:  make(map[string]*cacheEntry),
+		hosts:  make(map[string]chan struct{}),
+
+		circuits: make(map[string]*circuit),
+	}
+	if cfg.rps > 0 {
+		// A burst of 1 spaces requests evenly instead of letting the first
+		// few go out together.
+		s.limiter = rate.NewLimiter(rate.Limit(cfg.rps), 1)
+	}
+	client.Acquire = s.acquire
+	return s
+}
+
+// acquire runs before every attempt at a request, retries included. It
+// takes a rate-limit token first, so a request waiting for its turn doesn't
+// hold a per-host slot, then the slot, then checks the host's circuit, which
+// may have opened while it waited.
+func (s *scraper) acquire(req *http.Request) (release func(), err error) {
+	if s.limiter != nil {
+		if err := s.limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	release = s.acquireHost(req.URL.String())
+	if s.circuitOpen(hostOf(req.URL.String())) {
+		release()
+		return nil, errCircuitOpen
+	}
+	return release, nil
+}
+
+// normalizeURL lowercases the scheme and host and drops a trailing slash so
+// trivially different spellings of a page share a cache key.
+func normalizeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String()
+}
+
+// acquireHost blocks until fewer than cfg.perHost requests to rawURL's host
+// are in flight and returns the function that frees the slot.
+func (s *scraper) acquireHost(rawURL string) (release func()) {
+	if s.cfg.perHost <= 0 {
+		return func() {}
+	}
+	host := hostOf(rawURL)
+
+	s.hostMu.Lock()
+	sem, ok := s.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, s.cfg.perHost)
+		s.hosts[host] = sem
+	}
+	s.hostMu.Unlock()
+
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+// hostOf returns the lower-cased host of rawURL, or rawURL itself if it
+// doesn't parse.
+func hostOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return strings.ToLower(u.Host)
+	}
+	return rawURL
+}
+
+// circuitOpen reports whether requests to host should be skipped. Once the
+// cooldown has passed the next request goes through; if it fails too the
+// circuit opens again straight away, since failures is still at the limit.
+// Requests already in flight when a circuit opens still complete, so with
+// every URL fetched at once the breaker only bites alongside -per-host.
+func (s *scraper) circuitOpen(host string) bool {
+	if s.cfg.breakAfter <= 0 {
+		return false
+	}
+	s.breakerMu.Lock()
+	defer s.breakerMu.Unlock()
+	c, ok := s.circuits[host]
+	return ok && time.Now().Before(c.openUntil)
+}
+
+// recordOutcome updates host's consecutive failure count and opens its
+// circuit for the cooldown once the count reaches cfg.breakAfter.
+func (s *scraper) recordOutcome(host string, failed bool) {
+	if s.cfg.breakAfter <= 0 {
+		return
+	}
+	s.breakerMu.Lock()
+	defer s.breakerMu.Unlock()
+	c, ok := s.circuits[host]
+	if !ok {
+		c = &circuit{}
+		s.circuits[host] = c
+	}
+	if !failed {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= s.cfg.breakAfter {
+		c.openUntil = time.Now().Add(s.cfg.breakCooldown)
+	}
+}
+
+func (s *scraper) get(url string) (fetchResult, error) {
+	key := normalizeURL(url)
+
+	s.mu.Lock()
+	e, ok := s.cache[key]
+	if !ok {
+		e = &cacheEntry{done: make(chan struct{})}
+		s.cache[key] = e
+	}
+	s.mu.Unlock()
+
+	if ok {
+		<-e.done
+		res := e.res
+		res.url = url
+		return res, e.err
+	}
+
+	e.res, e.err = s.download(url)
+	if errors.Is(e.err, errCircuitOpen) {
+		// The skip only holds for the cooldown, so don't let it stand in for
+		// the page: the next fetch of this URL tries the circuit again.
+		s.mu.Lock()
+		delete(s.cache, key)
+		s.mu.Unlock()
+	}
+	close(e.done)
+	return e.res, e.err
+}
+
+func (s *scraper) download(url string) (fetchResult, error) {
+	ctx := context.Background()
+	var ct *connTrace
+	if s.cfg.trace {
+		ct = &connTrace{}
+		ctx = withConnTrace(ctx, ct)
+	}
+	host := hostOf(url)
+	if s.circuitOpen(host) {
+		return fetchResult{}, errCircuitOpen
+	}
+	resp, err := s.client.Get(ctx, url)
+	if errors.Is(err, errCircuitOpen) {
+		return fetchResult{}, err
+	}
+	if err != nil {
+		s.recordOutcome(host, true)
+		return fetchResult{}, err
+	}
+	defer resp.Body.Close()
+	body, truncated, err := readBody(resp.Body, s.cfg.maxBytes)
+	s.recordOutcome(host, err != nil || resp.StatusCode >= 500)
+	if err != nil {
+		return fetchResult{}, fmt.Errorf("reading body: %w", err)
+	}
+	text, nodes := s.extractPasses(string(body))
+	return fetchResult{
+		url:          url,
+		text:         text,
+		trace:        ct,
+		parseWarning: nodes == 0 && len(strings.TrimSpace(string(body))) > 0,
+		truncated:    truncated,
+	}, nil
+}
+
+// readBody reads at most maxBytes of r (all of it when maxBytes is 0) and
+// reports whether anything was left over. It asks the LimitReader for one
+// byte more than the cap, so a body of exactly maxBytes isn't flagged.
+func readBody(r io.Reader, maxBytes int64) ([]byte, bool, error) {
+	if maxBytes <= 0 {
+		body, err := io.ReadAll(r)
+		return body, false, err
+	}
+	body, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return body, false, err
+	}
+	if int64(len(body)) > maxBytes {
+		return body[:maxBytes], true, nil
+	}
+	return body, false, nil
+}
+
+// fetch sends exactly one result for url, carrying err on failure, so
+// consumers can count results against the number of URLs.
+func (s *scraper) fetch(url string, wg *sync.WaitGroup, ch chan<- fetchResult) {
+	defer wg.Done()
+	res, err := s.get(url)
+	if err != nil {
+		res = fetchResult{url: url, err: err}
+	}
+	start := time.Now()
+	ch <- res
+	s.sendWait.Add(int64(time.Since(start)))
+}
+
+// extract is the parse-and-walk run on every page; a variable so the pass
+// count can be checked by wrapping it.
+var extract = extractText
+
+// extractPasses runs extract cfg.passes times on the same page and keeps the
+// first result. The extra passes only add CPU work, so -passes can make
+// parsing dominate a run without fetching more pages.
+func (s *scraper) extractPasses(page string) (string, int) {
+	start := time.Now()
+	text, nodes := extract(page, s.cfg.traversal)
+	for i := 1; i < s.cfg.passes; i++ {
+		extract(page, s.cfg.traversal)
+	}
+	s.extractTime.Add(int64(time.Since(start)))
+	s.extractPages.Add(1)
+	return text, nodes
+}
+
+// DOM traversal strategies for extractText.
+const (
+	traverseIter      = "iter"
+	traverseRecursive = "recursive"
+)
+
+// extractText returns the visible text of htmlStr and the number of text
+// nodes it came from. Script and style contents are skipped so they don't
+// pollute word counts. traversal picks the tree walk; both give the same
+// result. The iterative one doesn't depend on the parser's nesting cap
+// (x/net/html currently refuses documents nested over 512 elements deep) to
+// stay within the goroutine stack, and builds the text in one buffer instead
+// of concatenating per level.
+func extractText(htmlStr, traversal string) (string, int) {
+	doc, err := html.Parse(strings.NewReader(htmlStr))
+	if err != nil {
+		return "", 0
+	}
+	if traversal == traverseRecursive {
+		return extractTextRecursive(doc)
+	}
+	return extractTextIter(doc)
+}
+
+// skipText reports whether n's subtree holds no visible text.
+func skipText(n *html.Node) bool {
+	return n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style)
+}
+
+// extractTextIter walks the tree depth-first with an explicit stack, so
+// nesting depth costs heap, not goroutine stack.
+func extractTextIter(doc *html.Node) (string, int) {
+	var sb strings.Builder
+	nodes := 0
+	stack := []*html.Node{doc}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.Type == html.TextNode {
+			nodes++
+			sb.WriteString(n.Data)
+			sb.WriteByte(' ')
+			continue
+		}
+		if skipText(n) {
+			continue
+		}
+		// Push children last-first so they pop in document order.
+		for c := n.LastChild; c != nil; c = c.PrevSibling {
+			stack = append(stack, c)
+		}
+	}
+	return sb.String(), nodes
+}
+
+// extractTextRecursive is the original recursive walk. Each nesting level
+// is a stack frame, so very deep documents can exhaust the goroutine stack.
+func extractTextRecursive(doc *html.Node) (string, int) {
+	nodes := 0
+	var f func(*html.Node) string
+	f = func(n *html.Node) string {
+		if n.Type == html.TextNode {
+			nodes++
+			return n.Data + " "
+		}
+		if skipText(n) {
+			return ""
+		}
+		result := ""
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			result += f(c)
+		}
+		return result
+	}
+	return f(doc), nodes
+}
+
+// fetchURLs fetches urls concurrently and streams one result per URL on the
+// returned channel, which is closed after the last one. The channel holds at
+// most s.cfg.buffer results; once it is full, fetchers block on send until
+// the consumer catches up instead of piling finished pages up in memory.
+func (s *scraper) fetchURLs(urls []string) chan fetchResult {
+	ch := make(chan fetchResult, s.cfg.buffer)
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		wg.Add(1)
+		go s.fetch(u, &wg, ch)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+// shuffledURLs returns a copy of list in an order fixed by seed, so a run
+// can be repeated with the same order or spread differently across hosts.
+func shuffledURLs(list []string, seed uint64) []string {
+	out := append([]string(nil), list...)
+	rng := rand.New(rand.NewPCG(seed, seed))
+	rng.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+// corpusFileName derives a file name for rawURL's text: a readable slug of
+// the host and path, suffixed with a hash of the normalized URL so different
+// URLs never collide even when their slugs do.
+func corpusFileName(rawURL string) string {
+	key := normalizeURL(rawURL)
+	sum := sha256.Sum256([]byte(key))
+
+	slug := key
+	if u, err := url.Parse(key); err == nil {
+		slug = u.Host + u.Path
+	}
+	slug = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, slug)
+	if len(slug) > 64 {
+		slug = slug[:64]
+	}
+	return slug + "-" + hex.EncodeToString(sum[:6]) + ".txt"
+}
+
+// writeCorpus writes r's extracted text into dir and returns the file path.
+func writeCorpus(dir string, r fetchResult) (string, error) {
+	path := filepath.Join(dir, corpusFileName(r.url))
+	return path, os.WriteFile(path, []byte(r.text), 0o644)
+}
+
+func printTraceSummary(results []fetchResult) {
+	var dns, connect, tlsTime time.Duration
+	traced, reused := 0, 0
+	for _, r := range results {
+		if r.trace == nil {
+			continue
+		}
+		traced++
+		t := r.trace.snapshot()
+		dns += t.dns
+		connect += t.connect
+		tlsTime += t.tls
+		if t.reused {
+			reused++
+		}
+	}
+	if traced == 0 {
+		fmt.Println("trace: no traced responses")
+		return
+	}
+	n := time.Duration(traced)
+	fmt.Printf("traced: %d\n", traced)
+	fmt.Printf("avg dns: %s\n", dns/n)
+	fmt.Printf("avg connect: %s\n", connect/n)
+	fmt.Printf("avg tls: %s\n", tlsTime/n)
+	fmt.Printf("reused conns: %d/%d\n", reused, traced)
+}
+
+// lengthBuckets are the upper bounds, in bytes, of the text length
+// histogram; longer pages land in a final open bucket.
+var lengthBuckets = []int{100, 1_000, 10_000, 100_000}
+
+// lengthSummary describes the extracted text lengths of successful pages.
+type lengthSummary struct {
+	pages            int
+	min, median, max int
+	counts           []int // one per lengthBuckets entry, plus the open bucket
+}
+
+func summarizeLengths(results []fetchResult) lengthSummary {
+	var lengths []int
+	for _, r := range results {
+		if r.err == nil {
+			lengths = append(lengths, len(r.text))
+		}
+	}
+	s := lengthSummary{pages: len(lengths), counts: make([]int, len(lengthBuckets)+1)}
+	if len(lengths) == 0 {
+		return s
+	}
+	slices.Sort(lengths)
+	s.min, s.median, s.max = lengths[0], lengths[len(lengths)/2], lengths[len(lengths)-1]
+	for _, l := range lengths {
+		i, _ := slices.BinarySearch(lengthBuckets, l)
+		s.counts[i]++
+	}
+	return s
+}
+
+// printLengthSummary shows where text lengths fall; a page near zero usually
+// failed to extract, and an outlier at the top may be scraping boilerplate.
+func printLengthSummary(s lengthSummary) {
+	if s.pages == 0 {
+		fmt.Println("text length: no successful pages")
+		return
+	}
+	fmt.Printf("text length over %d pages: min %d, median %d, max %d bytes\n", s.pages, s.min, s.median, s.max)
+	lo := 0
+	for i, n := range s.counts {
+		label := fmt.Sprintf(">%d", lengthBuckets[len(lengthBuckets)-1])
+		if i < len(lengthBuckets) {
+			label = fmt.Sprintf("%d-%d", lo, lengthBuckets[i])
+			lo = lengthBuckets[i] + 1
+		}
+		fmt.Printf("  %-14s %3d %s\n", label, n, strings.Repeat("#", n))
+	}
 }
 
 func main() {
-    _ = fetchURLs()
+	var cfg scrapeConfig
+	flag.BoolVar(&cfg.trace, "trace", false, "Record DNS/connect/TLS timings and connection reuse per fetch")
+	flag.IntVar(&cfg.retries, "retries", 2, "Retries per URL on transport errors and 5xx")
+	flag.DurationVar(&cfg.timeout, "timeout", 30*time.Second, "Per-attempt request timeout")
+	flag.StringVar(&cfg.traversal, "traversal", traverseIter, "DOM traversal for text extraction: iter (explicit stack) or recursive")
+	flag.IntVar(&cfg.perHost, "per-host", 0, "Limit concurrent requests to any one host (0 = unlimited)")
+	flag.IntVar(&cfg.breakAfter, "break-after", 0, "Skip a host's remaining URLs after this many consecutive failures (0 = never)")
+	flag.DurationVar(&cfg.breakCooldown, "break-cooldown", 30*time.Second, "How long a host is skipped once -break-after trips")
+	flag.Float64Var(&cfg.rps, "rps", 0, "Limit requests per second across all hosts (0 = unlimited)")
+	outDir := flag.String("outdir", "", "Write each page's extracted text to a file in this directory")
+	flag.IntVar(&cfg.passes, "passes", 1, "Parse and walk each page this many times, keeping the first result, so extraction CPU outweighs the network")
+	flag.Int64Var(&cfg.maxBytes, "max-bytes", 0, "Read at most this many bytes of each page and mark longer ones truncated (0 = unlimited)")
+	flag.IntVar(&cfg.buffer, "buffer", len(urls), "Result channel capacity; fetchers block once this many results are waiting")
+	unbuffered := flag.Bool("unbuffered", false, "Hand each result straight to the consumer (same as -buffer 0)")
+	lengths := flag.Bool("lengths", false, "After scraping, show the distribution of extracted text lengths")
+	shuffle := flag.Bool("shuffle", false, "Fetch the URLs in a shuffled order fixed by -seed")
+	seed := flag.Uint64("seed", 1, "Seed for -shuffle; the same seed always gives the same order")
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+
+	if *unbuffered {
+		cfg.buffer = 0
+	}
+
+	if cfg.traversal != traverseIter && cfg.traversal != traverseRecursive {
+		fmt.Fprintf(os.Stderr, "Invalid -traversal %q: must be iter or recursive\n", cfg.traversal)
+		os.Exit(1)
+	}
+	if cfg.buffer < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -buffer %d: must be >= 0\n", cfg.buffer)
+		os.Exit(1)
+	}
+	if cfg.passes < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid -passes %d: must be >= 1\n", cfg.passes)
+		os.Exit(1)
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -outdir: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Extraction is the CPU-bound part, so -cpu-limit shows up there.
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+
+	order := urls
+	if *shuffle {
+		order = shuffledURLs(urls, *seed)
+		hosts := make([]string, len(order))
+		for i, u := range order {
+			hosts[i] = hostOf(u)
+		}
+		fmt.Printf("order (seed %d): %s\n", *seed, strings.Join(hosts, ", "))
+	}
+
+	var results []fetchResult
+	failed, warnings, skipped, truncated := 0, 0, 0, 0
+	s := newScraper(cfg)
+	start := time.Now()
+	for r := range s.fetchURLs(order) {
+		switch {
+		case r.err != nil:
+			fmt.Printf("%s: %v\n", r.url, r.err)
+			failed++
+			if errors.Is(r.err, errCircuitOpen) {
+				skipped++
+			}
+		case r.parseWarning:
+			fmt.Printf("%s: no text extracted from a non-empty body\n", r.url)
+			warnings++
+		default:
+			verbosity.Detailf("%s: %d bytes of text\n", r.url, len(r.text))
+		}
+		if r.truncated {
+			fmt.Printf("%s: truncated at %d bytes\n", r.url, cfg.maxBytes)
+			truncated++
+		}
+		if *outDir != "" && r.err == nil {
+			if _, err := writeCorpus(*outDir, r); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: writing text: %v\n", r.url, err)
+			}
+		}
+		results = append(results, r)
+	}
+	fmt.Printf("fetched: %d ok, %d failed, %d parse warnings\n", len(results)-failed, failed, warnings)
+	if cfg.breakAfter > 0 {
+		fmt.Printf("circuit open: %d URLs skipped without a request\n", skipped)
+	}
+	if cfg.maxBytes > 0 {
+		fmt.Printf("max-bytes %d: %d pages truncated\n", cfg.maxBytes, truncated)
+	}
+	// With a small or zero buffer, time the consumer spends on each result
+	// (e.g. writing -outdir files) shows up here as fetchers waiting on send.
+	fmt.Printf("buffer %d: %s total, fetchers blocked on send for %s\n",
+		cfg.buffer, time.Since(start).Round(time.Millisecond), time.Duration(s.sendWait.Load()).Round(time.Microsecond))
+	if pages := s.extractPages.Load(); pages > 0 {
+		total := time.Duration(s.extractTime.Load())
+		fmt.Printf("extract: %s over %d pages x %d passes (%s per pass)\n", total.Round(time.Millisecond),
+			pages, cfg.passes, (total / time.Duration(pages*int64(cfg.passes))).Round(time.Microsecond))
+	}
+
+	if cfg.trace {
+		printTraceSummary(results)
+	}
+	if *lengths {
+		printLengthSummary(summarizeLengths(results))
+	}
+
+	verbosity.Notef("\nNote: every URL is fetched on its own goroutine; while one waits on the network\n")
+	verbosity.Notef("the others parse, so total time tracks the slowest page rather than the sum.\n")
 }
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+func newTestScraper(cfg scrapeConfig, ts *httptest.Server) *scraper {
+	if cfg.timeout == 0 {
+		cfg.timeout = 5 * time.Second
+	}
+	if cfg.passes == 0 {
+		cfg.passes = 1
+	}
+	s := newScraper(cfg)
+	s.client.HTTP = ts.Client()
+	s.client.HTTP.Timeout = cfg.timeout
+	return s
+}
+
+func TestConnTrace(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<p>hello</p>")
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{trace: true}, ts)
+
+	first, err := s.download(ts.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.trace == nil {
+		t.Fatal("trace not recorded")
+	}
+	ft := first.trace.snapshot()
+	if ft.dns < 0 || ft.connect <= 0 || ft.tls <= 0 {
+		t.Errorf("first request: dns %s, connect %s, tls %s; want dns >= 0 and connect, tls > 0", ft.dns, ft.connect, ft.tls)
+	}
+	if ft.reused {
+		t.Error("first request reported a reused connection")
+	}
+
+	second, err := s.download(ts.URL + "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := second.trace.snapshot()
+	if !st.reused {
+		t.Error("second request didn't reuse the connection")
+	}
+	if st.dns < 0 || st.connect < 0 || st.tls < 0 {
+		t.Errorf("second request: negative timing in %+v", st)
+	}
+}
+
+func TestCacheFetchesDuplicateOnce(t *testing.T) {
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, "<p>hello</p>")
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{}, ts)
+
+	urls := []string{ts.URL + "/page", ts.URL + "/page", strings.Replace(ts.URL, "http://", "HTTP://", 1) + "/page/"}
+	var got int
+	for r := range s.fetchURLs(urls) {
+		if r.err != nil {
+			t.Fatalf("%s: %v", r.url, r.err)
+		}
+		if strings.TrimSpace(r.text) != "hello" {
+			t.Errorf("%s: text %q, want %q", r.url, r.text, "hello")
+		}
+		got++
+	}
+	if got != len(urls) {
+		t.Errorf("got %d results, want %d", got, len(urls))
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("server saw %d requests, want 1", n)
+	}
+}
+
+func TestReadBodyError(t *testing.T) {
+	boom := errors.New("boom")
+	for _, max := range []int64{0, 10} {
+		if _, _, err := readBody(iotest.ErrReader(boom), max); !errors.Is(err, boom) {
+			t.Errorf("readBody(maxBytes=%d) error = %v, want %v", max, err, boom)
+		}
+	}
+
+	// A body cut short of its Content-Length fails mid-read.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		fmt.Fprint(w, "<p>short</p>")
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{}, ts)
+	if _, err := s.download(ts.URL); err == nil {
+		t.Error("download of a truncated body succeeded")
+	}
+}
+
+// checkNoLeak fails t if more goroutines are running than before once the
+// ones that are merely exiting have had a moment to finish.
+func checkNoLeak(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= before {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines running, %d before:\n%s", n, before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFetchURLsOneResultPerURLNoLeak(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "<p>ok</p>")
+	}))
+	before := runtime.NumGoroutine()
+
+	s := newTestScraper(scrapeConfig{buffer: 0}, ts)
+	urls := []string{
+		ts.URL + "/a",
+		ts.URL + "/fail",
+		"http://127.0.0.1:1/refused",
+		"://not a url",
+		ts.URL + "/a",
+	}
+	var results, failed int
+	for r := range s.fetchURLs(urls) {
+		results++
+		if r.err != nil {
+			failed++
+		}
+	}
+	if results != len(urls) {
+		t.Errorf("got %d results for %d URLs", results, len(urls))
+	}
+	if failed != 2 {
+		t.Errorf("%d results carried an error, want 2 (refused and unparsable)", failed)
+	}
+
+	s.client.HTTP.CloseIdleConnections()
+	ts.Close()
+	checkNoLeak(t, before)
+}
+
+func TestExtractTextSkipsScriptAndStyle(t *testing.T) {
+	page := `<html><head><style>body { color: red }</style></head><body>
+<p>visible words</p><script>var hidden = "script text";</script><p>more</p></body></html>`
+	for _, traversal := range []string{traverseIter, traverseRecursive} {
+		text, _ := extractText(page, traversal)
+		if strings.Contains(text, "hidden") || strings.Contains(text, "color") {
+			t.Errorf("%s: script or style content in extracted text %q", traversal, text)
+		}
+		if !strings.Contains(text, "visible words") || !strings.Contains(text, "more") {
+			t.Errorf("%s: visible text missing from %q", traversal, text)
+		}
+	}
+}
+
+func TestParseWarning(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			fmt.Fprint(w, "<script>only()</script>")
+			return
+		}
+		fmt.Fprint(w, "<p>text</p>")
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{}, ts)
+
+	for path, want := range map[string]bool{"/empty": true, "/text": false} {
+		r, err := s.download(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.parseWarning != want {
+			t.Errorf("%s: parseWarning = %v, want %v", path, r.parseWarning, want)
+		}
+	}
+}
+
+func TestSmallBufferBlocksFetchers(t *testing.T) {
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, "<p>page</p>")
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{buffer: 2}, ts)
+
+	var urls []string
+	for i := 0; i < 6; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d", ts.URL, i))
+	}
+	ch := s.fetchURLs(urls)
+
+	// Every page is fetched, but only buffer results can be waiting; the
+	// other fetchers are parked on send until the consumer catches up.
+	deadline := time.Now().Add(5 * time.Second)
+	for hits.Load() < int64(len(urls)) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(ch); n != 2 {
+		t.Errorf("%d results waiting, want the buffer's 2", n)
+	}
+
+	got := 0
+	for range ch {
+		got++
+		time.Sleep(10 * time.Millisecond) // slow consumer
+	}
+	if got != len(urls) {
+		t.Errorf("got %d results, want %d", got, len(urls))
+	}
+	if s.sendWait.Load() == 0 {
+		t.Error("no fetcher ever blocked on send")
+	}
+}
+
+func TestRateLimitAppliesToRetries(t *testing.T) {
+	var calls atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "<p>ok</p>")
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{rps: 10, retries: 1}, ts)
+	s.client.BaseBackoff = time.Millisecond
+
+	start := time.Now()
+	if _, err := s.download(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("server saw %d requests, want 2", calls.Load())
+	}
+	// The retry needs a second token, 100ms after the first at 10 rps.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("two attempts took %s, want at least one token interval", elapsed)
+	}
+}
+
+func TestWriteCorpus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<p>page %s</p>", r.URL.Path)
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{}, ts)
+	dir := t.TempDir()
+
+	// Both paths sanitize to the same slug; the hash keeps them apart.
+	want := map[string]string{}
+	for r := range s.fetchURLs([]string{ts.URL + "/a b", ts.URL + "/a_b"}) {
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		path, err := writeCorpus(dir, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[filepath.Base(path)] = r.text
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || len(want) != 2 {
+		t.Fatalf("%d files written for 2 URLs", len(entries))
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want[e.Name()] || !strings.Contains(string(data), "page /a") {
+			t.Errorf("%s holds %q, want %q", e.Name(), data, want[e.Name()])
+		}
+	}
+}
+
+func TestRateLimitSpacesFetches(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<p>page</p>")
+	}))
+	defer ts.Close()
+	const rps, n = 20, 6
+	s := newTestScraper(scrapeConfig{rps: rps}, ts)
+
+	var urls []string
+	for i := 0; i < n; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d", ts.URL, i))
+	}
+	start := time.Now()
+	for r := range s.fetchURLs(urls) {
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+	}
+	// The limiter's burst of one lets the first fetch through at once; every
+	// other one waits a full token interval.
+	if elapsed, min := time.Since(start), time.Duration(n-1)*time.Second/rps; elapsed < min {
+		t.Errorf("%d fetches at %d rps took %s, want at least %s", n, rps, elapsed, min)
+	}
+}
+
+func TestPerHostLimit(t *testing.T) {
+	var inFlight, peak atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		fmt.Fprint(w, "<p>page</p>")
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{perHost: 2}, ts)
+
+	var urls []string
+	for i := 0; i < 8; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d", ts.URL, i))
+	}
+	for r := range s.fetchURLs(urls) {
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+	}
+	if p := peak.Load(); p != 2 {
+		t.Errorf("peak of %d concurrent requests to one host, want the limit of 2", p)
+	}
+}
+
+func TestExtractTextDeepNesting(t *testing.T) {
+	// Within the parser's nesting cap both walks give the same text.
+	page := strings.Repeat("<div>", 400) + "deep text" + strings.Repeat("</div>", 400)
+	iter, _ := extractText(page, traverseIter)
+	rec, _ := extractText(page, traverseRecursive)
+	if strings.TrimSpace(iter) != "deep text" || iter != rec {
+		t.Errorf("400 levels: iter %q, recursive %q; want both %q", iter, rec, "deep text")
+	}
+
+	// Past the cap the parser refuses the document, so build the tree by
+	// hand. The iterative walk's stack is a slice on the heap; the recursive
+	// one would need a frame per level and isn't run here, as at this depth
+	// it can exhaust the goroutine stack.
+	const depth = 1_000_000
+	doc := &html.Node{Type: html.DocumentNode}
+	n := doc
+	for i := 0; i < depth; i++ {
+		div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+		n.AppendChild(div)
+		n = div
+	}
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: "bottom"})
+	text, nodes := extractTextIter(doc)
+	if strings.TrimSpace(text) != "bottom" || nodes != 1 {
+		t.Errorf("%d levels: text %q from %d nodes, want %q from 1", depth, text, nodes, "bottom")
+	}
+}
+
+func TestBufferedAndUnbufferedDeliverEveryURL(t *testing.T) {
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, "<p>page</p>")
+	}))
+	defer ts.Close()
+	var urls []string
+	for i := 0; i < 5; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d", ts.URL, i))
+	}
+
+	for _, buffer := range []int{len(urls), 0} {
+		hits.Store(0)
+		s := newTestScraper(scrapeConfig{buffer: buffer}, ts)
+		ch := s.fetchURLs(urls)
+
+		// Let every fetch finish with nobody receiving yet.
+		deadline := time.Now().Add(5 * time.Second)
+		for hits.Load() < int64(len(urls)) && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if buffer > 0 && len(ch) != len(urls) {
+			t.Errorf("buffer %d: %d results waiting, want all %d", buffer, len(ch), len(urls))
+		}
+
+		// Unbuffered, every fetcher is still parked on send: the results
+		// only move once the loop below starts receiving.
+		seen := map[string]int{}
+		for r := range ch {
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			seen[r.url]++
+		}
+		for _, u := range urls {
+			if seen[u] != 1 {
+				t.Errorf("buffer %d: %s delivered %d times", buffer, u, seen[u])
+			}
+		}
+		if buffer == 0 && time.Duration(s.sendWait.Load()) < 50*time.Millisecond {
+			t.Errorf("unbuffered: fetchers waited %s for the consumer, want at least the 50ms it was away", time.Duration(s.sendWait.Load()))
+		}
+	}
+}
+
+func TestCircuitBreakerSkipsFailingHost(t *testing.T) {
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{breakAfter: 3, breakCooldown: time.Minute}, ts)
+
+	// A 500 page is still a result; it only counts against the host.
+	for i := 0; i < 6; i++ {
+		_, err := s.download(fmt.Sprintf("%s/%d", ts.URL, i))
+		if i < 3 && err != nil {
+			t.Errorf("URL %d: %v before the threshold", i, err)
+		}
+		if i >= 3 && !errors.Is(err, errCircuitOpen) {
+			t.Errorf("URL %d: error %v, want %v", i, err, errCircuitOpen)
+		}
+	}
+	if n := hits.Load(); n != 3 {
+		t.Errorf("server saw %d requests, want 3 before the circuit opened", n)
+	}
+}
+
+func TestCircuitSkipIsNotCached(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "<p>ok</p>")
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{breakAfter: 1, breakCooldown: 50 * time.Millisecond}, ts)
+
+	if _, err := s.get(ts.URL + "/bad"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.get(ts.URL + "/page"); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("during the cooldown: error %v, want %v", err, errCircuitOpen)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := s.get(ts.URL + "/page"); err != nil {
+		t.Errorf("after the cooldown: %v, want the page fetched", err)
+	}
+}
+
+func TestShuffleIsReproducible(t *testing.T) {
+	list := make([]string, 20)
+	for i := range list {
+		list[i] = fmt.Sprintf("https://host%d.example/", i)
+	}
+	orig := slices.Clone(list)
+
+	a, b := shuffledURLs(list, 42), shuffledURLs(list, 42)
+	if !slices.Equal(a, b) {
+		t.Errorf("seed 42 gave two orders:\n%v\n%v", a, b)
+	}
+	if !slices.Equal(list, orig) {
+		t.Error("shuffledURLs reordered its input")
+	}
+	sorted := slices.Clone(a)
+	slices.Sort(sorted)
+	want := slices.Clone(orig)
+	slices.Sort(want)
+	if !slices.Equal(sorted, want) {
+		t.Errorf("shuffled list %v isn't a permutation of the input", a)
+	}
+
+	// 20! orders make a collision between seeds vanishingly unlikely.
+	if c := shuffledURLs(list, 43); slices.Equal(a, c) {
+		t.Errorf("seeds 42 and 43 gave the same order %v", a)
+	}
+}
+
+func TestSummarizeLengths(t *testing.T) {
+	// extractText follows each text node with a space, so these pages
+	// extract to 51 and 5001 bytes.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := 50
+		if r.URL.Path == "/long" {
+			n = 5000
+		}
+		fmt.Fprintf(w, "<html><body><p>%s</p></body></html>", strings.Repeat("a", n))
+	}))
+	defer ts.Close()
+
+	s := newTestScraper(scrapeConfig{}, ts)
+	var results []fetchResult
+	for r := range s.fetchURLs([]string{ts.URL + "/short", ts.URL + "/long", "http://127.0.0.1:1/refused"}) {
+		results = append(results, r)
+	}
+	got := summarizeLengths(results)
+	// The refused fetch isn't a page, so it doesn't count as a zero length.
+	want := lengthSummary{pages: 2, min: 51, median: 5001, max: 5001, counts: []int{1, 0, 1, 0, 0}}
+	if got.pages != want.pages || got.min != want.min || got.median != want.median || got.max != want.max || !slices.Equal(got.counts, want.counts) {
+		t.Errorf("summarizeLengths = %+v, want %+v", got, want)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestMaxBytesTruncates(t *testing.T) {
+	const maxBytes = 1000
+	page := "<p>" + strings.Repeat("a", 1<<20) + "</p>"
+
+	src := &countingReader{r: strings.NewReader(page)}
+	body, truncated, err := readBody(src, maxBytes)
+	if err != nil || !truncated || len(body) != maxBytes {
+		t.Errorf("readBody: %d bytes, truncated %v, err %v; want %d, true, nil", len(body), truncated, err, maxBytes)
+	}
+	// One byte past the cap tells a longer body from one of exactly maxBytes.
+	if src.n != maxBytes+1 {
+		t.Errorf("read %d bytes of a %d-byte body, want to stop at %d", src.n, len(page), maxBytes+1)
+	}
+	if _, truncated, _ := readBody(strings.NewReader(page[:maxBytes]), maxBytes); truncated {
+		t.Error("a body of exactly maxBytes was marked truncated")
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, page)
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{maxBytes: maxBytes}, ts)
+	res, err := s.download(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.truncated || len(res.text) > maxBytes {
+		t.Errorf("download: truncated %v, %d bytes of text; want true and at most %d", res.truncated, len(res.text), maxBytes)
+	}
+}
+
+func TestPassesRepeatExtraction(t *testing.T) {
+	var calls atomic.Int64
+	defer func(orig func(string, string) (string, int)) { extract = orig }(extract)
+	extract = func(page, traversal string) (string, int) {
+		calls.Add(1)
+		return extractText(page, traversal)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<p>hello</p>")
+	}))
+	defer ts.Close()
+	s := newTestScraper(scrapeConfig{passes: 3}, ts)
+	urls := []string{ts.URL + "/a", ts.URL + "/b"}
+	for r := range s.fetchURLs(urls) {
+		if r.err != nil || strings.TrimSpace(r.text) != "hello" {
+			t.Errorf("%s: text %q, err %v", r.url, r.text, r.err)
+		}
+	}
+	if got, want := calls.Load(), int64(3*len(urls)); got != want {
+		t.Errorf("extract ran %d times for %d pages at 3 passes, want %d", got, len(urls), want)
+	}
+	if got := s.extractPages.Load(); got != int64(len(urls)) {
+		t.Errorf("%d pages counted, want %d", got, len(urls))
+	}
+}
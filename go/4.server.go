@@ -1,14 +1,36 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	mrand "math/rand/v2"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/httpx"
+	"github.com/python-memory-research/go/memstat"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/sysx"
+	"github.com/python-memory-research/go/verbosity"
 )
 
 const (
@@ -16,10 +38,11 @@ const (
 	PORT = "8081"
 )
 
+// memSource selects what getRSSMiB reads; set from -mem-source in main.
+var memSource = memstat.Default
+
 func getRSSMiB() float64 {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	return float64(m.Sys) / (1024 * 1024)
+	return memSource.Reader()()
 }
 
 func helloHandler(w http.ResponseWriter, r *http.Request) {
@@ -27,52 +50,1065 @@ func helloHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("hello"))
 }
 
-func runServer() {
+// busyWork runs iters steps of a wrapping uint64 Fibonacci loop, a stand-in
+// for the CPU a real handler would spend before answering.
+func busyWork(iters int) uint64 {
+	a, b := uint64(0), uint64(1)
+	for i := 0; i < iters; i++ {
+		a, b = b, a+b
+	}
+	return a
+}
+
+// newHelloHandler returns helloHandler, preceded by workIters of busyWork
+// when workIters > 0.
+func newHelloHandler(workIters int) http.HandlerFunc {
+	if workIters <= 0 {
+		return helloHandler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Expose the result so the loop can't be optimized away.
+		w.Header().Set("X-Work", strconv.FormatUint(busyWork(workIters), 10))
+		helloHandler(w, r)
+	}
+}
+
+const streamChunkSize = 32 * 1024
+
+// serverStart is when the process started serving; main resets it so
+// /stats uptime excludes flag parsing and setup.
+var serverStart = time.Now()
+
+// openConns counts client connections the server currently holds, idle or
+// busy; trackConn keeps it up to date.
+var openConns atomic.Int64
+
+func trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		openConns.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		openConns.Add(-1)
+	}
+}
+
+// runtimeStats is the JSON body of /stats and of each /events message.
+type runtimeStats struct {
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	NumGoroutine  int     `json:"num_goroutine"`
+	OpenConns     int64   `json:"open_conns"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	HeapAlloc     uint64  `json:"heap_alloc"`
+	HeapSys       uint64  `json:"heap_sys"`
+	HeapObjects   uint64  `json:"heap_objects"`
+	StackInuse    uint64  `json:"stack_inuse"`
+	Sys           uint64  `json:"sys"`
+	TotalAlloc    uint64  `json:"total_alloc"`
+	NumGC         uint32  `json:"num_gc"`
+	PauseTotalNs  uint64  `json:"pause_total_ns"`
+}
+
+// currentStats takes a runtime snapshot. ReadMemStats stops the world
+// briefly, so taking one often adds latency of its own.
+func currentStats() runtimeStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return runtimeStats{
+		UptimeSeconds: time.Since(serverStart).Seconds(),
+		NumGoroutine:  runtime.NumGoroutine(),
+		OpenConns:     openConns.Load(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		HeapAlloc:     ms.HeapAlloc,
+		HeapSys:       ms.HeapSys,
+		HeapObjects:   ms.HeapObjects,
+		StackInuse:    ms.StackInuse,
+		Sys:           ms.Sys,
+		TotalAlloc:    ms.TotalAlloc,
+		NumGC:         ms.NumGC,
+		PauseTotalNs:  ms.PauseTotalNs,
+	}
+}
+
+// statsHandler reports a runtime snapshot so a load test or a person can
+// watch the server while it is under load.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentStats())
+}
+
+// eventInterval is how often /events pushes a snapshot.
+const eventInterval = 500 * time.Millisecond
+
+// eventsHandler streams a runtimeStats snapshot as a server-sent event every
+// eventInterval until the client goes away, e.g. `curl -N .../events`. A
+// -write-timeout ends the stream when it expires.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+	for {
+		data, err := json.Marshal(currentStats())
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// streamHandler writes ?mb= megabytes in 32KB chunks, flushing after each one
+// so the response goes out with chunked transfer encoding.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	mb, err := strconv.Atoi(r.URL.Query().Get("mb"))
+	if err != nil || mb < 0 {
+		http.Error(w, "mb must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+
+	chunk := make([]byte, streamChunkSize)
+	for remaining := mb * 1024 * 1024; remaining > 0; remaining -= len(chunk) {
+		if remaining < len(chunk) {
+			chunk = chunk[:remaining]
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// middleware wraps a handler with one server feature.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in mws so that mws[0] is outermost: a request passes through
+// the middlewares in the order given before it reaches h.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// limitInFlight admits at most max concurrent requests and answers the rest
+// with an immediate 503 instead of letting them queue.
+func limitInFlight(max int) middleware {
+	return func(h http.Handler) http.Handler {
+		sem := make(chan struct{}, max)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				h.ServeHTTP(w, r)
+			default:
+				http.Error(w, "too many requests in flight", http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// withWorkerPool runs requests on a fixed pool of workers instead of on the
+// connection's own goroutine: each request is queued and its goroutine waits
+// until a worker has served it. A request whose client gives up while it is
+// queued is dropped without taking a worker, so abandoned requests can't
+// fill the pool under overload. The workers exit once stop is closed, and
+// requests still queued then get a 503.
+func withWorkerPool(workers int, stop <-chan struct{}) middleware {
+	return func(h http.Handler) http.Handler {
+		jobs := make(chan func())
+		for i := 0; i < workers; i++ {
+			go func() {
+				for {
+					select {
+					case job := <-jobs:
+						job()
+					case <-stop:
+						return
+					}
+				}
+			}()
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// claimed settles the race between a worker picking the job up
+			// and the client leaving: whichever sets it first wins, so w is
+			// never written after this handler has returned.
+			var claimed atomic.Bool
+			done := make(chan struct{})
+			job := func() {
+				defer close(done)
+				if claimed.CompareAndSwap(false, true) {
+					h.ServeHTTP(w, r)
+				}
+			}
+			select {
+			case jobs <- job:
+			case <-r.Context().Done():
+				return
+			case <-stop:
+				http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			select {
+			case <-done:
+			case <-r.Context().Done():
+				if !claimed.CompareAndSwap(false, true) {
+					<-done // already being served
+				}
+			}
+		})
+	}
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// newRequestID returns a random ID in UUID v4 form.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// withRequestID echoes the request's X-Request-ID in the response, generating
+// one when the client didn't send it, so a client can correlate its requests
+// with server-side behaviour. Nothing is kept per request.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// gzipResponseWriter compresses the body written through it. The encoding
+// is only committed once the handler writes body bytes (or flushes), so a
+// 1xx, 204 or 304 response, or one that ends without a body, goes out as is
+// instead of gaining a gzip header and an empty gzip stream.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz    *gzip.Writer // set once compression has started
+	code  int          // status held back until the encoding is known
+	plain bool         // the response can't have a body and is passed through
+	sent  bool         // the final status has been sent
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.sent || w.code != 0 {
+		return
+	}
+	switch {
+	case code >= 100 && code < 200:
+		// Informational; the final status is still to come.
+		w.ResponseWriter.WriteHeader(code)
+	case code == http.StatusNoContent || code == http.StatusNotModified:
+		w.plain, w.sent = true, true
+		w.ResponseWriter.WriteHeader(code)
+	default:
+		w.code = code
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.plain {
+		return w.ResponseWriter.Write(b)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if w.gz == nil {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.start()
+	}
+	return w.gz.Write(b)
+}
+
+// start commits the response as gzip-encoded and sends its status.
+func (w *gzipResponseWriter) start() {
+	w.Header().Set("Content-Encoding", "gzip")
+	// Any length the handler set describes the uncompressed body.
+	w.Header().Del("Content-Length")
+	w.sendStatus()
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) sendStatus() {
+	if w.sent {
+		return
+	}
+	w.sent = true
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+// Flush pushes compressed data out so streamHandler still streams. A flush
+// before any body starts compression, since the handler means to stream.
+func (w *gzipResponseWriter) Flush() {
+	if !w.plain && w.gz == nil {
+		w.start()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish ends the gzip stream, or sends the held-back status of a response
+// that never wrote a body.
+func (w *gzipResponseWriter) finish() {
+	if w.gz != nil {
+		w.gz.Close()
+		return
+	}
+	if w.code != 0 {
+		w.sendStatus()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip: listed
+// with a non-zero q-value, or covered by a "*" with one when gzip isn't
+// listed at all. A q-value that doesn't parse counts as 0.
+func acceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// withGzip gzips responses for clients whose Accept-Encoding allows it.
+// HEAD requests are left alone, having no body to compress.
+func withGzip(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.finish()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// newMux routes the server's endpoints. stop ends any worker pool cfg asks
+// for.
+func newMux(cfg serverConfig, stop <-chan struct{}) *http.ServeMux {
+	// Shedding comes first so rejected requests never wait for a worker.
+	var helloMW []middleware
+	if cfg.maxInFlight > 0 {
+		helloMW = append(helloMW, limitInFlight(cfg.maxInFlight))
+	}
+	if cfg.poolSize > 0 {
+		helloMW = append(helloMW, withWorkerPool(cfg.poolSize, stop))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", chain(newHelloHandler(cfg.workIters), helloMW...))
+	mux.HandleFunc("/stream", streamHandler)
+	mux.HandleFunc("/stats", statsHandler)
+	mux.HandleFunc("/events", eventsHandler)
+	if cfg.staticDir != "" {
+		mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(cfg.staticDir))))
+	}
+	return mux
+}
+
+// serverConfig holds the tunables applied to the http.Server. Zero timeouts
+// mean "no timeout", matching net/http's defaults.
+type serverConfig struct {
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+
+	workIters   int // busy-loop iterations per hello request
+	maxInFlight int // concurrent hello requests before shedding with 503 (0 = unlimited)
+	poolSize    int // workers serving hello requests (0 = goroutine per connection)
+	staticDir   string
+	gzip        bool   // compress responses for clients that accept gzip
+	unixSocket  string // listen on this Unix domain socket instead of TCP
+}
+
+// newServer builds the server for cfg. Shutting it down, e.g. with
+// stopServer, also stops its worker pool.
+func newServer(addr string, cfg serverConfig) *http.Server {
+	mws := []middleware{withRequestID}
+	if cfg.gzip {
+		mws = append(mws, withGzip)
+	}
+	stop := make(chan struct{})
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      chain(newMux(cfg, stop), mws...),
+		ReadTimeout:  cfg.readTimeout,
+		WriteTimeout: cfg.writeTimeout,
+		IdleTimeout:  cfg.idleTimeout,
+		ConnState:    trackConn,
+	}
+	server.RegisterOnShutdown(func() { close(stop) })
+	return server
+}
+
+// stopServer shuts s down, running its RegisterOnShutdown hooks, and closes
+// whatever connections are still busy after a second.
+func stopServer(s *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		s.Close()
+	}
+}
+
+func printServerConfig(cfg serverConfig) {
+	fmt.Printf("gomaxprocs: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Printf("read_timeout: %s\n", cfg.readTimeout)
+	fmt.Printf("write_timeout: %s\n", cfg.writeTimeout)
+	fmt.Printf("idle_timeout: %s\n", cfg.idleTimeout)
+	fmt.Printf("work_iters: %d\n", cfg.workIters)
+	fmt.Printf("max_inflight: %d\n", cfg.maxInFlight)
+	if cfg.poolSize > 0 {
+		fmt.Printf("worker_pool: %d\n", cfg.poolSize)
+	}
+	if cfg.staticDir != "" {
+		fmt.Printf("static: %s\n", cfg.staticDir)
+	}
+	if cfg.gzip {
+		fmt.Println("gzip: on")
+	}
+	if cfg.unixSocket != "" {
+		fmt.Printf("unix_socket: %s\n", cfg.unixSocket)
+	}
+}
+
+// listen opens the server's listener: the Unix socket from cfg when set,
+// replacing a stale socket file left by an earlier run, otherwise TCP on addr.
+// Anything at the socket path that isn't a socket is left alone and reported.
+func listen(addr string, cfg serverConfig) (net.Listener, error) {
+	if cfg.unixSocket == "" {
+		return net.Listen("tcp", addr)
+	}
+	info, err := os.Lstat(cfg.unixSocket)
+	switch {
+	case err == nil && info.Mode()&os.ModeSocket == 0:
+		return nil, fmt.Errorf("%s exists and is not a socket", cfg.unixSocket)
+	case err == nil:
+		if err := os.Remove(cfg.unixSocket); err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	return net.Listen("unix", cfg.unixSocket)
+}
+
+// dialUnix returns a DialContext that ignores the request's host and port
+// and connects to the Unix socket at path, so plain http:// URLs reach a
+// server started with -unix.
+func dialUnix(path string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+func runServer(cfg serverConfig) {
 	addr := HOST + ":" + PORT
-	http.HandleFunc("/", helloHandler)
-	fmt.Printf("Server running on http://%s\n", addr)
-	fmt.Println("Press Ctrl+C to stop")
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	server := newServer(addr, cfg)
+	ln, err := listen(addr, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.unixSocket != "" {
+		fmt.Printf("Server running on unix:%s\n", cfg.unixSocket)
+	} else {
+		fmt.Printf("Server running on http://%s\n", addr)
+	}
+	printServerConfig(cfg)
+	verbosity.Notef("Press Ctrl+C to stop\n")
+	if err := server.Serve(ln); err != nil {
 		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func makeRequest(client *http.Client, url string) bool {
-	resp, err := client.Get(url)
+// withFirstByte records in *ttfb how long the first response byte took,
+// covering connection setup and server processing but not the body download.
+// The clock starts when the transport asks for a connection, which happens
+// once per attempt, so a retried request reports its last attempt and never
+// the backoff before it.
+func withFirstByte(ctx context.Context, ttfb *time.Duration) context.Context {
+	var start time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn:              func(string) { start = time.Now() },
+		GotFirstResponseByte: func() { *ttfb = time.Since(start) },
+	})
+}
+
+// makeRequest returns the response status code, or 0 if the request failed
+// before a response arrived, the number of body bytes received, and the
+// time to first byte. A gzip-encoded body is decompressed like a real client
+// would, but the count is of the compressed bytes.
+func makeRequest(client *httpx.Client, t loadTarget) (status int, n int64, ttfb time.Duration) {
+	ctx := withFirstByte(context.Background(), &ttfb)
+	req, err := http.NewRequestWithContext(ctx, t.method, t.url, nil)
 	if err != nil {
-		return false
+		return 0, 0, 0
+	}
+	resp, err := client.Do(ctx, req, client.Retries)
+	if err != nil {
+		return 0, 0, 0
+	}
+	defer resp.Body.Close()
+
+	body := &countingReader{r: resp.Body}
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		if gz, err := gzip.NewReader(body); err == nil {
+			io.Copy(io.Discard, gz)
+		}
+	}
+	io.Copy(io.Discard, body)
+	return resp.StatusCode, body.n, ttfb
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// acceptGzip asks for gzip on every request. Because the header is set
+// explicitly, net/http leaves decompression to makeRequest.
+type acceptGzip struct {
+	base http.RoundTripper
+}
+
+func (t acceptGzip) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip")
+	return t.base.RoundTrip(req)
+}
+
+func (t acceptGzip) CloseIdleConnections() { closeIdleConnections(t.base) }
+
+// closeIdleConnections forwards http.Client.CloseIdleConnections through a
+// wrapping RoundTripper, which the client can't see past on its own.
+func closeIdleConnections(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// errInjected is the transport error faultInjector returns for the requests
+// it fails.
+var errInjected = errors.New("injected failure")
+
+// faultInjector wraps a transport for resilience testing: it delays each
+// request by a random amount up to latency and fails a failRate fraction of
+// them before they reach the network. A seeded RNG makes the pattern of
+// delays and failures repeat from run to run for the same request order.
+type faultInjector struct {
+	base     http.RoundTripper
+	latency  time.Duration
+	failRate float64
+
+	mu  sync.Mutex
+	rng *mrand.Rand
+}
+
+func newFaultInjector(base http.RoundTripper, latency time.Duration, failRate float64, seed uint64) *faultInjector {
+	return &faultInjector{
+		base:     base,
+		latency:  latency,
+		failRate: failRate,
+		rng:      mrand.New(mrand.NewPCG(seed, seed)),
+	}
+}
+
+func (t *faultInjector) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	var delay time.Duration
+	if t.latency > 0 {
+		delay = time.Duration(t.rng.Int64N(int64(t.latency) + 1))
+	}
+	fail := t.rng.Float64() < t.failRate
+	t.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if fail {
+		return nil, errInjected
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (t *faultInjector) CloseIdleConnections() { closeIdleConnections(t.base) }
+
+type loadResult struct {
+	concurrency int
+	requests    int
+	rejected    int // 503 responses from admission control
+	errors      int // transport errors and any other non-200 status
+	start       time.Time
+	elapsed     time.Duration
+	bytes       int64                      // response body bytes received, compressed if gzipped
+	latencies   []time.Duration            // sorted ascending
+	ttfbs       []time.Duration            // time to first byte per request, sorted ascending
+	perPath     map[string][]time.Duration // latencies by request path, sorted ascending
+	rssDelta    float64
+	rssDrained  float64 // current RSS after drainPool, if drained
+	drained     bool
+	allocs      uint64 // heap objects allocated during the run, server included when in-process
+	allocBytes  uint64
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p / 100 * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+func (r loadResult) percentile(p float64) time.Duration {
+	return percentile(r.latencies, p)
+}
+
+func (r loadResult) rps() float64 {
+	return float64(r.requests) / r.elapsed.Seconds()
+}
+
+// loadConfig describes a load-test run against the hello route.
+type loadConfig struct {
+	numRequests int
+	concurrency int
+	retries     int          // per request, on transport errors and 5xx
+	paths       []string     // request paths, cycled through in order (default "/")
+	replay      []loadTarget // requests from -replay; used instead of paths when set
+	baseURL     string       // server to load (default http://HOST:PORT)
+	profile     transportProfile
+	warmup      int    // requests sent before timing starts, not counted in results
+	warmPool    bool   // open concurrency connections before timing starts
+	acceptGzip  bool   // send Accept-Encoding: gzip
+	unixSocket  string // dial this Unix domain socket instead of the URL's host
+	bindAddr    net.IP // local address to dial from; nil lets the OS choose
+
+	think thinkTime // pause between one worker's requests
+
+	injectLatency  time.Duration // random extra delay per request, up to this
+	injectFailRate float64       // fraction of requests failed client-side
+	injectSeed     uint64
+}
+
+func (cfg loadConfig) server() string {
+	if cfg.baseURL != "" {
+		return cfg.baseURL
+	}
+	return fmt.Sprintf("http://%s:%s", HOST, PORT)
+}
+
+// loadTarget is one request the load generator can issue.
+type loadTarget struct {
+	method string
+	path   string
+	url    string
+}
+
+// loadTargets expands cfg.replay, or else cfg.paths as GETs, into full
+// requests on cfg's server.
+func loadTargets(cfg loadConfig) []loadTarget {
+	targets := cfg.replay
+	if len(targets) == 0 {
+		paths := cfg.paths
+		if len(paths) == 0 {
+			paths = []string{"/"}
+		}
+		for _, p := range paths {
+			targets = append(targets, loadTarget{method: http.MethodGet, path: p})
+		}
+	}
+	out := make([]loadTarget, len(targets))
+	for i, t := range targets {
+		t.url = cfg.server() + t.path
+		out[i] = t
+	}
+	return out
+}
+
+// readReplayFile parses a replay log with one "METHOD PATH" request per line.
+// Blank lines and lines starting with # are skipped.
+func readReplayFile(path string) ([]loadTarget, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []loadTarget
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+			return nil, fmt.Errorf("%s:%d: want \"METHOD /path\", got %q", path, line, text)
+		}
+		targets = append(targets, loadTarget{method: strings.ToUpper(fields[0]), path: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: no requests", path)
+	}
+	return targets, nil
+}
+
+func (r loadResult) bytesPerRequest() float64 {
+	if r.requests == 0 {
+		return 0
+	}
+	return float64(r.bytes) / float64(r.requests)
+}
+
+// errorRate is the fraction of requests that ended in an error.
+func (r loadResult) errorRate() float64 {
+	if r.requests == 0 {
+		return 0
+	}
+	return float64(r.errors) / float64(r.requests)
+}
+
+func (r loadResult) metrics() map[string]float64 {
+	m := map[string]float64{
+		"bytes_per_req": r.bytesPerRequest(),
+		"workers":       float64(r.concurrency),
+		"requests":      float64(r.requests),
+		"rejected":      float64(r.rejected),
+		"errors":        float64(r.errors),
+		"error_rate":    r.errorRate(),
+		"seconds":       r.elapsed.Seconds(),
+		"rps":           r.rps(),
+		"p50_ms":        float64(r.percentile(50).Microseconds()) / 1000,
+		"p99_ms":        float64(r.percentile(99).Microseconds()) / 1000,
+		"ttfb_p50_ms":   float64(percentile(r.ttfbs, 50).Microseconds()) / 1000,
+		"ttfb_p99_ms":   float64(percentile(r.ttfbs, 99).Microseconds()) / 1000,
+		"rss_delta_mb":  r.rssDelta,
+		// One load run is one op, as in -benchformat's ns/op.
+		"allocs_per_op": float64(r.allocs),
+		"bytes_per_op":  float64(r.allocBytes),
+	}
+	if r.drained {
+		m["rss_drained_mb"] = r.rssDrained
+	}
+	return m
+}
+
+// transportProfile is a named set of load-client connection settings.
+type transportProfile struct {
+	name string
+
+	// idlePerHostFactor scales the load's concurrency into
+	// MaxIdleConnsPerHost; 0 means use idlePerHost as is.
+	idlePerHostFactor int
+	idlePerHost       int
+	idleTimeout       time.Duration
+	keepAlive         time.Duration // TCP keep-alive period; negative disables it
+}
+
+var transportProfiles = map[string]transportProfile{
+	// default keeps one idle connection per worker, as the load test always has.
+	"default": {name: "default", idlePerHostFactor: 1, idleTimeout: 30 * time.Second, keepAlive: 30 * time.Second},
+	// aggressive keeps spare idle connections around for longer.
+	"aggressive": {name: "aggressive", idlePerHostFactor: 2, idleTimeout: 90 * time.Second, keepAlive: 15 * time.Second},
+	// conservative matches net/http's per-host default of 2 idle connections,
+	// so most workers dial fresh connections.
+	"conservative": {name: "conservative", idlePerHost: http.DefaultMaxIdleConnsPerHost, idleTimeout: 5 * time.Second, keepAlive: -1},
+}
+
+// newTransport builds the load client's transport for p at the given
+// concurrency, dialing from bind when it is non-nil.
+func newTransport(p transportProfile, concurrency int, bind net.IP) *http.Transport {
+	perHost := p.idlePerHost
+	if p.idlePerHostFactor > 0 {
+		perHost = p.idlePerHostFactor * concurrency
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: p.keepAlive}
+	if bind != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: bind}
+	}
+	return &http.Transport{
+		DialContext: dialer.DialContext,
+		// Only ask for compression when -accept-gzip says so.
+		DisableCompression:  true,
+		MaxIdleConns:        perHost,
+		MaxIdleConnsPerHost: perHost,
+		IdleConnTimeout:     p.idleTimeout,
+	}
+}
+
+func printTransport(p transportProfile, t *http.Transport) {
+	keepAlive := p.keepAlive.String()
+	if p.keepAlive < 0 {
+		keepAlive = "off"
+	}
+	fmt.Printf("profile: %s (max_idle_per_host=%d idle_timeout=%s keepalive=%s)\n",
+		p.name, t.MaxIdleConnsPerHost, t.IdleConnTimeout, keepAlive)
+}
+
+func newLoadClient(cfg loadConfig) *httpx.Client {
+	profile := cfg.profile
+	if profile.name == "" {
+		profile = transportProfiles["default"]
+	}
+	client := httpx.New(10 * time.Second)
+	transport := newTransport(profile, cfg.concurrency, cfg.bindAddr)
+	if cfg.unixSocket != "" {
+		transport.DialContext = dialUnix(cfg.unixSocket)
+	}
+	client.HTTP.Transport = transport
+	if cfg.acceptGzip {
+		client.HTTP.Transport = acceptGzip{base: transport}
+	}
+	if cfg.injectLatency > 0 || cfg.injectFailRate > 0 {
+		client.HTTP.Transport = newFaultInjector(client.HTTP.Transport, cfg.injectLatency, cfg.injectFailRate, cfg.injectSeed)
+		fmt.Printf("injecting: up to %s latency, %.0f%% failures (seed %d)\n", cfg.injectLatency, cfg.injectFailRate*100, cfg.injectSeed)
+	}
+	client.Retries = cfg.retries
+	printTransport(profile, transport)
+	if cfg.bindAddr != nil {
+		fmt.Printf("bind: %s\n", cfg.bindAddr)
+	}
+	return client
+}
+
+// warmUp sends n untimed requests to open connections and fill the pool
+// before measurement; none of them appear in the load results.
+func warmUp(client *httpx.Client, targets []loadTarget, n int) {
+	for i := 0; i < n; i++ {
+		makeRequest(client, targets[i%len(targets)])
+	}
+	fmt.Printf("warmup: %d requests (excluded from stats)\n", n)
+}
+
+// fillPool opens n connections at once by holding n responses unread until
+// all of them have arrived, so no request can reuse another's connection.
+// Once released they sit idle in the transport, ready for the timed phase.
+// It returns how many of the n requests dialed a new connection.
+func fillPool(client *httpx.Client, targets []loadTarget, n int) int {
+	var dialed atomic.Int64
+	var arrived, done sync.WaitGroup
+	arrived.Add(n)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func(t loadTarget) {
+			defer done.Done()
+			ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					if !info.Reused {
+						dialed.Add(1)
+					}
+				},
+			})
+			req, err := http.NewRequestWithContext(ctx, t.method, t.url, nil)
+			var resp *http.Response
+			if err == nil {
+				resp, err = client.HTTP.Do(req)
+			}
+			arrived.Done()
+			if err != nil {
+				return
+			}
+			arrived.Wait()
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}(targets[i%len(targets)])
+	}
+	done.Wait()
+	return int(dialed.Load())
+}
+
+// serverOpenConns asks the server's /stats how many connections it holds.
+func serverOpenConns(client *httpx.Client, baseURL string) (int64, error) {
+	resp, err := client.HTTP.Get(baseURL + "/stats")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var stats runtimeStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return 0, err
+	}
+	return stats.OpenConns, nil
+}
+
+// warmPool fills the client's pool with concurrency connections and checks
+// with the server that they are open, so the timed phase starts at steady
+// state rather than dialing as it goes.
+func warmPool(client *httpx.Client, targets []loadTarget, cfg loadConfig) {
+	dialed := fillPool(client, targets, cfg.concurrency)
+	fmt.Printf("warm pool: %d workers, %d new connections, %d reused\n", cfg.concurrency, dialed, cfg.concurrency-dialed)
+	open, err := serverOpenConns(client, cfg.server())
+	if err != nil {
+		fmt.Printf("  could not read server connections: %v\n", err)
+		return
+	}
+	fmt.Printf("  server reports %d open connections\n", open)
+	if open < int64(cfg.concurrency) {
+		fmt.Println("  warning: fewer connections than workers; the timed phase will dial the rest")
+	}
+}
+
+// thinkTime is how long a worker pauses between its requests, like a user
+// reading a page: base, plus or minus a uniformly random jitter.
+type thinkTime struct {
+	base   time.Duration
+	jitter time.Duration
+}
+
+func (t thinkTime) pause() {
+	d := t.base
+	if t.jitter > 0 {
+		d += time.Duration(mrand.Int64N(int64(2*t.jitter)+1)) - t.jitter
+	}
+	if d > 0 {
+		time.Sleep(d)
 	}
-	io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
-	return resp.StatusCode == 200
 }
 
-func runLoadTest(numRequests, concurrency int) {
-	url := fmt.Sprintf("http://%s:%s/", HOST, PORT)
+// maxRPS is the closed-loop ceiling: with every worker pausing base between
+// requests, concurrency workers can't exceed concurrency/base requests per
+// second however fast the server answers. It is 0 when there is no pause.
+func (t thinkTime) maxRPS(concurrency int) float64 {
+	if t.base <= 0 {
+		return 0
+	}
+	return float64(concurrency) / t.base.Seconds()
+}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        concurrency,
-			MaxIdleConnsPerHost: concurrency,
-			IdleConnTimeout:     30 * time.Second,
-		},
-		Timeout: 10 * time.Second,
+// parseBindAddr parses an IP to dial from and checks that it belongs to this
+// host by listening on it briefly, so a typo fails at startup rather than as
+// a connection error on every request.
+func parseBindAddr(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IP address", s)
+	}
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a local address: %w", ip, err)
 	}
+	ln.Close()
+	return ip, nil
+}
+
+// drainPool closes the client's idle connections, which would otherwise
+// linger until IdleConnTimeout and count toward the next run's memory, and
+// returns the current RSS once they and their buffers are gone. That is
+// always memstat.CurrentRSSMB, whatever -mem-source says, since a peak can't
+// show the drop; ok is false where current RSS can't be read.
+func drainPool(client *httpx.Client) (mb float64, ok bool) {
+	client.HTTP.CloseIdleConnections()
+	runtime.GC()
+	mb = memstat.CurrentRSSMB()
+	return mb, mb > 0
+}
 
-	// Warm up
-	for i := 0; i < 10; i++ {
-		makeRequest(client, url)
+// printDrained reports the reading drainPool took.
+func printDrained(res loadResult) {
+	if !res.drained {
+		fmt.Println("rss_after_drain: unavailable (current RSS is Linux-only)")
+		return
 	}
+	fmt.Printf("rss_after_drain: %.1fMiB (%s)\n", res.rssDrained, memstat.RSSNow)
+}
 
+// runLoad fires numRequests from concurrency workers, cycling through targets,
+// and records each latency.
+func runLoad(client *httpx.Client, targets []loadTarget, numRequests, concurrency int, think thinkTime) loadResult {
 	rssBefore := getRSSMiB()
 
-	work := make(chan struct{}, numRequests)
+	work := make(chan loadTarget, numRequests)
 	for i := 0; i < numRequests; i++ {
-		work <- struct{}{}
+		work <- targets[i%len(targets)]
 	}
 	close(work)
 
+	latencies := make([]time.Duration, 0, numRequests)
+	ttfbs := make([]time.Duration, 0, numRequests)
+	perPath := make(map[string][]time.Duration)
+	var rejected, errCount int
+	var received int64
+	var mu sync.Mutex
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
 	start := time.Now()
 
 	var wg sync.WaitGroup
@@ -80,60 +1116,641 @@ func runLoadTest(numRequests, concurrency int) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for range work {
-				makeRequest(client, url)
+			first := true
+			for t := range work {
+				if !first {
+					think.pause()
+				}
+				first = false
+				reqStart := time.Now()
+				status, n, ttfb := makeRequest(client, t)
+				d := time.Since(reqStart)
+				mu.Lock()
+				received += n
+				latencies = append(latencies, d)
+				if status != 0 {
+					ttfbs = append(ttfbs, ttfb)
+				}
+				perPath[t.path] = append(perPath[t.path], d)
+				switch status {
+				case http.StatusOK:
+				case http.StatusServiceUnavailable:
+					rejected++
+				default:
+					errCount++
+				}
+				mu.Unlock()
 			}
 		}()
 	}
 
 	wg.Wait()
 	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
 	rssAfter := getRSSMiB()
 
-	avgLatency := (elapsed.Seconds() / float64(numRequests)) * 1000
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	sort.Slice(ttfbs, func(i, j int) bool { return ttfbs[i] < ttfbs[j] })
+	for _, l := range perPath {
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	}
+
+	return loadResult{
+		concurrency: concurrency,
+		requests:    numRequests,
+		rejected:    rejected,
+		errors:      errCount,
+		start:       start,
+		bytes:       received,
+		elapsed:     elapsed,
+		latencies:   latencies,
+		ttfbs:       ttfbs,
+		perPath:     perPath,
+		rssDelta:    rssAfter - rssBefore,
+		allocs:      after.Mallocs - before.Mallocs,
+		allocBytes:  after.TotalAlloc - before.TotalAlloc,
+	}
+}
+
+// hdrMaxLatency is the largest latency an HdrHistogram log can hold; longer
+// requests are clamped to it.
+const hdrMaxLatency = time.Hour
 
-	fmt.Printf("workers: %d\n", concurrency)
-	fmt.Printf("reqs: %d\n", numRequests)
+// writeHdrLog writes every run's latencies, in nanoseconds, as one tagged
+// interval histogram in HdrHistogram's compressed log format, so the data can
+// be fed to HistogramLogProcessor and similar tools without loss.
+func writeHdrLog(path string, runs []loadResult) error {
+	if len(runs) == 0 {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeHdrIntervals(f, runs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeHdrIntervals writes the log header and one interval line per run.
+// Interval lines are formatted here rather than by HistogramLogWriter, which
+// prints millisecond timestamps where the format expects seconds.
+func writeHdrIntervals(out io.Writer, runs []loadResult) error {
+	w := hdrhistogram.NewHistogramLogWriter(out)
+	// Whole seconds: the header lines have no room for milliseconds.
+	base := runs[0].start.Unix() * 1000
+	for _, err := range []error{w.OutputLogFormatVersion(), w.OutputStartTime(base), w.OutputBaseTime(base), w.OutputLegend()} {
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range runs {
+		h := hdrhistogram.New(1, int64(hdrMaxLatency), 3)
+		for _, d := range r.latencies {
+			if err := h.RecordValue(int64(min(d, hdrMaxLatency))); err != nil {
+				return err
+			}
+		}
+		payload, err := h.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+		if err != nil {
+			return err
+		}
+		offset := float64(r.start.UnixMilli()-base) / 1000
+		maxMs := float64(h.Max()) / hdrhistogram.MsToNsRatio
+		_, err = fmt.Fprintf(out, "Tag=c%d,%.3f,%.3f,%.3f,%s\n", r.concurrency, offset, r.elapsed.Seconds(), maxMs, payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runLoadTest(cfg loadConfig) loadResult {
+	targets := loadTargets(cfg)
+	client := newLoadClient(cfg)
+	warmUp(client, targets, cfg.warmup)
+	if cfg.warmPool {
+		warmPool(client, targets, cfg)
+	}
+
+	res := runLoad(client, targets, cfg.numRequests, cfg.concurrency, cfg.think)
+	res.rssDrained, res.drained = drainPool(client)
+
+	avgLatency := (res.elapsed.Seconds() / float64(cfg.numRequests)) * 1000
+
+	fmt.Printf("workers: %d\n", cfg.concurrency)
+	fmt.Printf("reqs: %d\n", cfg.numRequests)
 	fmt.Printf("latency: %.2fms\n", avgLatency)
-	fmt.Printf("rss_delta: %.1fMiB\n", rssAfter-rssBefore)
+	fmt.Printf("p99: %.2fms\n", float64(res.percentile(99).Microseconds())/1000)
+	verbosity.Detailf("p50/p90/p95/max: %.2fms / %.2fms / %.2fms / %.2fms\n",
+		float64(res.percentile(50).Microseconds())/1000, float64(res.percentile(90).Microseconds())/1000,
+		float64(res.percentile(95).Microseconds())/1000, float64(res.percentile(100).Microseconds())/1000)
+	fmt.Printf("ttfb p50/p99: %.2fms / %.2fms\n",
+		float64(percentile(res.ttfbs, 50).Microseconds())/1000,
+		float64(percentile(res.ttfbs, 99).Microseconds())/1000)
+	fmt.Printf("rps: %.0f\n", res.rps())
+	if ceiling := cfg.think.maxRPS(cfg.concurrency); ceiling > 0 {
+		fmt.Printf("think-time ceiling: %.0f rps (%d workers / %s)\n", ceiling, cfg.concurrency, cfg.think.base)
+	}
+	fmt.Printf("rejected: %d\n", res.rejected)
+	fmt.Printf("errors: %d (%.1f%%)\n", res.errors, res.errorRate()*100)
+	fmt.Printf("bytes/resp: %.0f\n", res.bytesPerRequest())
+	fmt.Printf("rss_delta: %.1fMiB (%s)\n", res.rssDelta, memSource)
+	printDrained(res)
+	if len(res.perPath) > 1 {
+		printPerPath(res)
+	}
+	return res
+}
+
+func printPerPath(res loadResult) {
+	paths := make([]string, 0, len(res.perPath))
+	for p := range res.perPath {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	fmt.Printf("\n%-24s %-8s %-10s %-10s\n", "path", "reqs", "p50", "p99")
+	for _, p := range paths {
+		l := res.perPath[p]
+		fmt.Printf("%-24s %-8d %-10s %-10s\n", p, len(l),
+			fmt.Sprintf("%.2fms", float64(percentile(l, 50).Microseconds())/1000),
+			fmt.Sprintf("%.2fms", float64(percentile(l, 99).Microseconds())/1000))
+	}
+}
+
+// runStreamTest downloads mb megabytes from baseURL's /stream and reports
+// throughput.
+func runStreamTest(baseURL, unixSocket string, mb int) {
+	url := fmt.Sprintf("%s/stream?mb=%d", baseURL, mb)
+	client := &http.Client{Timeout: 5 * time.Minute}
+	if unixSocket != "" {
+		client.Transport = &http.Transport{DialContext: dialUnix(unixSocket)}
+	}
+
+	start := time.Now()
+	var ttfb time.Duration
+	req, err := http.NewRequestWithContext(withFirstByte(context.Background(), &ttfb), http.MethodGet, url, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Stream error: %v\n", err)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Stream error: %v\n", err)
+		return
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Stream error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("stream: %.1fMiB\n", float64(n)/(1024*1024))
+	fmt.Printf("time: %dms\n", elapsed.Milliseconds())
+	fmt.Printf("ttfb: %.2fms\n", float64(ttfb.Microseconds())/1000)
+	fmt.Printf("throughput: %.1fMiB/s\n", float64(n)/(1024*1024)/elapsed.Seconds())
 }
 
-func runBoth(numRequests, concurrency int) {
+// sweepLevels returns 1, 2, 4, ... up to and including maxConcurrency.
+func sweepLevels(maxConcurrency int) []int {
+	var levels []int
+	for c := 1; c < maxConcurrency; c *= 2 {
+		levels = append(levels, c)
+	}
+	return append(levels, maxConcurrency)
+}
+
+// runSweep repeats the load test at concurrency 1, 2, 4, ... up to
+// cfg.concurrency on one warmed-up client, so the saturation point shows up
+// as p99 rising while RPS flattens.
+func runSweep(cfg loadConfig) []loadResult {
+	targets := loadTargets(cfg)
+	client := newLoadClient(cfg)
+	warmUp(client, targets, cfg.warmup)
+	if cfg.warmPool {
+		warmPool(client, targets, cfg)
+	}
+
+	var results []loadResult
+	fmt.Printf("%-8s %-10s %-10s\n", "workers", "p99", "rps")
+	for _, c := range sweepLevels(cfg.concurrency) {
+		res := runLoad(client, targets, cfg.numRequests, c, cfg.think)
+		results = append(results, res)
+		fmt.Printf("%-8d %-10s %-10.0f\n", c, fmt.Sprintf("%.2fms", float64(res.percentile(99).Microseconds())/1000), res.rps())
+	}
+	last := &results[len(results)-1]
+	last.rssDrained, last.drained = drainPool(client)
+	printDrained(*last)
+	return results
+}
+
+// slaThreshold is an upper bound on one latency percentile.
+type slaThreshold struct {
+	name       string // e.g. "p99"
+	percentile float64
+	max        time.Duration
+}
+
+// exit is called with a non-zero status when an SLA is violated; it is a
+// variable so the failure path can be exercised without ending the process.
+var exit = os.Exit
+
+// checkSLA returns one message per threshold a run breached. Thresholds with
+// a zero max are ignored.
+func checkSLA(runs []loadResult, thresholds []slaThreshold) []string {
+	var violations []string
+	for _, res := range runs {
+		for _, t := range thresholds {
+			if t.max <= 0 {
+				continue
+			}
+			if got := res.percentile(t.percentile); got > t.max {
+				violations = append(violations, fmt.Sprintf("workers=%d %s %s > %s",
+					res.concurrency, t.name, got.Round(time.Microsecond), t.max))
+			}
+		}
+	}
+	return violations
+}
+
+// enforceSLA reports every breached threshold on stderr and exits with
+// status 1 if there were any.
+func enforceSLA(runs []loadResult, thresholds []slaThreshold) {
+	violations := checkSLA(runs, thresholds)
+	for _, v := range violations {
+		fmt.Fprintf(os.Stderr, "SLA violated: %s\n", v)
+	}
+	if len(violations) > 0 {
+		exit(1)
+	}
+}
+
+func runBoth(cfg serverConfig, load func()) {
 	addr := HOST + ":" + PORT
-	http.HandleFunc("/", helloHandler)
 
-	server := &http.Server{Addr: addr}
+	server := newServer(addr, cfg)
+	if cfg != (serverConfig{}) {
+		printServerConfig(cfg)
+	}
+	ln, err := listen(addr, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+		os.Exit(1)
+	}
 	go func() {
-		server.ListenAndServe()
+		server.Serve(ln)
 	}()
 
 	time.Sleep(300 * time.Millisecond)
 
-	runLoadTest(numRequests, concurrency)
+	load()
+
+	stopServer(server)
+}
+
+// runHermetic is runBoth on an httptest server bound to an ephemeral port, so
+// the load test needs no fixed PORT and several runs can share a machine.
+func runHermetic(cfg serverConfig, load func(baseURL string)) {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = newServer(srv.Listener.Addr().String(), cfg)
+	if cfg != (serverConfig{}) {
+		printServerConfig(cfg)
+	}
+	baseURL := ""
+	if cfg.unixSocket != "" {
+		ln, err := listen("", cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+			os.Exit(1)
+		}
+		srv.Listener.Close()
+		srv.Listener = ln
+		// httptest would build its URL from the socket path; the host is
+		// ignored by dialUnix anyway.
+		baseURL = "http://unix"
+	}
+	srv.Start()
+	defer srv.Close()
+	defer stopServer(srv.Config)
+
+	if baseURL == "" {
+		baseURL = srv.URL
+	}
+	load(baseURL)
+}
+
+// acceptConnTimeout bounds how long one connection can hold an accept loop,
+// so a client that connects and never sends can't stall it for good.
+const acceptConnTimeout = 10 * time.Second
+
+// serveAcceptLoops starts loops goroutines that all call Accept on ln and
+// serve each connection themselves instead of handing it to a new goroutine
+// as http.Server does, so at most loops connections are in service at once.
+// Each connection gets one request and a Connection: close reply; kept
+// alive, a pooled client's idle connections would hold every loop forever.
+// The goroutines exit once ln is closed.
+func serveAcceptLoops(ln net.Listener, loops, workIters int) {
+	for range loops {
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				if err != nil {
+					// e.g. out of file descriptors; back off as http.Server does.
+					time.Sleep(5 * time.Millisecond)
+					continue
+				}
+				serveOne(conn, workIters)
+			}
+		}()
+	}
+}
+
+// serveOne answers a single HTTP request on conn with the hello body and
+// closes it.
+func serveOne(conn net.Conn, workIters int) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(acceptConnTimeout))
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, req.Body)
+
+	var work string
+	if workIters > 0 {
+		work = "X-Work: " + strconv.FormatUint(busyWork(workIters), 10) + "\r\n"
+	}
+	fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n%s\r\nhello", work)
+}
+
+// sweepServers load-tests a fresh server at each count from
+// sweepLevels(maxN), with one client throughout, and prints how throughput
+// scales with the count. start brings up a server of n units (accept loops,
+// listeners) on an ephemeral port and returns its address and a function
+// that shuts it down. Results are in the same order as the counts.
+func sweepServers(cfg loadConfig, unit string, maxN int, start func(n int) (addr string, stop func(), err error)) []loadResult {
+	client := newLoadClient(cfg)
+	var results []loadResult
+	fmt.Printf("%-10s %-10s %-10s %s\n", unit, "p99", "rps", "errors")
+	for _, n := range sweepLevels(maxN) {
+		addr, stop, err := start(n)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+			os.Exit(1)
+		}
+		lcfg := cfg
+		lcfg.baseURL = "http://" + addr
+		targets := loadTargets(lcfg)
+		warmUp(client, targets, cfg.warmup)
 
-	server.Close()
+		res := runLoad(client, targets, cfg.numRequests, cfg.concurrency, cfg.think)
+		stop()
+		client.HTTP.CloseIdleConnections()
+		results = append(results, res)
+		fmt.Printf("%-10d %-10s %-10.0f %d\n", n, fmt.Sprintf("%.2fms", float64(res.percentile(99).Microseconds())/1000), res.rps(), res.errors)
+	}
+	return results
+}
+
+// runAcceptLoops sweeps serveAcceptLoops servers up to maxLoops loops.
+func runAcceptLoops(cfg loadConfig, maxLoops, workIters int) []loadResult {
+	return sweepServers(cfg, "loops", maxLoops, func(n int) (string, func(), error) {
+		ln, err := net.Listen("tcp", HOST+":0")
+		if err != nil {
+			return "", nil, err
+		}
+		serveAcceptLoops(ln, n, workIters)
+		return ln.Addr().String(), func() { ln.Close() }, nil
+	})
+}
+
+// startReusePort binds n SO_REUSEPORT listeners to one ephemeral port, each
+// served by its own http.Server, and leaves it to the kernel to spread
+// connections across them. The first listener picks the port and the rest
+// join it.
+func startReusePort(n int, cfg serverConfig) (string, func(), error) {
+	var servers []*http.Server
+	stop := func() {
+		for _, s := range servers {
+			stopServer(s)
+		}
+	}
+	addr := HOST + ":0"
+	for range n {
+		ln, err := sysx.ListenReusePort(addr)
+		if err != nil {
+			stop()
+			return "", nil, err
+		}
+		addr = ln.Addr().String()
+		server := newServer(addr, cfg)
+		servers = append(servers, server)
+		go server.Serve(ln)
+	}
+	return addr, stop, nil
+}
+
+// runReusePort sweeps startReusePort servers up to maxListeners listeners;
+// the one-listener run is the baseline.
+func runReusePort(lcfg loadConfig, cfg serverConfig, maxListeners int) []loadResult {
+	return sweepServers(lcfg, "listeners", maxListeners, func(n int) (string, func(), error) {
+		return startReusePort(n, cfg)
+	})
 }
 
 func main() {
-	mode := flag.String("mode", "both", "Run mode: server, client, or both")
+	serverStart = time.Now()
+	mode := flag.String("mode", "both", "Run mode: server, client, both, hermetic (in-process server on an ephemeral port), accept (raw accept loops, see -accept-loops), or reuseport (see -listeners)")
+	listeners := flag.Int("listeners", 4, "For -mode reuseport: largest number of SO_REUSEPORT listeners on one port, each with its own http.Server; runs 1, 2, 4, ... up to this (Linux only)")
+	acceptLoops := flag.Int("accept-loops", 8, "For -mode accept: largest number of goroutines calling Accept on one listener; runs 1, 2, 4, ... up to this")
 	numRequests := flag.Int("n", 1000, "Number of requests")
 	concurrency := flag.Int("c", 50, "Concurrency level")
+	retries := flag.Int("retries", 0, "Retries per request on transport errors and 5xx")
+	warmup := flag.Int("warmup", 10, "Untimed requests sent before the load test (0 = none)")
+	warmPoolFlag := flag.Bool("warm-pool", false, "Before timing, open one connection per worker and hold them all at once so the load test starts with a full pool")
+	sweep := flag.Bool("sweep", false, "Run the load test at concurrency 1, 2, 4, ... up to -c")
+	outputs := report.RegisterFlags()
+	hdrPath := flag.String("hdr", "", "Write load-test latencies to this file in HdrHistogram log format, e.g. out.hgrm")
+	staticFiles := flag.String("static-files", "", "Comma-separated files under /static/ to mix into the load test with /")
+	replayPath := flag.String("replay", "", "Replay requests from this file (one \"METHOD /path\" per line, cycled) instead of hitting /")
+	streamMB := flag.Int("stream-mb", 0, "Download this many MB from /stream instead of running the load test")
+	profileName := flag.String("profile", "default", "Load client transport profile: default, aggressive, or conservative")
+	slaP50 := flag.Duration("sla-p50", 0, "Exit non-zero if the load test's p50 latency exceeds this (0 = off)")
+	slaP95 := flag.Duration("sla-p95", 0, "Exit non-zero if the load test's p95 latency exceeds this (0 = off)")
+	slaP99 := flag.Duration("sla-p99", 0, "Exit non-zero if the load test's p99 latency exceeds this (0 = off)")
+	var cfg serverConfig
+	flag.DurationVar(&cfg.readTimeout, "read-timeout", 0, "Server read timeout (0 = none)")
+	flag.DurationVar(&cfg.writeTimeout, "write-timeout", 0, "Server write timeout (0 = none)")
+	flag.DurationVar(&cfg.idleTimeout, "idle-timeout", 0, "Server keep-alive idle timeout (0 = use read timeout)")
+	flag.IntVar(&cfg.workIters, "work-iters", 0, "Busy-loop iterations the hello handler runs before responding")
+	flag.IntVar(&cfg.maxInFlight, "max-inflight", 0, "Reject hello requests beyond this many in flight with 503 (0 = unlimited)")
+	flag.BoolVar(&cfg.gzip, "gzip", false, "Gzip responses for clients that send Accept-Encoding: gzip")
+	acceptGzipFlag := flag.Bool("accept-gzip", false, "Load client sends Accept-Encoding: gzip")
+	injectLatency := flag.Duration("inject-latency", 0, "Load client delays each request by a random amount up to this (0 = off)")
+	injectFailRate := flag.Float64("inject-fail-rate", 0, "Load client fails this fraction of requests before sending them, e.g. 0.1")
+	bindAddr := flag.String("bind-addr", "", "Dial load-test connections from this local IP, e.g. to pick a NIC (TCP only)")
+	thinkBase := flag.Duration("think-time", 0, "Pause each worker this long between its requests (closed-loop load)")
+	thinkJitter := flag.Duration("think-jitter", 0, "Randomize -think-time by up to plus or minus this much")
+	injectSeed := flag.Uint64("inject-seed", 1, "Seed for -inject-latency and -inject-fail-rate")
+	flag.IntVar(&cfg.poolSize, "pool", 0, "Experimental: serve hello requests on this many pooled workers instead of per-connection goroutines")
+	flag.StringVar(&cfg.unixSocket, "unix", "", "Serve and load over this Unix domain socket path instead of TCP")
+	flag.StringVar(&cfg.staticDir, "static", "", "Serve files from this directory under /static/")
+	flag.Var(&memSource, "mem-source", memstat.FlagUsage)
+	cpuLimit := cpulimit.RegisterFlag()
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
 	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
 
 	// Also check positional argument for mode
 	if flag.NArg() > 0 {
 		*mode = flag.Arg(0)
 	}
 
+	if cfg.staticDir != "" {
+		if info, err := os.Stat(cfg.staticDir); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Invalid -static %q: not a directory\n", cfg.staticDir)
+			os.Exit(1)
+		}
+	}
+
+	profile, ok := transportProfiles[*profileName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown -profile %q: must be default, aggressive, or conservative\n", *profileName)
+		os.Exit(1)
+	}
+	if *injectFailRate < 0 || *injectFailRate > 1 {
+		fmt.Fprintf(os.Stderr, "Invalid -inject-fail-rate %g: must be between 0 and 1\n", *injectFailRate)
+		os.Exit(1)
+	}
+	if *thinkBase < 0 || *thinkJitter < 0 {
+		fmt.Fprintln(os.Stderr, "-think-time and -think-jitter must be >= 0")
+		os.Exit(1)
+	}
+	if *warmup < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -warmup %d: must be >= 0\n", *warmup)
+		os.Exit(1)
+	}
+	if *numRequests < 1 || *concurrency < 1 {
+		fmt.Fprintln(os.Stderr, "-n and -c must be at least 1")
+		os.Exit(1)
+	}
+	lcfg := loadConfig{numRequests: *numRequests, concurrency: *concurrency, retries: *retries, profile: profile, warmup: *warmup, warmPool: *warmPoolFlag, acceptGzip: *acceptGzipFlag, unixSocket: cfg.unixSocket}
+	lcfg.injectLatency, lcfg.injectFailRate, lcfg.injectSeed = *injectLatency, *injectFailRate, *injectSeed
+	lcfg.think = thinkTime{base: *thinkBase, jitter: *thinkJitter}
+	if *bindAddr != "" {
+		if cfg.unixSocket != "" {
+			fmt.Fprintln(os.Stderr, "-bind-addr applies to TCP and can't be combined with -unix")
+			os.Exit(1)
+		}
+		ip, err := parseBindAddr(*bindAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -bind-addr: %v\n", err)
+			os.Exit(1)
+		}
+		lcfg.bindAddr = ip
+	}
+	if *staticFiles != "" {
+		lcfg.paths = []string{"/"}
+		for _, f := range strings.Split(*staticFiles, ",") {
+			lcfg.paths = append(lcfg.paths, "/static/"+strings.TrimPrefix(f, "/"))
+		}
+	}
+	if *replayPath != "" {
+		var err error
+		if lcfg.replay, err = readReplayFile(*replayPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Replay error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var results []report.Result
+	var runs []loadResult
+	load := func() {
+		res := runLoadTest(lcfg)
+		runs = append(runs, res)
+		name := "load"
+		if cfg.poolSize > 0 {
+			name = fmt.Sprintf("load_pool%d", cfg.poolSize)
+		}
+		results = append(results, report.Result{Benchmark: "server", Name: name, Metrics: res.metrics()})
+	}
+	if *sweep {
+		load = func() {
+			for _, res := range runSweep(lcfg) {
+				runs = append(runs, res)
+				name := fmt.Sprintf("sweep_c%d", res.concurrency)
+				results = append(results, report.Result{Benchmark: "server", Name: name, Metrics: res.metrics()})
+			}
+		}
+	}
+	if *streamMB > 0 {
+		load = func() { runStreamTest(lcfg.server(), lcfg.unixSocket, *streamMB) }
+	}
+
 	switch *mode {
 	case "server":
-		runServer()
+		runServer(cfg)
 	case "client":
-		runLoadTest(*numRequests, *concurrency)
+		load()
 	case "both":
-		runBoth(*numRequests, *concurrency)
+		runBoth(cfg, load)
+	case "hermetic":
+		runHermetic(cfg, func(baseURL string) {
+			lcfg.baseURL = baseURL
+			load()
+		})
+	case "accept":
+		if cfg.unixSocket != "" || *acceptLoops < 1 {
+			fmt.Fprintln(os.Stderr, "-mode accept needs -accept-loops >= 1 and runs over TCP only")
+			os.Exit(1)
+		}
+		loops := sweepLevels(*acceptLoops)
+		for i, res := range runAcceptLoops(lcfg, *acceptLoops, cfg.workIters) {
+			runs = append(runs, res)
+			results = append(results, report.Result{Benchmark: "server", Name: fmt.Sprintf("accept_loops%d", loops[i]), Metrics: res.metrics()})
+		}
+	case "reuseport":
+		if cfg.unixSocket != "" || *listeners < 1 {
+			fmt.Fprintln(os.Stderr, "-mode reuseport needs -listeners >= 1 and runs over TCP only")
+			os.Exit(1)
+		}
+		counts := sweepLevels(*listeners)
+		for i, res := range runReusePort(lcfg, cfg, *listeners) {
+			runs = append(runs, res)
+			results = append(results, report.Result{Benchmark: "server", Name: fmt.Sprintf("reuseport_listeners%d", counts[i]), Metrics: res.metrics()})
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown mode: %s\n", *mode)
 		os.Exit(1)
 	}
+
+	if len(runs) > 0 {
+		verbosity.Notef("\nNote: net/http serves every connection on its own goroutine, and with no GIL the\n")
+		verbosity.Notef("handlers run in parallel across GOMAXPROCS; -pool caps that to compare.\n")
+	}
+
+	outputs.WriteOrExit(results...)
+
+	if *hdrPath != "" && len(runs) > 0 {
+		if err := writeHdrLog(*hdrPath, runs); err != nil {
+			fmt.Fprintf(os.Stderr, "HdrHistogram error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote HdrHistogram log to %s\n", *hdrPath)
+	}
+
+	enforceSLA(runs, []slaThreshold{
+		{"p50", 50, *slaP50},
+		{"p95", 95, *slaP95},
+		{"p99", 99, *slaP99},
+	})
 }
@@ -0,0 +1,959 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/python-memory-research/go/httpx"
+)
+
+// newTestServer serves newServer's handler for cfg on an ephemeral port.
+func newTestServer(t *testing.T, cfg serverConfig) *httptest.Server {
+	t.Helper()
+	srv := newServer("", cfg)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(func() {
+		ts.Close()
+		stopServer(srv) // the listener is httptest's, so this only stops the pool
+	})
+	return ts
+}
+
+func TestRunSweep(t *testing.T) {
+	ts := newTestServer(t, serverConfig{})
+	cfg := loadConfig{numRequests: 20, concurrency: 8, warmup: 2, baseURL: ts.URL}
+
+	results := runSweep(cfg)
+	var levels []int
+	for _, res := range results {
+		levels = append(levels, res.concurrency)
+		if res.requests != cfg.numRequests || res.errors != 0 || len(res.latencies) != cfg.numRequests {
+			t.Errorf("workers=%d: %d requests, %d errors, %d latencies; want %d, 0, %d",
+				res.concurrency, res.requests, res.errors, len(res.latencies), cfg.numRequests, cfg.numRequests)
+		}
+	}
+	if want := []int{1, 2, 4, 8}; !slices.Equal(levels, want) {
+		t.Errorf("sweep levels = %v, want %v", levels, want)
+	}
+	if got := sweepLevels(6); !slices.Equal(got, []int{1, 2, 4, 6}) {
+		t.Errorf("sweepLevels(6) = %v, want [1 2 4 6]", got)
+	}
+}
+
+func TestStreamEndpoint(t *testing.T) {
+	ts := newTestServer(t, serverConfig{})
+	resp, err := http.Get(ts.URL + "/stream?mb=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2<<20 {
+		t.Errorf("received %d bytes, want %d", n, 2<<20)
+	}
+	if !slices.Contains(resp.TransferEncoding, "chunked") {
+		t.Errorf("Transfer-Encoding = %v, want chunked", resp.TransferEncoding)
+	}
+}
+
+// TestMakeRequestTTFBExcludesBackoff fails the first attempt and checks that
+// the retry's backoff isn't counted as time to first byte.
+func TestMakeRequestTTFBExcludesBackoff(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client := httpx.New(5 * time.Second)
+	client.Retries = 1
+	client.BaseBackoff = 300 * time.Millisecond
+	status, _, ttfb := makeRequest(client, loadTarget{method: http.MethodGet, url: ts.URL})
+	if status != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("status %d after %d attempts, want 200 after 2", status, calls.Load())
+	}
+	if ttfb >= client.BaseBackoff {
+		t.Errorf("ttfb %s includes the %s backoff", ttfb, client.BaseBackoff)
+	}
+}
+
+func TestMakeRequestTTFBReflectsDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(2 * delay) // body download, after the first byte
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	status, _, ttfb := makeRequest(httpx.New(5*time.Second), loadTarget{method: http.MethodGet, url: ts.URL})
+	total := time.Since(start)
+	if status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if ttfb < delay || ttfb >= 2*delay {
+		t.Errorf("ttfb %s, want about the %s delay before the first byte", ttfb, delay)
+	}
+	if total < 3*delay {
+		t.Errorf("request took %s, shorter than the whole response", total)
+	}
+}
+
+func TestWriteTimeoutCutsSlowResponse(t *testing.T) {
+	srv := newServer("", serverConfig{writeTimeout: 100 * time.Millisecond})
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(w, "too late")
+	})
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.Config = srv
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		return // the connection was closed before any response arrived
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err == nil && string(body) == "too late" {
+		t.Fatal("response written after the write timeout reached the client intact")
+	}
+}
+
+func TestWorkItersSlowsHandler(t *testing.T) {
+	latency := func(workIters int) time.Duration {
+		h := newHelloHandler(workIters)
+		best := time.Duration(1<<63 - 1)
+		for i := 0; i < 5; i++ {
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			best = min(best, time.Since(start))
+			if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+				t.Fatalf("work-iters %d: got %d %q", workIters, rec.Code, rec.Body.String())
+			}
+		}
+		return best
+	}
+
+	idle, busy := latency(0), latency(20_000_000)
+	if busy < 10*idle || busy < time.Millisecond {
+		t.Errorf("handler latency %s with work vs %s without; want a clear increase", busy, idle)
+	}
+}
+
+func TestMaxInFlightRejects(t *testing.T) {
+	// The handler sleeps rather than spins so requests overlap even on a
+	// single CPU.
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		helloHandler(w, r)
+	})
+	ts := httptest.NewServer(chain(slow, limitInFlight(1)))
+	defer ts.Close()
+	cfg := loadConfig{numRequests: 16, concurrency: 8, baseURL: ts.URL}
+
+	res := runLoad(httpx.New(10*time.Second), loadTargets(cfg), cfg.numRequests, cfg.concurrency, thinkTime{})
+	if res.rejected == 0 {
+		t.Error("no request was rejected with max-inflight=1 and 8 concurrent clients")
+	}
+	if res.errors != 0 {
+		t.Errorf("%d requests failed with something other than 503", res.errors)
+	}
+	if res.rejected == cfg.numRequests {
+		t.Error("every request was rejected; want at least one admitted")
+	}
+}
+
+func TestLoadReportsAllocs(t *testing.T) {
+	ts := newTestServer(t, serverConfig{})
+	cfg := loadConfig{numRequests: 20, concurrency: 2, baseURL: ts.URL}
+
+	res := runLoad(httpx.New(5*time.Second), loadTargets(cfg), cfg.numRequests, cfg.concurrency, thinkTime{})
+	m := res.metrics()
+	if m["allocs_per_op"] < float64(cfg.numRequests) {
+		t.Errorf("allocs_per_op = %v for %d requests, want at least one per request", m["allocs_per_op"], cfg.numRequests)
+	}
+	if m["bytes_per_op"] <= 0 {
+		t.Errorf("bytes_per_op = %v, want > 0", m["bytes_per_op"])
+	}
+}
+
+func TestStaticFiles(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("static "), 10_000)
+	if err := os.WriteFile(filepath.Join(dir, "page.txt"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServer(t, serverConfig{staticDir: dir})
+
+	resp, err := http.Get(ts.URL + "/static/page.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+	if resp.ContentLength != int64(len(content)) || !bytes.Equal(body, content) {
+		t.Errorf("Content-Length %d, %d bytes read; want %d matching bytes", resp.ContentLength, len(body), len(content))
+	}
+}
+
+func TestReplayHitsEveryPath(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "replay.txt")
+	if err := os.WriteFile(path, []byte("# two routes\nGET /\n\npost /submit\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	replay, err := readReplayFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadConfig{numRequests: 10, concurrency: 2, replay: replay, baseURL: ts.URL}
+
+	res := runLoad(httpx.New(5*time.Second), loadTargets(cfg), cfg.numRequests, cfg.concurrency, thinkTime{})
+	if res.errors != 0 {
+		t.Errorf("%d requests failed", res.errors)
+	}
+	for _, key := range []string{"GET /", "POST /submit"} {
+		if hits[key] != 5 {
+			t.Errorf("server saw %d %q requests, want 5", hits[key], key)
+		}
+	}
+	for _, p := range []string{"/", "/submit"} {
+		if len(res.perPath[p]) != 5 {
+			t.Errorf("perPath[%q] has %d latencies, want 5", p, len(res.perPath[p]))
+		}
+	}
+}
+
+func TestReadReplayFileRejectsBadLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.txt")
+	for _, content := range []string{"GET\n", "GET no-slash\n", "# only comments\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readReplayFile(path); err == nil {
+			t.Errorf("replay file %q accepted", content)
+		}
+	}
+}
+
+func TestSLABreachExits(t *testing.T) {
+	var code int
+	exited := false
+	defer func(prev func(int)) { exit = prev }(exit)
+	exit = func(c int) { code, exited = c, true }
+
+	// 100 requests, the slowest two at 80ms: p50 is fast, p99 is not.
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Millisecond
+	}
+	latencies[98], latencies[99] = 80*time.Millisecond, 80*time.Millisecond
+	runs := []loadResult{{concurrency: 4, latencies: latencies}}
+
+	enforceSLA(runs, []slaThreshold{{"p50", 50, 10 * time.Millisecond}})
+	if exited {
+		t.Fatal("exited although p50 was within its SLA")
+	}
+
+	thresholds := []slaThreshold{{"p50", 50, 10 * time.Millisecond}, {"p99", 99, 50 * time.Millisecond}}
+	if v := checkSLA(runs, thresholds); len(v) != 1 || !strings.Contains(v[0], "p99") {
+		t.Errorf("violations = %q, want only p99", v)
+	}
+	enforceSLA(runs, thresholds)
+	if !exited || code != 1 {
+		t.Errorf("exited=%v code=%d, want exit(1) on a p99 breach", exited, code)
+	}
+}
+
+func TestRequestIDEcho(t *testing.T) {
+	ts := newTestServer(t, serverConfig{})
+	get := func(id string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get(requestIDHeader)
+	}
+
+	if got := get("client-chosen-42"); got != "client-chosen-42" {
+		t.Errorf("echoed %q, want the client's ID", got)
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	first, second := get(""), get("")
+	if !uuid.MatchString(first) {
+		t.Errorf("generated ID %q isn't a UUID v4", first)
+	}
+	if first == second {
+		t.Errorf("two requests without an ID both got %q", first)
+	}
+}
+
+func TestHermeticRunsInParallel(t *testing.T) {
+	var wg sync.WaitGroup
+	urls := make([]string, 2)
+	results := make([]loadResult, 2)
+	for i := range urls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runHermetic(serverConfig{}, func(baseURL string) {
+				urls[i] = baseURL
+				cfg := loadConfig{numRequests: 20, concurrency: 4, baseURL: baseURL}
+				results[i] = runLoad(httpx.New(5*time.Second), loadTargets(cfg), cfg.numRequests, cfg.concurrency, thinkTime{})
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	if urls[0] == urls[1] {
+		t.Errorf("both instances served on %s", urls[0])
+	}
+	for i, res := range results {
+		if res.requests != 20 || res.errors != 0 || res.rejected != 0 {
+			t.Errorf("instance %d: %d requests, %d errors, %d rejected; want 20, 0, 0", i, res.requests, res.errors, res.rejected)
+		}
+	}
+}
+
+func TestTransportProfiles(t *testing.T) {
+	const concurrency = 8
+	for _, tc := range []struct {
+		profile     string
+		idlePerHost int
+		idleTimeout time.Duration
+		keepAlive   time.Duration
+	}{
+		{"default", 8, 30 * time.Second, 30 * time.Second},
+		{"aggressive", 16, 90 * time.Second, 15 * time.Second},
+		{"conservative", 2, 5 * time.Second, -1},
+	} {
+		p, ok := transportProfiles[tc.profile]
+		if !ok {
+			t.Fatalf("profile %q missing", tc.profile)
+		}
+		tr := newTransport(p, concurrency, nil)
+		if tr.MaxIdleConnsPerHost != tc.idlePerHost || tr.MaxIdleConns != tc.idlePerHost {
+			t.Errorf("%s: MaxIdleConns %d, MaxIdleConnsPerHost %d; want %d", tc.profile, tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tc.idlePerHost)
+		}
+		if tr.IdleConnTimeout != tc.idleTimeout {
+			t.Errorf("%s: IdleConnTimeout %s, want %s", tc.profile, tr.IdleConnTimeout, tc.idleTimeout)
+		}
+		if p.keepAlive != tc.keepAlive {
+			t.Errorf("%s: dialer keep-alive %s, want %s", tc.profile, p.keepAlive, tc.keepAlive)
+		}
+	}
+}
+
+func TestWarmupExcludedFromStats(t *testing.T) {
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer ts.Close()
+
+	for _, warmup := range []int{0, 3} {
+		hits.Store(0)
+		cfg := loadConfig{numRequests: 10, concurrency: 2, warmup: warmup, baseURL: ts.URL}
+		res := runLoadTest(cfg)
+		if got, want := hits.Load(), int64(cfg.numRequests+warmup); got != want {
+			t.Errorf("warmup %d: server saw %d requests, want %d", warmup, got, want)
+		}
+		if res.requests != cfg.numRequests || len(res.latencies) != cfg.numRequests {
+			t.Errorf("warmup %d: %d requests and %d latencies in the stats, want %d", warmup, res.requests, len(res.latencies), cfg.numRequests)
+		}
+	}
+}
+
+// rawGet issues method on url with the given Accept-Encoding and returns the
+// response with its body still compressed.
+func rawGet(t *testing.T, method, url, acceptEncoding string) (*http.Response, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestGzip(t *testing.T) {
+	ts := newTestServer(t, serverConfig{gzip: true})
+
+	resp, body := rawGet(t, http.MethodGet, ts.URL, "gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := io.ReadAll(zr); err != nil || string(plain) != "hello" {
+		t.Errorf("decompressed body %q, %v; want \"hello\"", plain, err)
+	}
+
+	for _, tc := range []struct {
+		method, accept string
+		want           bool
+	}{
+		{http.MethodGet, "deflate, gzip;q=0.5", true},
+		{http.MethodGet, "*", true},
+		{http.MethodGet, "gzip;q=0", false},
+		{http.MethodGet, "gzip; q=0.0, *", false},
+		{http.MethodGet, "*;q=0", false},
+		{http.MethodGet, "gzip;q=bogus", false},
+		{http.MethodGet, "deflate", false},
+		{http.MethodHead, "gzip", false},
+	} {
+		resp, _ := rawGet(t, tc.method, ts.URL, tc.accept)
+		if got := resp.Header.Get("Content-Encoding") == "gzip"; got != tc.want {
+			t.Errorf("%s with Accept-Encoding %q: gzipped=%v, want %v", tc.method, tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestGzipSkipsBodylessResponses(t *testing.T) {
+	ts := httptest.NewServer(withGzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/204":
+			w.WriteHeader(http.StatusNoContent)
+		case "/304":
+			w.WriteHeader(http.StatusNotModified)
+		case "/418":
+			w.WriteHeader(http.StatusTeapot)
+		}
+		// "/empty" writes nothing at all.
+	})))
+	defer ts.Close()
+
+	for path, code := range map[string]int{"/204": 204, "/304": 304, "/418": 418, "/empty": 200} {
+		resp, body := rawGet(t, http.MethodGet, ts.URL+path, "gzip")
+		if resp.StatusCode != code {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, code)
+		}
+		if enc := resp.Header.Get("Content-Encoding"); enc != "" || len(body) != 0 {
+			t.Errorf("%s: Content-Encoding %q with %d body bytes, want a plain empty response", path, enc, len(body))
+		}
+	}
+}
+
+func TestWorkerPoolSerializes(t *testing.T) {
+	for _, tc := range []struct{ workers, wantPeak int }{{1, 1}, {3, 3}} {
+		var inFlight, peak atomic.Int32
+		slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(30 * time.Millisecond)
+		})
+		stop := make(chan struct{})
+		ts := httptest.NewServer(chain(slow, withWorkerPool(tc.workers, stop)))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if resp, err := ts.Client().Get(ts.URL); err == nil {
+					resp.Body.Close()
+				} else {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		ts.Close()
+		close(stop)
+		if p := int(peak.Load()); p != tc.wantPeak {
+			t.Errorf("pool of %d: peak of %d overlapping requests, want %d", tc.workers, p, tc.wantPeak)
+		}
+	}
+}
+
+func TestHdrLogRoundTrip(t *testing.T) {
+	ts := newTestServer(t, serverConfig{})
+	res := runLoadTest(loadConfig{numRequests: 25, concurrency: 4, baseURL: ts.URL})
+
+	path := filepath.Join(t.TempDir(), "out.hgrm")
+	if err := writeHdrLog(path, []loadResult{res}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if info, _ := f.Stat(); info.Size() == 0 {
+		t.Fatal("HdrHistogram log is empty")
+	}
+	h, err := hdrhistogram.NewHistogramLogReader(f).NextIntervalHistogram()
+	if err != nil || h == nil {
+		t.Fatalf("reading the interval histogram: %v", err)
+	}
+	if h.TotalCount() != 25 || h.Tag() != "c4" {
+		t.Errorf("histogram %q holds %d values, want c4 with 25", h.Tag(), h.TotalCount())
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" in")
+				h.ServeHTTP(w, r)
+				calls = append(calls, name+" out")
+			})
+		}
+	}
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}), mark("a"), mark("b"), mark("c"))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	want := []string{"a in", "b in", "c in", "handler", "c out", "b out", "a out"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls %q, want %q", calls, want)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.sock")
+
+	// A socket file left behind by an earlier run is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("Unix sockets unavailable: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := serverConfig{unixSocket: path}
+	ln, err := listen("", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer("", cfg)
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: dialUnix(path)}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("body over the socket %q, want %q", body, "hello")
+	}
+
+	// A regular file at the path is never deleted.
+	file := filepath.Join(dir, "not-a-socket")
+	if err := os.WriteFile(file, []byte("keep me"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("", serverConfig{unixSocket: file}); err == nil {
+		t.Error("listen replaced a regular file")
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "keep me" {
+		t.Errorf("regular file after listen: %q, %v", data, err)
+	}
+}
+
+func TestStatsEndpoint(t *testing.T) {
+	ts := newTestServer(t, serverConfig{})
+	get := func() runtimeStats {
+		t.Helper()
+		resp, err := ts.Client().Get(ts.URL + "/stats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type %q", ct)
+		}
+		var s runtimeStats
+		if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+			t.Fatalf("decoding /stats: %v", err)
+		}
+		return s
+	}
+
+	first := get()
+	time.Sleep(20 * time.Millisecond)
+	second := get()
+	if first.NumGoroutine <= 0 || first.GOMAXPROCS <= 0 || first.HeapAlloc == 0 {
+		t.Errorf("implausible snapshot %+v", first)
+	}
+	if second.UptimeSeconds <= first.UptimeSeconds {
+		t.Errorf("uptime went from %v to %v", first.UptimeSeconds, second.UptimeSeconds)
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestInjectedFailuresNeedNoServer(t *testing.T) {
+	// Nothing listens on port 1; with every request failed before it is
+	// sent, the load test never tries to connect.
+	cfg := loadConfig{numRequests: 40, concurrency: 4, injectFailRate: 1, baseURL: "http://127.0.0.1:1"}
+	res := runLoadTest(cfg)
+	if res.errors != cfg.numRequests || res.errorRate() != 1 {
+		t.Errorf("%d errors (rate %v) for %d requests, want all of them", res.errors, res.errorRate(), cfg.numRequests)
+	}
+
+	var reached atomic.Int32
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		reached.Add(1)
+		return nil, errors.New("unreachable")
+	})
+	client := &http.Client{Transport: newFaultInjector(base, 0, 1, 1)}
+	for i := 0; i < 10; i++ {
+		if _, err := client.Get("http://example.invalid/"); !errors.Is(err, errInjected) {
+			t.Fatalf("request %d: error %v, want %v", i, err, errInjected)
+		}
+	}
+	if n := reached.Load(); n != 0 {
+		t.Errorf("%d requests reached the wrapped transport", n)
+	}
+}
+
+func TestWarmPoolOpensConnections(t *testing.T) {
+	// httptest.NewServer would drop newServer's ConnState hook, and with it
+	// the open-connection count /stats reports.
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newServer("", serverConfig{})
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	const concurrency = 8
+	cfg := loadConfig{concurrency: concurrency, baseURL: ts.URL}
+	client := newLoadClient(cfg)
+	if dialed := fillPool(client, loadTargets(cfg), concurrency); dialed != concurrency {
+		t.Errorf("warm-up dialed %d connections, want %d", dialed, concurrency)
+	}
+	open, err := serverOpenConns(client, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open < concurrency {
+		t.Errorf("server reports %d open connections after warm-up, want at least %d", open, concurrency)
+	}
+}
+
+func TestEventsStopsOnDisconnect(t *testing.T) {
+	returned := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(returned)
+		eventsHandler(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type %q, want text/event-stream", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	for events := 0; events < 2; {
+		if !lines.Scan() {
+			t.Fatalf("stream ended after %d events: %v", events, lines.Err())
+		}
+		data, ok := strings.CutPrefix(lines.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var stats runtimeStats
+		if err := json.Unmarshal([]byte(data), &stats); err != nil {
+			t.Fatalf("event %d: %v", events, err)
+		}
+		events++
+	}
+
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still running after the client disconnected")
+	}
+}
+
+func TestThinkTimeBoundsRPS(t *testing.T) {
+	ts := newTestServer(t, serverConfig{})
+	const concurrency, numRequests = 4, 40
+	think := thinkTime{base: 20 * time.Millisecond}
+	if got := think.maxRPS(concurrency); got != 200 {
+		t.Fatalf("maxRPS = %v, want 4 workers / 20ms = 200", got)
+	}
+
+	cfg := loadConfig{numRequests: numRequests, concurrency: concurrency, baseURL: ts.URL}
+	res := runLoad(httpx.New(5*time.Second), loadTargets(cfg), numRequests, concurrency, think)
+	if res.requests != numRequests || res.errors != 0 {
+		t.Fatalf("%d requests, %d errors; want %d, 0", res.requests, res.errors, numRequests)
+	}
+	// A worker doesn't pause before its first request, so the busiest of them
+	// pauses at least perWorker-1 times; the ceiling is scaled to match.
+	perWorker := numRequests / concurrency
+	if minElapsed := time.Duration(perWorker-1) * think.base; res.elapsed < minElapsed {
+		t.Errorf("run took %s, want at least %s of think time", res.elapsed, minElapsed)
+	}
+	if limit := think.maxRPS(concurrency) * float64(perWorker) / float64(perWorker-1); res.rps() > limit {
+		t.Errorf("achieved %.0f rps, above the think-time ceiling of %.0f", res.rps(), limit)
+	}
+}
+
+func TestBindAddr(t *testing.T) {
+	for _, bad := range []string{"not-an-ip", "192.0.2.1"} { // 192.0.2.0/24 is reserved for documentation
+		if _, err := parseBindAddr(bad); err == nil {
+			t.Errorf("parseBindAddr(%q) accepted it", bad)
+		}
+	}
+
+	// Linux routes all of 127.0.0.0/8 to loopback, so 127.0.0.2 shows the
+	// bind took effect where 127.0.0.1 would be the default source anyway.
+	ip, err := parseBindAddr("127.0.0.2")
+	if err != nil {
+		t.Skipf("no loopback alias to bind to: %v", err)
+	}
+	tr := newTransport(transportProfiles["default"], 1, ip)
+	t.Cleanup(tr.CloseIdleConnections)
+
+	remotes := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		remotes <- host
+	}))
+	t.Cleanup(ts.Close)
+	resp, err := (&http.Client{Transport: tr}).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if remote := <-remotes; remote != "127.0.0.2" {
+		t.Errorf("server saw the request from %s, want 127.0.0.2", remote)
+	}
+}
+
+func TestDrainPoolClosesIdleConnections(t *testing.T) {
+	ts := newTestServer(t, serverConfig{})
+	// gzip and fault injection wrap the transport, so this also checks that
+	// the drain reaches through them.
+	cfg := loadConfig{concurrency: 2, baseURL: ts.URL, acceptGzip: true, injectLatency: time.Microsecond}
+	client := newLoadClient(cfg)
+	targets := loadTargets(cfg)
+
+	reused := func() bool {
+		t.Helper()
+		var got bool
+		ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { got = info.Reused },
+		})
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, targets[0].url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.HTTP.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return got
+	}
+
+	reused()
+	if !reused() {
+		t.Fatal("second request didn't reuse the first's connection")
+	}
+	mb, ok := drainPool(client)
+	if runtime.GOOS == "linux" && (!ok || mb <= 0) {
+		t.Errorf("drainPool read %v MiB, ok %v; want the current RSS", mb, ok)
+	}
+	if reused() {
+		t.Error("request after the drain reused a pooled connection")
+	}
+}
+
+func TestAcceptLoopsServe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveAcceptLoops(ln, 2, 10)
+	t.Cleanup(func() { ln.Close() })
+
+	// More requests than loops, in parallel, so the loops must each serve
+	// several connections in turn.
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get("http://" + ln.Addr().String() + "/")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil || resp.StatusCode != http.StatusOK || string(body) != "hello" {
+				t.Errorf("got %d %q, err %v; want 200 \"hello\"", resp.StatusCode, body, err)
+			}
+			if resp.Header.Get("X-Work") == "" {
+				t.Error("response lacks the X-Work header for workIters > 0")
+			}
+			if !resp.Close {
+				t.Error("response doesn't close the connection")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWorkerPoolDropsAbandonedRequests(t *testing.T) {
+	var served atomic.Int32
+	release := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		<-release
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	ts := httptest.NewServer(chain(slow, withWorkerPool(1, stop)))
+	defer ts.Close()
+
+	// The first request holds the only worker.
+	first := make(chan error, 1)
+	go func() {
+		resp, err := ts.Client().Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		first <- err
+	}()
+	for served.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The second gives up while queued behind it.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("queued request finished while the worker was busy")
+	}
+
+	// Give the server time to see the disconnect before the worker frees up.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	// Had the abandoned request stayed queued, the free worker would take it now.
+	time.Sleep(50 * time.Millisecond)
+	if n := served.Load(); n != 1 {
+		t.Errorf("%d requests served, want 1: the abandoned one took a worker", n)
+	}
+}
+
+func TestWorkerPoolStopsOnShutdown(t *testing.T) {
+	before := runtime.NumGoroutine()
+	srv := newServer("", serverConfig{poolSize: 8})
+	if n := runtime.NumGoroutine(); n < before+8 {
+		t.Fatalf("%d goroutines after starting a pool of 8, %d before", n, before)
+	}
+	stopServer(srv)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines after shutdown, %d before: the pool's workers leaked", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,105 +1,1150 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/python-memory-research/go/cpulimit"
+	"github.com/python-memory-research/go/memstat"
+	"github.com/python-memory-research/go/report"
+	"github.com/python-memory-research/go/sysx"
+	"github.com/python-memory-research/go/tracing"
+	"github.com/python-memory-research/go/verbosity"
 )
 
 const (
 	SIZE     = 4000
 	MAX_ITER = 50
+
+	minIter = 1
+	maxIter = 1 << 20
+
+	// escapeRadius is where a point counts as escaped; the loops compare
+	// |z|² against its square, 4.
+	escapeRadius = 2.0
 )
 
+// renderConfig describes the viewport being rendered. Zoom 1 shows the
+// classic [-1.5, 0.5] x [-1, 1] window centred on (-0.5, 0).
+type renderConfig struct {
+	size    int
+	maxIter int
+	centerX float64
+	centerY float64
+	zoom    float64
+
+	// Derived from the fields above by newRenderConfig.
+	scale   float64 // complex-plane distance between adjacent pixels
+	originX float64
+	originY float64
+
+	// single switches computeRowInto to float32 arithmetic (-precision 32).
+	// The tile, escape-count and anti-aliased kernels are float64 only.
+	single bool
+}
+
+// newRenderConfig validates the viewport and precomputes the pixel mapping.
+// Non-finite inputs and zero zoom are rejected rather than rendered, since they
+// turn every coordinate into NaN/Inf and silently produce an all-black image.
+// maxIter is clamped to [minIter, maxIter].
+func newRenderConfig(size, iters int, centerX, centerY, zoom float64) (renderConfig, error) {
+	if size <= 0 {
+		return renderConfig{}, fmt.Errorf("size must be positive, got %d", size)
+	}
+	if math.IsNaN(centerX) || math.IsInf(centerX, 0) || math.IsNaN(centerY) || math.IsInf(centerY, 0) {
+		return renderConfig{}, fmt.Errorf("center must be finite, got (%v, %v)", centerX, centerY)
+	}
+	if math.IsNaN(zoom) || math.IsInf(zoom, 0) {
+		return renderConfig{}, fmt.Errorf("zoom must be finite, got %v", zoom)
+	}
+	if zoom <= 0 {
+		return renderConfig{}, fmt.Errorf("zoom must be greater than zero, got %v", zoom)
+	}
+
+	scale := 2.0 / (zoom * float64(size))
+	if scale == 0 || math.IsInf(scale, 0) {
+		return renderConfig{}, fmt.Errorf("zoom %v is out of range for a %dpx render", zoom, size)
+	}
+	half := float64(size) / 2
+	originX := centerX - half*scale
+	originY := centerY - half*scale
+	if math.IsInf(originX, 0) || math.IsInf(originY, 0) ||
+		math.IsInf(originX+float64(size)*scale, 0) || math.IsInf(originY+float64(size)*scale, 0) {
+		return renderConfig{}, errors.New("viewport overflows float64; move the center closer to the origin")
+	}
+
+	if iters < minIter {
+		iters = minIter
+	} else if iters > maxIter {
+		iters = maxIter
+	}
+
+	return renderConfig{
+		size:    size,
+		maxIter: iters,
+		centerX: centerX,
+		centerY: centerY,
+		zoom:    zoom,
+		scale:   scale,
+		originX: originX,
+		originY: originY,
+	}, nil
+}
+
+// memSource selects what getRSSMiB reads; set from -mem-source in main.
+var memSource = memstat.Default
+
 func getRSSMiB() float64 {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	return float64(m.HeapAlloc) / (1024 * 1024)
-}
-
-func computeRow(y int) []byte {
-	row := make([]byte, (SIZE+7)/8)
-	c1 := 2.0 / float64(SIZE)
-	ci := float64(y)*c1 - 1.0
-
-	for x := 0; x < SIZE; x++ {
-		cr := float64(x)*c1 - 1.5
-		zr, zi := cr, ci
-
-		inside := true
-		for i := 0; i < MAX_ITER; i++ {
-			zr2, zi2 := zr*zr, zi*zi
-			if zr2+zi2 > 4.0 {
-				inside = false
-				break
-			}
-			zi = 2.0*zr*zi + ci
-			zr = zr2 - zi2 + cr
+	return memSource.Reader()()
+}
+
+func computeRow(cfg renderConfig, y int) []byte {
+	row := make([]byte, rowBytes(cfg))
+	computeRowInto(cfg, y, row)
+	return row
+}
+
+func rowBytes(cfg renderConfig) int {
+	return (cfg.size + 7) / 8
+}
+
+// computeRowInto renders row y into row, which must be rowBytes(cfg) long.
+// Any previous contents are overwritten.
+func computeRowInto(cfg renderConfig, y int, row []byte) {
+	if cfg.single {
+		computeRowInto32(cfg, y, row)
+		return
+	}
+	clear(row)
+	ci := float64(y)*cfg.scale + cfg.originY
+
+	for x := 0; x < cfg.size; x++ {
+		cr := float64(x)*cfg.scale + cfg.originX
+		if escapeIter(cr, ci, cfg.maxIter) == cfg.maxIter {
+			row[x/8] |= (128 >> (x % 8))
 		}
+	}
+}
 
-		if inside {
+// computeRowInto32 is computeRowInto in float32. Each pixel's coordinate is
+// mapped in float64 and then rounded, so the difference comes from the
+// iteration itself and from float32's 24-bit mantissa: adjacent pixels
+// collapse onto the same value once the pixel spacing drops below about
+// 1e-7, which shows up as blocky artifacts at high zoom.
+func computeRowInto32(cfg renderConfig, y int, row []byte) {
+	clear(row)
+	ci := float32(float64(y)*cfg.scale + cfg.originY)
+
+	for x := 0; x < cfg.size; x++ {
+		cr := float32(float64(x)*cfg.scale + cfg.originX)
+		if escapeIter(cr, ci, cfg.maxIter) == cfg.maxIter {
 			row[x/8] |= (128 >> (x % 8))
 		}
 	}
+}
+
+// differingPixels counts the pixels that are set in one bit-packed image and
+// not the other.
+func differingPixels(a, b [][]byte) int {
+	n := 0
+	for y := range a {
+		for i := range a[y] {
+			n += bits.OnesCount8(a[y][i] ^ b[y][i])
+		}
+	}
+	return n
+}
+
+// escapeIter is the one escape-time kernel every renderer uses: it returns
+// the iteration at which c = cr + ci·i escapes |z| > 2, or maxIter if it
+// stays bounded, so a pixel is inside the set exactly when the result is
+// maxIter. It is small enough to inline into the row and tile loops.
+func escapeIter[F float32 | float64](cr, ci F, maxIter int) int {
+	zr, zi := cr, ci
+	for i := 0; i < maxIter; i++ {
+		zr2, zi2 := zr*zr, zi*zi
+		if zr2+zi2 > 4.0 {
+			return i
+		}
+		zi = 2.0*zr*zi + ci
+		zr = zr2 - zi2 + cr
+	}
+	return maxIter
+}
+
+// escapeTime is escapeIter in float64 at cfg's iteration limit.
+func escapeTime(cfg renderConfig, cr, ci float64) int {
+	return escapeIter(cr, ci, cfg.maxIter)
+}
+
+// computeRowIters renders row y one byte per pixel instead of bit-packed:
+// escaped pixels hold their escape iteration (saturating at 254) and pixels
+// inside the set hold 255, so inside/outside matches computeRow exactly.
+func computeRowIters(cfg renderConfig, y int) []byte {
+	row := make([]byte, cfg.size)
+	ci := float64(y)*cfg.scale + cfg.originY
+	for x := range row {
+		cr := float64(x)*cfg.scale + cfg.originX
+		if it := escapeTime(cfg, cr, ci); it == cfg.maxIter {
+			row[x] = 255
+		} else {
+			row[x] = byte(min(it, 254))
+		}
+	}
+	return row
+}
+
+func mandelbrotItersSequential(cfg renderConfig) [][]byte {
+	result := make([][]byte, cfg.size)
+	for y := 0; y < cfg.size; y++ {
+		result[y] = computeRowIters(cfg, y)
+	}
+	return result
+}
+
+func mandelbrotItersThreaded(cfg renderConfig) [][]byte {
+	result := make([][]byte, cfg.size)
+	forEachRowParallel(cfg.size, func(y int) {
+		result[y] = computeRowIters(cfg, y)
+	})
+	return result
+}
+
+// computeRowAA renders row y with aa×aa supersampling, one byte per pixel.
+// Each pixel is the mean escape time of its subsamples scaled to 0..255, so
+// 255 means every subsample stayed inside the set. Subsamples start at the
+// pixel's corner, so aa=1 samples the same points as computeRow.
+func computeRowAA(cfg renderConfig, y, aa int) []byte {
+	row := make([]byte, cfg.size)
+	step := cfg.scale / float64(aa)
+	samples := aa * aa
+
+	for x := 0; x < cfg.size; x++ {
+		total := 0
+		for sy := 0; sy < aa; sy++ {
+			ci := float64(y)*cfg.scale + cfg.originY + float64(sy)*step
+			for sx := 0; sx < aa; sx++ {
+				cr := float64(x)*cfg.scale + cfg.originX + float64(sx)*step
+				total += escapeTime(cfg, cr, ci)
+			}
+		}
+		row[x] = byte(total * 255 / (samples * cfg.maxIter))
+	}
 
 	return row
 }
 
-func mandelbrotSequential() [][]byte {
-	result := make([][]byte, SIZE)
-	for y := 0; y < SIZE; y++ {
-		result[y] = computeRow(y)
+// mandelbrotAA renders a supersampled greyscale image on GOMAXPROCS workers.
+func mandelbrotAA(cfg renderConfig, aa int) [][]byte {
+	result := make([][]byte, cfg.size)
+	forEachRowParallel(cfg.size, func(y int) {
+		result[y] = computeRowAA(cfg, y, aa)
+	})
+	return result
+}
+
+func mandelbrotSequential(cfg renderConfig) [][]byte {
+	result := make([][]byte, cfg.size)
+	for y := 0; y < cfg.size; y++ {
+		result[y] = computeRow(cfg, y)
 	}
 	return result
 }
 
-func mandelbrotThreaded() [][]byte {
-	result := make([][]byte, SIZE)
+func mandelbrotThreaded(cfg renderConfig) [][]byte {
+	result := make([][]byte, cfg.size)
+	forEachRowParallel(cfg.size, func(y int) {
+		result[y] = computeRow(cfg, y)
+	})
+	return result
+}
+
+// forEachRowParallel calls fn for every row index, spread over GOMAXPROCS
+// workers pulling from a shared queue.
+func forEachRowParallel(size int, fn func(y int)) {
+	forEachRowCounted(size, fn)
+}
+
+// forEachRowCounted is forEachRowParallel that also returns how many rows
+// each worker completed. Every worker only touches its own slot, so the
+// counting needs no synchronization.
+func forEachRowCounted(size int, fn func(y int)) []int {
 	var wg sync.WaitGroup
 
 	workers := runtime.GOMAXPROCS(0)
-	jobs := make(chan int, SIZE)
+	jobs := make(chan int, size)
+	counts := make([]int, workers)
 
 	for w := 0; w < workers; w++ {
 		wg.Add(1)
-		go func() {
+		go func(w int) {
 			defer wg.Done()
 			for y := range jobs {
-				result[y] = computeRow(y)
+				fn(y)
+				counts[w]++
 			}
-		}()
+		}(w)
+	}
+
+	for y := 0; y < size; y++ {
+		jobs <- y
+	}
+	close(jobs)
+
+	wg.Wait()
+	return counts
+}
+
+// workerSpread summarizes rows per worker; a large stddev relative to the
+// mean means some workers drew cheap rows and others interior-heavy ones.
+type workerSpread struct {
+	total    int
+	min, max int
+	mean     float64
+	stddev   float64 // population standard deviation
+}
+
+func spreadOf(counts []int) workerSpread {
+	if len(counts) == 0 {
+		return workerSpread{}
 	}
+	s := workerSpread{min: counts[0], max: counts[0]}
+	for _, c := range counts {
+		s.total += c
+		s.min = min(s.min, c)
+		s.max = max(s.max, c)
+	}
+	s.mean = float64(s.total) / float64(len(counts))
+	var sq float64
+	for _, c := range counts {
+		d := float64(c) - s.mean
+		sq += d * d
+	}
+	s.stddev = math.Sqrt(sq / float64(len(counts)))
+	return s
+}
+
+func mandelbrotCounted(cfg renderConfig) ([][]byte, []int) {
+	result := make([][]byte, cfg.size)
+	counts := forEachRowCounted(cfg.size, func(y int) {
+		result[y] = computeRow(cfg, y)
+	})
+	return result, counts
+}
+
+// forEachRowPinned is forEachRowParallel with every worker locked to its own
+// OS thread and, when cpus is non-empty, that thread pinned to
+// cpus[worker % len(cpus)]. Pinning errors are returned after the render.
+func forEachRowPinned(size int, cpus []int, fn func(y int)) error {
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var pinErr error
+
+	workers := runtime.GOMAXPROCS(0)
+	jobs := make(chan int, size)
 
-	for y := 0; y < SIZE; y++ {
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			if len(cpus) > 0 {
+				if err := sysx.PinToCPU(cpus[w%len(cpus)]); err != nil {
+					errOnce.Do(func() { pinErr = fmt.Errorf("pinning worker %d: %w", w, err) })
+				}
+			}
+			for y := range jobs {
+				fn(y)
+			}
+		}(w)
+	}
+
+	for y := 0; y < size; y++ {
 		jobs <- y
 	}
 	close(jobs)
 
 	wg.Wait()
+	return pinErr
+}
+
+func mandelbrotPinned(cfg renderConfig, cpus []int) ([][]byte, error) {
+	result := make([][]byte, cfg.size)
+	err := forEachRowPinned(cfg.size, cpus, func(y int) {
+		result[y] = computeRow(cfg, y)
+	})
+	return result, err
+}
+
+// parseCPUList parses "0,2,3" into CPU indices.
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, f := range strings.Split(s, ",") {
+		if f == "" {
+			continue
+		}
+		cpu, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || cpu < 0 {
+			return nil, fmt.Errorf("invalid CPU %q", f)
+		}
+		cpus = append(cpus, cpu)
+	}
+	return cpus, nil
+}
+
+// rowQueue is one worker's share of rows. The owner pops from the front;
+// idle workers steal from the back.
+type rowQueue struct {
+	mu   sync.Mutex
+	rows []int
+}
+
+func (q *rowQueue) popFront() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.rows) == 0 {
+		return 0, false
+	}
+	y := q.rows[0]
+	q.rows = q.rows[1:]
+	return y, true
+}
+
+func (q *rowQueue) popBack() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.rows) == 0 {
+		return 0, false
+	}
+	y := q.rows[len(q.rows)-1]
+	q.rows = q.rows[:len(q.rows)-1]
+	return y, true
+}
+
+// forEachRowSharded is forEachRowParallel with one queue per worker instead
+// of a shared channel: rows are dealt round-robin, each worker drains its own
+// queue without contention and only then steals from the others. It returns
+// how many rows were stolen.
+func forEachRowSharded(size int, fn func(y int)) int {
+	workers := runtime.GOMAXPROCS(0)
+	queues := make([]*rowQueue, workers)
+	for w := range queues {
+		queues[w] = &rowQueue{}
+	}
+	for y := 0; y < size; y++ {
+		q := queues[y%workers]
+		q.rows = append(q.rows, y)
+	}
+
+	var steals atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for {
+				if y, ok := queues[w].popFront(); ok {
+					fn(y)
+					continue
+				}
+				stolen := false
+				for i := 1; i < workers; i++ {
+					if y, ok := queues[(w+i)%workers].popBack(); ok {
+						steals.Add(1)
+						fn(y)
+						stolen = true
+						break
+					}
+				}
+				// Queues only shrink, so once a full pass finds nothing we're done.
+				if !stolen {
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return int(steals.Load())
+}
+
+func mandelbrotSharded(cfg renderConfig) ([][]byte, int) {
+	result := make([][]byte, cfg.size)
+	steals := forEachRowSharded(cfg.size, func(y int) {
+		result[y] = computeRow(cfg, y)
+	})
+	return result, steals
+}
+
+// tile is a rectangle of the bit-packed image, [x0,x1) x [y0,y1), rendered
+// on its own. x0 is a multiple of 8 so each tile row starts on a byte
+// boundary and stitching is a plain copy.
+type tile struct {
+	x0, y0, x1, y1 int
+	rows           [][]byte // y1-y0 rows of bytes covering pixels x0..x1-1
+}
+
+// computeTile renders one tile with the same escapeIter kernel as
+// computeRowInto, so a stitched image is identical to a per-row one.
+func computeTile(cfg renderConfig, x0, y0, x1, y1 int) tile {
+	t := tile{x0: x0, y0: y0, x1: x1, y1: y1, rows: make([][]byte, y1-y0)}
+	width := (x1+7)/8 - x0/8
+	for y := y0; y < y1; y++ {
+		row := make([]byte, width)
+		ci := float64(y)*cfg.scale + cfg.originY
+		for x := x0; x < x1; x++ {
+			cr := float64(x)*cfg.scale + cfg.originX
+			if escapeIter(cr, ci, cfg.maxIter) == cfg.maxIter {
+				row[(x-x0)/8] |= (128 >> (x % 8))
+			}
+		}
+		t.rows[y-y0] = row
+	}
+	return t
+}
+
+// stitchTile copies t into its place in img.
+func stitchTile(img [][]byte, t tile) {
+	for i, row := range t.rows {
+		copy(img[t.y0+i][t.x0/8:], row)
+	}
+}
+
+// mandelbrotTiled splits the image into tileSize squares (tileSize must be a
+// multiple of 8), renders them on GOMAXPROCS workers and stitches the results
+// on the calling goroutine, so workers never share the output buffer.
+func mandelbrotTiled(cfg renderConfig, tileSize int) [][]byte {
+	type bounds struct{ x0, y0, x1, y1 int }
+	var jobs []bounds
+	for y0 := 0; y0 < cfg.size; y0 += tileSize {
+		for x0 := 0; x0 < cfg.size; x0 += tileSize {
+			jobs = append(jobs, bounds{x0, y0, min(x0+tileSize, cfg.size), min(y0+tileSize, cfg.size)})
+		}
+	}
+
+	queue := make(chan bounds, len(jobs))
+	for _, b := range jobs {
+		queue <- b
+	}
+	close(queue)
+
+	tiles := make(chan tile, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range queue {
+				tiles <- computeTile(cfg, b.x0, b.y0, b.x1, b.y1)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(tiles)
+	}()
+
+	result := allocateImage(cfg)
+	for t := range tiles {
+		stitchTile(result, t)
+	}
 	return result
 }
 
-func benchmark(name string, fn func() [][]byte) {
+// sameImage reports whether two bit-packed renders are byte-for-byte equal.
+func sameImage(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for y := range a {
+		if !bytes.Equal(a[y], b[y]) {
+			return false
+		}
+	}
+	return true
+}
+
+// rowPool recycles row buffers between renders. A buffer is only put back
+// once the image holding it is released, never while a worker could still
+// be writing it, so rows of one image never alias each other. The pool holds
+// *[]byte so that Get and Put don't allocate a slice header each time.
+type rowPool struct {
+	p sync.Pool
+}
+
+func newRowPool(cfg renderConfig) *rowPool {
+	n := rowBytes(cfg)
+	return &rowPool{p: sync.Pool{New: func() any {
+		b := make([]byte, n)
+		return &b
+	}}}
+}
+
+// pooledImage is a render whose rows belong to a rowPool.
+type pooledImage struct {
+	rows [][]byte
+	bufs []*[]byte // bufs[y] backs rows[y]
+}
+
+// release hands every row back to p; the image must not be used after.
+func (img pooledImage) release(p *rowPool) {
+	for _, b := range img.bufs {
+		p.p.Put(b)
+	}
+}
+
+// mandelbrotPooled is mandelbrotThreaded with rows taken from pool instead
+// of freshly allocated, so repeated renders stop feeding the GC.
+func mandelbrotPooled(cfg renderConfig, pool *rowPool) pooledImage {
+	img := pooledImage{rows: make([][]byte, cfg.size), bufs: make([]*[]byte, cfg.size)}
+	forEachRowParallel(cfg.size, func(y int) {
+		b := pool.p.Get().(*[]byte)
+		computeRowInto(cfg, y, *b)
+		img.rows[y], img.bufs[y] = *b, b
+	})
+	return img
+}
+
+// mallocs returns how many heap allocations fn made.
+func mallocs(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.Mallocs - before.Mallocs
+}
+
+// allocateImage returns a zeroed cfg.size x rowBytes(cfg) bitmap for the
+// *Into renderers, so allocation can happen outside the timed region.
+func allocateImage(cfg renderConfig) [][]byte {
+	result := make([][]byte, cfg.size)
+	for y := range result {
+		result[y] = make([]byte, rowBytes(cfg))
+	}
+	return result
+}
+
+func mandelbrotSequentialInto(cfg renderConfig, result [][]byte) {
+	for y := 0; y < cfg.size; y++ {
+		computeRowInto(cfg, y, result[y])
+	}
+}
+
+func mandelbrotThreadedInto(cfg renderConfig, result [][]byte) {
+	forEachRowParallel(cfg.size, func(y int) {
+		computeRowInto(cfg, y, result[y])
+	})
+}
+
+// writePBM writes a bit-packed image as a binary PBM (P4), whose rows use
+// the same 8-pixels-per-byte, MSB-first layout as computeRow.
+func writePBM(w io.Writer, cfg renderConfig, img [][]byte) error {
+	if _, err := fmt.Fprintf(w, "P4\n%d %d\n", cfg.size, cfg.size); err != nil {
+		return err
+	}
+	for _, row := range img {
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mandelbrotSequentialTo renders like mandelbrotSequential but writes each
+// row to w as a PBM as soon as it is done, reusing one row buffer, so peak
+// memory no longer grows with the image.
+func mandelbrotSequentialTo(cfg renderConfig, w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "P4\n%d %d\n", cfg.size, cfg.size); err != nil {
+		return err
+	}
+	row := make([]byte, rowBytes(cfg))
+	for y := 0; y < cfg.size; y++ {
+		computeRowInto(cfg, y, row)
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderMetadata is the sidecar written next to a rendered image, with enough
+// to reproduce the render and compare it with others.
+type renderMetadata struct {
+	Image          string  `json:"image"`
+	Size           int     `json:"size"`
+	MaxIter        int     `json:"max_iter"`
+	CenterX        float64 `json:"center_x"`
+	CenterY        float64 `json:"center_y"`
+	Zoom           float64 `json:"zoom"`
+	EscapeRadius   float64 `json:"escape_radius"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Workers        int     `json:"workers"`
+}
+
+// sidecarPath returns imagePath with its extension replaced by .json.
+func sidecarPath(imagePath string) string {
+	return strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + ".json"
+}
+
+// writeSidecar writes the render parameters of imagePath to its sidecar and
+// returns the sidecar's path.
+func writeSidecar(imagePath string, cfg renderConfig, elapsed time.Duration, workers int) (string, error) {
+	meta := renderMetadata{
+		Image:          filepath.Base(imagePath),
+		Size:           cfg.size,
+		MaxIter:        cfg.maxIter,
+		CenterX:        cfg.centerX,
+		CenterY:        cfg.centerY,
+		Zoom:           cfg.zoom,
+		EscapeRadius:   escapeRadius,
+		ElapsedSeconds: elapsed.Seconds(),
+		Workers:        workers,
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := sidecarPath(imagePath)
+	return path, os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// renderPBMFile renders into path, either streamed row by row or fully in
+// memory first.
+func renderPBMFile(cfg renderConfig, path string, streamed bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if streamed {
+		err = mandelbrotSequentialTo(cfg, w)
+	} else {
+		err = writePBM(w, cfg, mandelbrotSequential(cfg))
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeCounter counts the Write calls that reach w; for an *os.File each is
+// one write syscall.
+type writeCounter struct {
+	w      io.Writer
+	writes int
+}
+
+func (c *writeCounter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.w.Write(p)
+}
+
+// pbmWrite is the cost of writing one rendered image to a file.
+type pbmWrite struct {
+	elapsed time.Duration // create through close
+	writes  int           // Write calls that reached the file
+}
+
+// timePBMWrite writes img to path with writePBM and times it. With bufSize
+// 0 every row goes straight to the file; otherwise the file is wrapped in a
+// bufio.Writer of bufSize bytes, flushed before closing.
+func timePBMWrite(path string, cfg renderConfig, img [][]byte, bufSize int) (pbmWrite, error) {
+	start := time.Now()
+	f, err := os.Create(path)
+	if err != nil {
+		return pbmWrite{}, err
+	}
+	counter := &writeCounter{w: f}
+	if bufSize > 0 {
+		w := bufio.NewWriterSize(counter, bufSize)
+		err = writePBM(w, cfg, img)
+		if err == nil {
+			err = w.Flush()
+		}
+	} else {
+		err = writePBM(counter, cfg, img)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return pbmWrite{elapsed: time.Since(start), writes: counter.writes}, err
+}
+
+// comparePBMWrites writes the same image to path directly and to a temporary
+// file next to it through a bufSize bufio.Writer, checks that the two files
+// are byte-identical, and removes the temporary one.
+func comparePBMWrites(path string, cfg renderConfig, img [][]byte, bufSize int) (direct, buffered pbmWrite, err error) {
+	if direct, err = timePBMWrite(path, cfg, img, 0); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "*.pbm")
+	if err != nil {
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if buffered, err = timePBMWrite(tmp.Name(), cfg, img, bufSize); err != nil {
+		return
+	}
+
+	a, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	b, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(a, b) {
+		err = errors.New("buffered and direct writes produced different files")
+	}
+	return
+}
+
+// pixelsPerSecond normalizes a render time by image area so renders of
+// different sizes can be compared.
+func pixelsPerSecond(pixels int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(pixels) / d.Seconds()
+}
+
+func benchmark(name string, cfg renderConfig, precise bool, fn func(renderConfig) [][]byte) report.Result {
+	if cfg.single {
+		// Keep float32 runs apart from float64 ones in reports and history.
+		name += " float32"
+	}
 	runtime.GC()
 	rssBefore := getRSSMiB()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
 	start := time.Now()
 
-	_ = fn()
+	_ = fn(cfg)
 
 	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
 	rssAfter := getRSSMiB()
 
 	fmt.Printf("%s:\n", name)
-	fmt.Printf("  time: %dms\n", elapsed.Milliseconds())
+	if precise {
+		fmt.Printf("  time: %dus\n", elapsed.Microseconds())
+		fmt.Printf("  pixels/s: %.0f\n", pixelsPerSecond(cfg.size*cfg.size, elapsed))
+	} else {
+		fmt.Printf("  time: %dms\n", elapsed.Milliseconds())
+	}
 	fmt.Printf("  rss_delta: %.1fMiB\n", rssAfter-rssBefore)
+	verbosity.Detailf("  allocs: %d (%.1fKiB)\n", after.Mallocs-before.Mallocs, float64(after.TotalAlloc-before.TotalAlloc)/1024)
+
+	return report.Result{Benchmark: "mandelbrot", Name: name, Metrics: map[string]float64{
+		"seconds":      elapsed.Seconds(),
+		"pixels_per_s": pixelsPerSecond(cfg.size*cfg.size, elapsed),
+		"rss_delta_mb": rssAfter - rssBefore,
+		// One render is one op, as in -benchformat's ns/op.
+		"allocs_per_op": float64(after.Mallocs - before.Mallocs),
+		"bytes_per_op":  float64(after.TotalAlloc - before.TotalAlloc),
+	}}
+}
+
+// benchmarkInto pre-allocates the image before timing fn, so the reported
+// time covers only the arithmetic.
+func benchmarkInto(name string, cfg renderConfig, precise bool, fn func(renderConfig, [][]byte)) report.Result {
+	result := allocateImage(cfg)
+	return benchmark(name, cfg, precise, func(cfg renderConfig) [][]byte {
+		fn(cfg, result)
+		return result
+	})
 }
 
 func main() {
-	fmt.Printf("Mandelbrot %dx%d, max_iter=%d\n", SIZE, SIZE, MAX_ITER)
-	fmt.Printf("GOMAXPROCS: %d\n\n", runtime.GOMAXPROCS(0))
+	size := flag.Int("size", SIZE, "Image width and height in pixels")
+	iters := flag.Int("max-iter", MAX_ITER, fmt.Sprintf("Maximum iterations per pixel (clamped to %d..%d)", minIter, maxIter))
+	centerX := flag.Float64("cx", -0.5, "Real part of the viewport center")
+	centerY := flag.Float64("cy", 0, "Imaginary part of the viewport center")
+	zoom := flag.Float64("zoom", 1, "Zoom factor (1 shows the whole set)")
+	precise := flag.Bool("precise", false, "Report time in microseconds plus pixels/s")
+	outputs := report.RegisterFlags()
+	cpuLimit := cpulimit.RegisterFlag()
+	excludeAlloc := flag.Bool("exclude-alloc", false, "Pre-allocate the image so only computation is timed")
+	lockThreads := flag.Bool("lock-threads", false, "Also render with each worker locked to its own OS thread")
+	cpuList := flag.String("cpus", "", "With -lock-threads, pin workers round-robin to these CPUs, e.g. 0,1,2 (Linux only)")
+	sharded := flag.Bool("sharded", false, "Also render with per-worker queues and work stealing")
+	pbmPath := flag.String("pbm", "", "Also write the sequential render to this PBM file, once from memory and once streamed row by row")
+	pbmBuffer := flag.Int("pbm-buffer", 0, "With -pbm, also time writing the image one row per write against through a bufio.Writer of this many bytes (0 = off)")
+	tracePath := flag.String("trace", "", tracing.FlagUsage)
+	packing := flag.String("packing", "bits", "Pixel layout for the sequential/threaded renders: bits (8 pixels per byte) or bytes (escape count per pixel)")
+	aa := flag.Int("aa", 1, "Supersampling factor per axis (1, 2 or 4); >1 adds an anti-aliased render")
+	pooled := flag.Bool("row-pool", false, "Also render threaded with row buffers reused from a sync.Pool across renders")
+	imbalance := flag.Bool("imbalance", false, "Also render threaded while counting rows per worker, and report their min/max/stddev")
+	precision := flag.Int("precision", 64, "Floating-point width of the per-row kernel: 64, or 32 to trade accuracy for speed and compare pixels against 64")
+	tileSize := flag.Int("tile", 0, "Also render in square tiles of this many pixels (a multiple of 8) and check the stitched image against the per-row one")
+	flag.Var(&memSource, "mem-source", memstat.FlagUsage)
+	quiet := flag.Bool("quiet", false, verbosity.QuietUsage)
+	verbose := flag.Bool("verbose", false, verbosity.VerboseUsage)
+	flag.Parse()
+	verbosity.Set(*quiet, *verbose)
+	cpuLimit.Apply()
+
+	cpus, err := parseCPUList(*cpuList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -cpus: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *packing != "bits" && *packing != "bytes" {
+		fmt.Fprintf(os.Stderr, "Invalid -packing %q: must be bits or bytes\n", *packing)
+		os.Exit(1)
+	}
+	if *packing == "bytes" && *excludeAlloc {
+		fmt.Fprintln(os.Stderr, "-exclude-alloc only supports -packing bits")
+		os.Exit(1)
+	}
+
+	if *aa != 1 && *aa != 2 && *aa != 4 {
+		fmt.Fprintf(os.Stderr, "Invalid -aa %d: must be 1, 2 or 4\n", *aa)
+		os.Exit(1)
+	}
+
+	if *tileSize < 0 || *tileSize%8 != 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -tile %d: must be 0 (off) or a positive multiple of 8\n", *tileSize)
+		os.Exit(1)
+	}
+
+	if *precision != 32 && *precision != 64 {
+		fmt.Fprintf(os.Stderr, "Invalid -precision %d: must be 32 or 64\n", *precision)
+		os.Exit(1)
+	}
+	if *precision == 32 && (*packing == "bytes" || *aa != 1 || *tileSize != 0) {
+		fmt.Fprintln(os.Stderr, "-precision 32 only applies to the bit-packed row kernel; drop -packing bytes, -aa and -tile")
+		os.Exit(1)
+	}
+
+	cfg, err := newRenderConfig(*size, *iters, *centerX, *centerY, *zoom)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid render config: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.single = *precision == 32
+
+	fmt.Printf("Mandelbrot %dx%d, max_iter=%d\n", cfg.size, cfg.size, cfg.maxIter)
+	if cfg.zoom != 1 || cfg.centerX != -0.5 || cfg.centerY != 0 {
+		fmt.Printf("center=(%g, %g), zoom=%g\n", cfg.centerX, cfg.centerY, cfg.zoom)
+	}
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Printf("mem_source: %s\n", memSource)
+	imageBytes := cfg.size * rowBytes(cfg)
+	if *packing == "bytes" {
+		imageBytes = cfg.size * cfg.size
+	}
+	fmt.Printf("packing: %s (%.1fKiB per image)\n", *packing, float64(imageBytes)/1024)
+	fmt.Printf("precision: float%d\n\n", *precision)
+
+	stopTrace, err := tracing.Start(*tracePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Trace error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var results []report.Result
+	if *packing == "bytes" {
+		results = append(results, benchmark("sequential (bytes)", cfg, *precise, mandelbrotItersSequential))
+		fmt.Println()
+		results = append(results, benchmark("threaded (bytes)", cfg, *precise, mandelbrotItersThreaded))
+	} else if *excludeAlloc {
+		results = append(results, benchmarkInto("sequential (pre-allocated)", cfg, *precise, mandelbrotSequentialInto))
+		fmt.Println()
+		results = append(results, benchmarkInto("threaded (pre-allocated)", cfg, *precise, mandelbrotThreadedInto))
+	} else {
+		results = append(results, benchmark("sequential", cfg, *precise, mandelbrotSequential))
+		fmt.Println()
+		results = append(results, benchmark("threaded", cfg, *precise, mandelbrotThreaded))
+	}
+
+	if cfg.single {
+		cfg64 := cfg
+		cfg64.single = false
+		diff := differingPixels(mandelbrotSequential(cfg), mandelbrotSequential(cfg64))
+		pct := float64(diff) / float64(cfg.size*cfg.size) * 100
+		fmt.Printf("\nfloat32 vs float64: %d of %d pixels differ (%.3f%%)\n", diff, cfg.size*cfg.size, pct)
+		results = append(results, report.Result{Benchmark: "mandelbrot", Name: "float32_vs_float64", Metrics: map[string]float64{
+			"pixels_differ": float64(diff),
+			"differ_pct":    pct,
+		}})
+	}
+
+	if *lockThreads {
+		fmt.Println()
+		name := "threaded (locked threads)"
+		if len(cpus) > 0 {
+			name = fmt.Sprintf("threaded (pinned to %s)", *cpuList)
+		}
+		var pinErr error
+		results = append(results, benchmark(name, cfg, *precise, func(cfg renderConfig) [][]byte {
+			var img [][]byte
+			img, pinErr = mandelbrotPinned(cfg, cpus)
+			return img
+		}))
+		if pinErr != nil {
+			fmt.Printf("  warning: %v\n", pinErr)
+		}
+	}
+
+	if *sharded {
+		fmt.Println()
+		var steals int
+		results = append(results, benchmark("threaded (sharded)", cfg, *precise, func(cfg renderConfig) [][]byte {
+			var img [][]byte
+			img, steals = mandelbrotSharded(cfg)
+			return img
+		}))
+		fmt.Printf("  steals: %d of %d rows\n", steals, cfg.size)
+	}
+
+	if *pooled {
+		fmt.Println()
+		pool := newRowPool(cfg)
+		// Fill the pool first, as a long-running renderer would have.
+		mandelbrotPooled(cfg, pool).release(pool)
+		var img pooledImage
+		results = append(results, benchmark("threaded (pooled rows)", cfg, *precise, func(cfg renderConfig) [][]byte {
+			img = mandelbrotPooled(cfg, pool)
+			return img.rows
+		}))
+		if !sameImage(img.rows, mandelbrotSequential(cfg)) {
+			fmt.Fprintln(os.Stderr, "Pooled render differs from the per-row render")
+			os.Exit(1)
+		}
+		img.release(pool)
+		fmt.Printf("  allocations: %d pooled, %d allocating per row\n",
+			mallocs(func() { mandelbrotPooled(cfg, pool).release(pool) }),
+			mallocs(func() { mandelbrotThreaded(cfg) }))
+	}
+
+	if *imbalance {
+		fmt.Println()
+		var counts []int
+		res := benchmark("threaded (per-worker rows)", cfg, *precise, func(cfg renderConfig) [][]byte {
+			var img [][]byte
+			img, counts = mandelbrotCounted(cfg)
+			return img
+		})
+		s := spreadOf(counts)
+		if s.total != cfg.size {
+			fmt.Fprintf(os.Stderr, "Workers completed %d rows, want %d\n", s.total, cfg.size)
+			os.Exit(1)
+		}
+		fmt.Printf("  rows per worker: min %d, max %d, mean %.1f, stddev %.1f over %d workers\n",
+			s.min, s.max, s.mean, s.stddev, len(counts))
+		res.Metrics["rows_min"] = float64(s.min)
+		res.Metrics["rows_max"] = float64(s.max)
+		res.Metrics["rows_stddev"] = s.stddev
+		results = append(results, res)
+	}
+
+	if *tileSize > 0 {
+		fmt.Println()
+		var tiled [][]byte
+		name := fmt.Sprintf("threaded (tiles %dx%d)", *tileSize, *tileSize)
+		results = append(results, benchmark(name, cfg, *precise, func(cfg renderConfig) [][]byte {
+			tiled = mandelbrotTiled(cfg, *tileSize)
+			return tiled
+		}))
+		if !sameImage(tiled, mandelbrotSequential(cfg)) {
+			fmt.Fprintln(os.Stderr, "Tiled render differs from the per-row render")
+			os.Exit(1)
+		}
+		fmt.Println("  matches per-row render")
+	}
+
+	if *pbmPath != "" {
+		var elapsed time.Duration
+		for _, streamed := range []bool{false, true} {
+			name := "pbm (in-memory)"
+			if streamed {
+				name = "pbm (streamed)"
+			}
+			var pbmErr error
+			fmt.Println()
+			res := benchmark(name, cfg, *precise, func(cfg renderConfig) [][]byte {
+				pbmErr = renderPBMFile(cfg, *pbmPath, streamed)
+				return nil
+			})
+			results = append(results, res)
+			if pbmErr != nil {
+				fmt.Fprintf(os.Stderr, "PBM error: %v\n", pbmErr)
+				os.Exit(1)
+			}
+			elapsed = time.Duration(res.Metrics["seconds"] * float64(time.Second))
+		}
+		fmt.Printf("  wrote %s\n", *pbmPath)
+		// The file on disk is the streamed render's, made by one goroutine.
+		sidecar, err := writeSidecar(*pbmPath, cfg, elapsed, 1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Sidecar error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("  wrote %s\n", sidecar)
+
+		if *pbmBuffer > 0 {
+			direct, buffered, err := comparePBMWrites(*pbmPath, cfg, mandelbrotSequential(cfg), *pbmBuffer)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "PBM error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("\npbm write, image already rendered:\n")
+			fmt.Printf("  %-14s %s in %d writes\n", "direct:", direct.elapsed.Round(time.Microsecond), direct.writes)
+			fmt.Printf("  %-14s %s in %d writes (%.1fx faster)\n", fmt.Sprintf("bufio %d:", *pbmBuffer),
+				buffered.elapsed.Round(time.Microsecond), buffered.writes, direct.elapsed.Seconds()/buffered.elapsed.Seconds())
+			fmt.Println("  files identical")
+			for _, w := range []struct {
+				name string
+				pbmWrite
+			}{{"pbm write (direct)", direct}, {fmt.Sprintf("pbm write (bufio %d)", *pbmBuffer), buffered}} {
+				results = append(results, report.Result{Benchmark: "mandelbrot", Name: w.name, Metrics: map[string]float64{
+					"seconds": w.elapsed.Seconds(),
+					"writes":  float64(w.writes),
+				}})
+			}
+		}
+	}
+
+	if *aa > 1 {
+		fmt.Println()
+		name := fmt.Sprintf("threaded (aa=%d)", *aa)
+		results = append(results, benchmark(name, cfg, *precise, func(cfg renderConfig) [][]byte {
+			return mandelbrotAA(cfg, *aa)
+		}))
+	}
+
+	if err := stopTrace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Trace error: %v\n", err)
+		os.Exit(1)
+	}
+	if *tracePath != "" {
+		fmt.Printf("\nWrote trace to %s\n", *tracePath)
+	}
+
+	verbosity.Notef("\nNote: rows share nothing but the output image, so the threaded renders scale with\n")
+	verbosity.Notef("GOMAXPROCS; goroutines run the arithmetic in parallel with no lock to contend on.\n")
 
-	benchmark("sequential", mandelbrotSequential)
-	fmt.Println()
-	benchmark("threaded", mandelbrotThreaded)
+	outputs.WriteOrExit(results...)
 }
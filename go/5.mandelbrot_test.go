@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewRenderConfigRejectsBadViewport(t *testing.T) {
+	tests := []struct {
+		name           string
+		centerX, zoom  float64
+		wantErrMention string
+	}{
+		{"zero zoom", -0.5, 0, "zoom"},
+		{"negative zoom", -0.5, -2, "zoom"},
+		{"NaN zoom", -0.5, math.NaN(), "zoom"},
+		{"Inf zoom", -0.5, math.Inf(1), "zoom"},
+		{"Inf center", math.Inf(1), 1, "center"},
+		{"NaN center", math.NaN(), 1, "center"},
+		{"overflowing center", math.MaxFloat64, 1e-300, "overflow"},
+	}
+	for _, tt := range tests {
+		_, err := newRenderConfig(64, MAX_ITER, tt.centerX, 0, tt.zoom)
+		if err == nil {
+			t.Errorf("%s: got no error, want one mentioning %q", tt.name, tt.wantErrMention)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErrMention) {
+			t.Errorf("%s: error %q doesn't mention %q", tt.name, err, tt.wantErrMention)
+		}
+	}
+}
+
+func TestNewRenderConfigClampsIterations(t *testing.T) {
+	for _, tt := range []struct{ in, want int }{{-5, minIter}, {0, minIter}, {50, 50}, {maxIter + 1, maxIter}} {
+		cfg, err := newRenderConfig(64, tt.in, -0.5, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.maxIter != tt.want {
+			t.Errorf("maxIter %d clamped to %d, want %d", tt.in, cfg.maxIter, tt.want)
+		}
+	}
+}
+
+func TestPixelsPerSecond(t *testing.T) {
+	for _, tt := range []struct {
+		pixels int
+		d      time.Duration
+		want   float64
+	}{
+		{4000 * 4000, 2 * time.Second, 8e6},
+		{100, time.Millisecond, 1e5},
+		{1, time.Microsecond, 1e6},
+		{100, 0, 0},
+		{100, -time.Second, 0},
+	} {
+		if got := pixelsPerSecond(tt.pixels, tt.d); got != tt.want {
+			t.Errorf("pixelsPerSecond(%d, %s) = %v, want %v", tt.pixels, tt.d, got, tt.want)
+		}
+	}
+}
+
+// testConfig is a small render of the default viewport, big enough to have
+// rows both inside and outside the set.
+func testConfig(t *testing.T) renderConfig {
+	t.Helper()
+	cfg, err := newRenderConfig(64, MAX_ITER, -0.5, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestIntoMatchesAllocating(t *testing.T) {
+	cfg := testConfig(t)
+	want := mandelbrotSequential(cfg)
+	if !sameImage(mandelbrotThreaded(cfg), want) {
+		t.Error("threaded render differs from sequential")
+	}
+
+	for name, into := range map[string]func(renderConfig, [][]byte){
+		"sequential into": mandelbrotSequentialInto,
+		"threaded into":   mandelbrotThreadedInto,
+	} {
+		img := allocateImage(cfg)
+		// Start from a dirty buffer: the into variants must overwrite, not OR.
+		for _, row := range img {
+			for i := range row {
+				row[i] = 0xff
+			}
+		}
+		into(cfg, img)
+		if !sameImage(img, want) {
+			t.Errorf("%s differs from the allocating render", name)
+		}
+	}
+}
+
+func TestAASoftensBoundary(t *testing.T) {
+	cfg := testConfig(t)
+	hard := mandelbrotAA(cfg, 1)
+	soft := mandelbrotAA(cfg, 2)
+	bits := mandelbrotSequential(cfg)
+
+	inside := func(img [][]byte, x, y int) bool { return img[y][x] == 255 }
+	boundary, softened := 0, 0
+	for y := 1; y < cfg.size-1; y++ {
+		for x := 1; x < cfg.size-1; x++ {
+			if got, want := inside(hard, x, y), bits[y][x/8]&(128>>(x%8)) != 0; got != want {
+				t.Fatalf("aa=1 pixel (%d, %d) inside=%v, computeRow says %v", x, y, got, want)
+			}
+			// An inside pixel with an escaping neighbour is a hard edge at aa=1.
+			if !inside(hard, x, y) || (inside(hard, x-1, y) && inside(hard, x+1, y) && inside(hard, x, y-1) && inside(hard, x, y+1)) {
+				continue
+			}
+			boundary++
+			if v := soft[y][x]; v > 0 && v < 255 {
+				softened++
+			}
+		}
+	}
+	if boundary == 0 {
+		t.Fatal("test image has no boundary pixels")
+	}
+	if softened == 0 {
+		t.Errorf("none of %d boundary pixels got an intermediate value at aa=2", boundary)
+	}
+}
+
+func TestShardedMatchesSingleQueue(t *testing.T) {
+	// Several workers even on a single CPU, so rows are dealt to more than
+	// one queue and stealing can happen.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	cfg := testConfig(t)
+	img, _ := mandelbrotSharded(cfg)
+	if !sameImage(img, mandelbrotThreaded(cfg)) {
+		t.Error("sharded render differs from the single-queue render")
+	}
+
+	const size = 1000
+	counts := make([]atomic.Int32, size)
+	forEachRowSharded(size, func(y int) {
+		counts[y].Add(1)
+		if y%7 == 0 {
+			runtime.Gosched() // uneven rows give other workers a chance to steal
+		}
+	})
+	for y := range counts {
+		if n := counts[y].Load(); n != 1 {
+			t.Errorf("row %d computed %d times, want 1", y, n)
+		}
+	}
+}
+
+func TestPinnedRenderIsCorrect(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("CPU pinning is Linux-only")
+	}
+	cfg := testConfig(t)
+	img, err := mandelbrotPinned(cfg, []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(img, mandelbrotSequential(cfg)) {
+		t.Error("render pinned to CPU 0 differs from the sequential render")
+	}
+}
+
+func TestPackingModesAgree(t *testing.T) {
+	cfg := testConfig(t)
+	bits := mandelbrotSequential(cfg)
+	for name, img := range map[string][][]byte{
+		"sequential": mandelbrotItersSequential(cfg),
+		"threaded":   mandelbrotItersThreaded(cfg),
+	} {
+		inside := 0
+		for y := 0; y < cfg.size; y++ {
+			for x := 0; x < cfg.size; x++ {
+				packed := bits[y][x/8]&(128>>(x%8)) != 0
+				if byteInside := img[y][x] == 255; byteInside != packed {
+					t.Fatalf("%s: pixel (%d, %d) inside=%v in bytes mode, %v bit-packed", name, x, y, byteInside, packed)
+				}
+				if packed {
+					inside++
+				}
+			}
+		}
+		if inside == 0 || inside == cfg.size*cfg.size {
+			t.Fatalf("%s: test image is uniform (%d inside)", name, inside)
+		}
+	}
+}
+
+func TestStreamedPBMMatchesInMemory(t *testing.T) {
+	cfg := testConfig(t)
+	var inMemory, streamed bytes.Buffer
+	if err := writePBM(&inMemory, cfg, mandelbrotSequential(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mandelbrotSequentialTo(cfg, &streamed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(streamed.Bytes(), inMemory.Bytes()) {
+		t.Errorf("streamed PBM (%d bytes) differs from the in-memory one (%d bytes)", streamed.Len(), inMemory.Len())
+	}
+}
+
+func TestSidecarMatchesConfig(t *testing.T) {
+	cfg, err := newRenderConfig(128, 300, -0.75, 0.1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := filepath.Join(t.TempDir(), "render.pbm")
+	path, err := writeSidecar(image, cfg, 1500*time.Millisecond, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != strings.TrimSuffix(image, ".pbm")+".json" {
+		t.Errorf("sidecar written to %s", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got renderMetadata
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := renderMetadata{
+		Image:          "render.pbm",
+		Size:           128,
+		MaxIter:        300,
+		CenterX:        -0.75,
+		CenterY:        0.1,
+		Zoom:           4,
+		EscapeRadius:   escapeRadius,
+		ElapsedSeconds: 1.5,
+		Workers:        8,
+	}
+	if got != want {
+		t.Errorf("sidecar %+v, want %+v", got, want)
+	}
+}
+
+func TestTiledMatchesPerRow(t *testing.T) {
+	// 100 isn't a multiple of any tile size, so edge tiles are partial.
+	cfg, err := newRenderConfig(100, MAX_ITER, -0.5, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mandelbrotSequential(cfg)
+	for _, size := range []int{8, 16, 24, 64, 128} {
+		if got := mandelbrotTiled(cfg, size); !sameImage(got, want) {
+			t.Errorf("tile %d: stitched image differs from the per-row render", size)
+		}
+	}
+}
+
+func TestWorkerCounts(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	cfg := testConfig(t)
+	img, counts := mandelbrotCounted(cfg)
+	if len(counts) != 4 {
+		t.Errorf("%d worker counts, want one per GOMAXPROCS (4)", len(counts))
+	}
+	if s := spreadOf(counts); s.total != cfg.size {
+		t.Errorf("workers completed %d rows in total, want %d", s.total, cfg.size)
+	}
+	if !sameImage(img, mandelbrotSequential(cfg)) {
+		t.Error("counted render differs from the sequential render")
+	}
+
+	s := spreadOf([]int{2, 4, 4, 4, 5, 5, 7, 9})
+	want := workerSpread{total: 40, min: 2, max: 9, mean: 5, stddev: 2}
+	if s != want {
+		t.Errorf("spreadOf = %+v, want %+v", s, want)
+	}
+}
+
+func TestPooledMatchesAllocating(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	cfg := testConfig(t)
+	pool := newRowPool(cfg)
+	first := mandelbrotPooled(cfg, pool)
+	if !sameImage(first.rows, mandelbrotThreaded(cfg)) {
+		t.Error("pooled render differs from the allocating one")
+	}
+	first.release(pool)
+
+	// A different view of the same size reuses those rows, so any pixel the
+	// render fails to overwrite shows up as a leftover from the first image.
+	zoomed, err := newRenderConfig(cfg.size, MAX_ITER, -0.75, 0.1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := mandelbrotPooled(zoomed, pool)
+	if !sameImage(second.rows, mandelbrotSequential(zoomed)) {
+		t.Error("render into recycled rows differs from the allocating one")
+	}
+	second.release(pool)
+}
+
+func TestFloat32AgreesAtDefaultZoom(t *testing.T) {
+	cfg, err := newRenderConfig(256, MAX_ITER, -0.5, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mandelbrotSequential(cfg)
+	cfg.single = true
+	got := mandelbrotSequential(cfg)
+
+	// float32 rounding can flip pixels right at the boundary, but only there.
+	total := cfg.size * cfg.size
+	diff := differingPixels(got, want)
+	if diff > total/100 {
+		t.Errorf("%d of %d pixels differ between float32 and float64, want under 1%%", diff, total)
+	}
+
+	// Deep in, neighbouring pixels are closer than float32 can tell apart, so
+	// some must differ; none would mean cfg.single never reached the kernel.
+	deep, err := newRenderConfig(64, 1000, -0.743643887, 0.131825904, 1e6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = mandelbrotSequential(deep)
+	deep.single = true
+	if differingPixels(mandelbrotSequential(deep), want) == 0 {
+		t.Error("float32 and float64 renders are identical at zoom 1e6, want float32 artifacts")
+	}
+}
+
+func TestBufferedPBMMatchesDirect(t *testing.T) {
+	cfg := testConfig(t)
+	img := mandelbrotSequential(cfg)
+	dir := t.TempDir()
+
+	direct, err := timePBMWrite(filepath.Join(dir, "direct.pbm"), cfg, img, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffered, err := timePBMWrite(filepath.Join(dir, "buffered.pbm"), cfg, img, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := os.ReadFile(filepath.Join(dir, "direct.pbm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "buffered.pbm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("buffered file (%d bytes) differs from the direct one (%d bytes)", len(b), len(a))
+	}
+	// A 64x64 image is well under 4096 bytes, so buffering makes one write.
+	if buffered.writes != 1 || direct.writes <= cfg.size {
+		t.Errorf("%d buffered and %d direct writes, want 1 and more than %d", buffered.writes, direct.writes, cfg.size)
+	}
+
+	if _, _, err := comparePBMWrites(filepath.Join(dir, "compare.pbm"), cfg, img, 64); err != nil {
+		t.Errorf("comparePBMWrites: %v", err)
+	}
+}
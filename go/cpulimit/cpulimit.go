@@ -0,0 +1,55 @@
+// Package cpulimit backs the benchmarks' shared -cpu-limit flag, which caps
+// GOMAXPROCS at a fraction of the machine's CPUs the way a container's CPU
+// quota would, without configuring cgroups.
+package cpulimit
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+)
+
+// FlagUsage is the usage text benchmarks pass when registering -cpu-limit.
+const FlagUsage = "Run on this fraction of NumCPU, like a container CPU quota, e.g. 0.5 (sets GOMAXPROCS, rounding up; 0 = no limit)"
+
+// Procs returns the GOMAXPROCS a quota of limit (0 < limit <= 1) allows on
+// numCPU CPUs: limit·numCPU rounded up, so any quota gets at least one.
+func Procs(limit float64, numCPU int) int {
+	return max(1, int(math.Ceil(limit*float64(numCPU))))
+}
+
+// Flag is the value of -cpu-limit, as registered by RegisterFlag.
+type Flag struct {
+	limit float64
+}
+
+// RegisterFlag adds -cpu-limit to the command-line flag set. Call it before
+// flag.Parse and Apply the result after.
+func RegisterFlag() *Flag {
+	f := &Flag{}
+	flag.Float64Var(&f.limit, "cpu-limit", 0, FlagUsage)
+	return f
+}
+
+// Apply sets GOMAXPROCS for the parsed -cpu-limit, or exits with a usage
+// error if it isn't a fraction in (0, 1].
+func (f *Flag) Apply() {
+	if err := Apply(f.limit); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -cpu-limit: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Apply sets GOMAXPROCS for limit. A limit of 0 leaves it unchanged.
+func Apply(limit float64) error {
+	if limit == 0 {
+		return nil
+	}
+	if limit < 0 || limit > 1 {
+		return fmt.Errorf("%g is not a fraction in (0, 1]", limit)
+	}
+	runtime.GOMAXPROCS(Procs(limit, runtime.NumCPU()))
+	return nil
+}
@@ -0,0 +1,40 @@
+package cpulimit
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestProcs(t *testing.T) {
+	for _, tt := range []struct {
+		limit  float64
+		numCPU int
+		want   int
+	}{
+		{0.5, 8, 4},
+		{1, 8, 8},
+		{0.3, 8, 3}, // 2.4 rounds up
+		{0.01, 8, 1},
+		{0.5, 1, 1},
+	} {
+		if got := Procs(tt.limit, tt.numCPU); got != tt.want {
+			t.Errorf("Procs(%g, %d) = %d, want %d", tt.limit, tt.numCPU, got, tt.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	for _, bad := range []float64{-0.5, 1.5} {
+		if err := Apply(bad); err == nil {
+			t.Errorf("Apply(%g) accepted an invalid limit", bad)
+		}
+	}
+	before := runtime.GOMAXPROCS(0)
+	if err := Apply(0); err != nil || runtime.GOMAXPROCS(0) != before {
+		t.Errorf("Apply(0) changed GOMAXPROCS to %d (err %v)", runtime.GOMAXPROCS(0), err)
+	}
+	if err := Apply(1); err != nil || runtime.GOMAXPROCS(0) != runtime.NumCPU() {
+		t.Errorf("Apply(1): GOMAXPROCS %d, want NumCPU %d (err %v)", runtime.GOMAXPROCS(0), runtime.NumCPU(), err)
+	}
+}
@@ -0,0 +1,16 @@
+// Package fib holds the one step of the Fibonacci recurrence that every
+// big.Int loop in the benchmarks takes, so the variants only differ in how
+// they set up their values and what they do between steps.
+package fib
+
+import "math/big"
+
+// Step advances two consecutive terms of a Fibonacci-style sequence in place,
+// from (a, b) = (X(i), X(i+1)) to (X(i+1), X(i+2)). temp is scratch that the
+// caller keeps across steps, so a loop only allocates when a value outgrows
+// the words it already has.
+func Step(a, b, temp *big.Int) {
+	temp.Set(a)
+	a.Set(b)
+	b.Add(temp, b)
+}
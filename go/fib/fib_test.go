@@ -0,0 +1,25 @@
+package fib
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestStep(t *testing.T) {
+	a, b, temp := big.NewInt(0), big.NewInt(1), new(big.Int)
+	for i, want := range []int64{0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55} {
+		if a.Int64() != want {
+			t.Fatalf("after %d steps a = %s, want F(%d) = %d", i, a, i, want)
+		}
+		Step(a, b, temp)
+	}
+
+	// Lucas numbers follow the same step from L(0) = 2, L(1) = 1.
+	a, b = big.NewInt(2), big.NewInt(1)
+	for range 10 {
+		Step(a, b, temp)
+	}
+	if a.Int64() != 123 {
+		t.Errorf("L(10) = %s, want 123", a)
+	}
+}
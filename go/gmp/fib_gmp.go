@@ -0,0 +1,43 @@
+//go:build cgo && gmp
+
+package gmp
+
+/*
+#cgo LDFLAGS: -lgmp
+#include <stdlib.h>
+#include <gmp.h>
+
+// fib_str returns F(n) in decimal, allocated with malloc by GMP's default
+// allocator, so the caller frees it.
+static char *fib_str(unsigned long n) {
+	mpz_t f;
+	mpz_init(f);
+	mpz_fib_ui(f, n);
+	char *s = mpz_get_str(NULL, 10, f);
+	mpz_clear(f);
+	return s;
+}
+*/
+import "C"
+
+import "unsafe"
+
+// Available reports whether Fibonacci calls into libgmp.
+const Available = true
+
+// Backend names the implementation behind Fibonacci, e.g. "libgmp 6.3.0".
+func Backend() string {
+	return "libgmp " + C.GoString(C.gmp_version)
+}
+
+// Fibonacci returns F(n) in decimal using mpz_fib_ui, which works from a
+// table and the doubling identities rather than n additions. Negative n
+// gives "0".
+func Fibonacci(n int) string {
+	if n <= 0 {
+		return "0"
+	}
+	s := C.fib_str(C.ulong(n))
+	defer C.free(unsafe.Pointer(s))
+	return C.GoString(s)
+}
@@ -0,0 +1,17 @@
+//go:build cgo && gmp
+
+package gmp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGMPMatchesMathBig(t *testing.T) {
+	if !Available || !strings.HasPrefix(Backend(), "libgmp") {
+		t.Fatalf("built with -tags gmp but the backend is %s", Backend())
+	}
+	if got, want := Fibonacci(10000), mathBigFibonacci(10000); got != want {
+		t.Errorf("GMP F(10000) has %d digits, math/big %d; they differ", len(got), len(want))
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !(cgo && gmp)
+
+package gmp
+
+import "math/big"
+
+// Available reports whether Fibonacci calls into libgmp.
+const Available = false
+
+// Backend names the implementation behind Fibonacci.
+func Backend() string {
+	return "math/big fast doubling"
+}
+
+// Fibonacci returns F(n) in decimal using the same fast-doubling approach as
+// mpz_fib_ui, in math/big:
+// F(2k) = F(k)·(2F(k+1) − F(k)) and F(2k+1) = F(k)² + F(k+1)².
+// Negative n gives "0".
+func Fibonacci(n int) string {
+	if n <= 0 {
+		return "0"
+	}
+	a, b := big.NewInt(0), big.NewInt(1) // F(k), F(k+1), starting at k = 0
+	t, u := new(big.Int), new(big.Int)
+	for bit := big.NewInt(int64(n)).BitLen() - 1; bit >= 0; bit-- {
+		t.Lsh(b, 1).Sub(t, a).Mul(t, a) // F(2k)
+		u.Mul(b, b)
+		b.Mul(a, a).Add(b, u) // F(2k+1)
+		a, t = t, a
+		if n>>bit&1 == 1 {
+			a.Add(a, b)
+			a, b = b, a // F(2k+1), F(2k+2)
+		}
+	}
+	return a.Text(10)
+}
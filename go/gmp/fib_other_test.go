@@ -0,0 +1,19 @@
+//go:build !(cgo && gmp)
+
+package gmp
+
+import "testing"
+
+func TestFallbackMatchesMathBig(t *testing.T) {
+	if Available {
+		t.Fatal("fallback build claims to use libgmp")
+	}
+	for _, n := range []int{1, 2, 3, 10, 63, 64, 65, 10000} {
+		if got, want := Fibonacci(n), mathBigFibonacci(n); got != want {
+			t.Errorf("Fibonacci(%d) = %.20s..., want %.20s...", n, got, want)
+		}
+	}
+	if got := Fibonacci(-1); got != "0" {
+		t.Errorf("Fibonacci(-1) = %s, want 0", got)
+	}
+}
@@ -0,0 +1,5 @@
+// Package gmp computes Fibonacci numbers with GNU MP through cgo, for
+// comparison with math/big. It is only linked against libgmp when built with
+// cgo and -tags gmp; otherwise Fibonacci falls back to pure Go and Available
+// is false.
+package gmp
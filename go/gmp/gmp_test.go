@@ -0,0 +1,14 @@
+package gmp
+
+import "math/big"
+
+// mathBigFibonacci is F(n) by n additions in math/big, the reference both
+// backends are checked against.
+func mathBigFibonacci(n int) string {
+	a, b := big.NewInt(0), big.NewInt(1)
+	for i := 0; i < n; i++ {
+		a.Add(a, b)
+		a, b = b, a
+	}
+	return a.Text(10)
+}
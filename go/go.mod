@@ -4,4 +4,10 @@ go 1.24.0
 
 toolchain go1.24.2
 
-require golang.org/x/net v0.49.0 // indirect
+require (
+	github.com/HdrHistogram/hdrhistogram-go v1.1.2
+	golang.org/x/net v0.49.0
+	golang.org/x/sync v0.19.0
+	golang.org/x/sys v0.40.0
+	golang.org/x/time v0.14.0
+)
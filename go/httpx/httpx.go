@@ -0,0 +1,134 @@
+// Package httpx provides a small retrying HTTP client shared by the load test
+// and the scraper.
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Client wraps an *http.Client with retry and backoff behaviour.
+type Client struct {
+	HTTP *http.Client
+
+	// Retries is the retry budget used by Get; Do takes it explicitly.
+	Retries int
+
+	// BaseBackoff is the delay before the first retry; it doubles on each
+	// further attempt up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// Acquire, when set, is called before every attempt, retries included,
+	// e.g. to wait for a rate-limit token or a per-host slot; its error ends
+	// the request. The release it returns runs once the attempt is over:
+	// straight away for a retried or failed attempt, or when the returned
+	// response's body is closed. Backoff sleeps happen with nothing held.
+	Acquire func(req *http.Request) (release func(), err error)
+}
+
+// DefaultClient is used by the package-level Do.
+var DefaultClient = New(30 * time.Second)
+
+// New returns a Client whose underlying http.Client gives up on a single
+// attempt after timeout (0 = no timeout).
+func New(timeout time.Duration) *Client {
+	return &Client{
+		HTTP:        &http.Client{Timeout: timeout},
+		BaseBackoff: 50 * time.Millisecond,
+		MaxBackoff:  2 * time.Second,
+	}
+}
+
+// Do sends req via DefaultClient, retrying up to retries times.
+func Do(ctx context.Context, req *http.Request, retries int) (*http.Response, error) {
+	return DefaultClient.Do(ctx, req, retries)
+}
+
+// Do sends req, retrying up to retries times on transport errors and 5xx
+// responses with exponential backoff. The final attempt's response or error
+// is returned as is, so a persistent 5xx comes back as a response, not an
+// error. Requests with a body are only retried if req.GetBody is set.
+func (c *Client) Do(ctx context.Context, req *http.Request, retries int) (*http.Response, error) {
+	if req.Body != nil && req.GetBody == nil {
+		retries = 0
+	}
+
+	backoff := c.BaseBackoff
+	for attempt := 0; ; attempt++ {
+		attemptReq := req.Clone(ctx)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("httpx: rewinding body: %w", err)
+			}
+			attemptReq.Body = body
+		}
+
+		release := func() {}
+		if c.Acquire != nil {
+			var err error
+			if release, err = c.Acquire(attemptReq); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.HTTP.Do(attemptReq)
+		if attempt >= retries || !retryable(resp, err) {
+			if resp == nil {
+				release()
+			} else {
+				resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+			}
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		release()
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+		if c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+// Get issues a GET for url using the client's Retries budget.
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req, c.Retries)
+}
+
+// releasingBody runs release when the body is first closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500
+}
@@ -0,0 +1,139 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingServer returns 503 for the first failures requests and 200 after.
+func failingServer(t *testing.T, failures int64) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var calls atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls
+}
+
+func TestDoRetriesOnce(t *testing.T) {
+	ts, calls := failingServer(t, 1)
+	c := New(5 * time.Second)
+	c.BaseBackoff = time.Millisecond
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	resp, err := c.Do(context.Background(), req, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want 200", resp.StatusCode)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("server saw %d requests, want 2", n)
+	}
+}
+
+func TestDoReturnsLastResponseWhenOutOfRetries(t *testing.T) {
+	ts, calls := failingServer(t, 5)
+	c := New(5 * time.Second)
+	c.BaseBackoff = time.Millisecond
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	resp, err := c.Do(context.Background(), req, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", resp.StatusCode)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("server saw %d requests, want 3", n)
+	}
+}
+
+func TestAcquirePerAttempt(t *testing.T) {
+	ts, _ := failingServer(t, 2)
+	c := New(5 * time.Second)
+	c.BaseBackoff = time.Millisecond
+	var acquired, held atomic.Int64
+	c.Acquire = func(req *http.Request) (func(), error) {
+		acquired.Add(1)
+		if held.Add(1) != 1 {
+			t.Error("attempt started while an earlier one still held its slot")
+		}
+		return func() { held.Add(-1) }, nil
+	}
+
+	c.Retries = 2
+
+	resp, err := c.Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := acquired.Load(); n != 3 {
+		t.Errorf("acquired %d times for three attempts", n)
+	}
+	if held.Load() != 1 {
+		t.Error("final attempt's slot released before its body was closed")
+	}
+	resp.Body.Close()
+	resp.Body.Close()
+	if n := held.Load(); n != 0 {
+		t.Errorf("%d slots still held after closing the body", n)
+	}
+}
+
+func TestAcquireErrorEndsRequest(t *testing.T) {
+	ts, calls := failingServer(t, 0)
+	c := New(5 * time.Second)
+	boom := errors.New("no slot")
+	c.Acquire = func(*http.Request) (func(), error) { return nil, boom }
+	if _, err := c.Get(context.Background(), ts.URL); !errors.Is(err, boom) {
+		t.Errorf("err = %v, want %v", err, boom)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("server saw %d requests, want 0", n)
+	}
+}
+
+func TestDoRewindsBody(t *testing.T) {
+	var bodies []string
+	var calls atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, string(b))
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	c := New(5 * time.Second)
+	c.BaseBackoff = time.Millisecond
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("payload"))
+	resp, err := c.Do(context.Background(), req, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(bodies) != 2 || bodies[0] != "payload" || bodies[1] != "payload" {
+		t.Errorf("server received bodies %q, want the payload twice", bodies)
+	}
+}
@@ -0,0 +1,84 @@
+// Package memstat lets every benchmark report memory from the same source,
+// selected with a shared -mem-source flag.
+package memstat
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Source selects where memory numbers come from. It implements flag.Value.
+type Source string
+
+const (
+	// RSS is the OS view: the process's peak resident set size.
+	RSS Source = "rss"
+	// Runtime is the Go view: bytes of live heap objects (MemStats.HeapAlloc).
+	Runtime Source = "runtime"
+	// RSSNow is the OS view at this instant, which can fall as well as rise
+	// (Linux only).
+	RSSNow Source = "rss-now"
+	// Sys is everything the Go runtime has obtained from the OS
+	// (MemStats.Sys), which the server reported before -mem-source existed.
+	Sys Source = "sys"
+)
+
+// Default is the source every benchmark starts with, so their numbers are
+// comparable unless -mem-source says otherwise. Peak RSS is what the memory
+// benchmark has always reported and the only source that sees memory the Go
+// heap doesn't own.
+const Default = RSS
+
+// FlagUsage is the usage text benchmarks pass when registering -mem-source.
+const FlagUsage = "Memory source for reporting: rss (OS peak RSS, the default), rss-now (current RSS, Linux only), runtime (Go HeapAlloc) or sys (Go MemStats.Sys)"
+
+func (s *Source) String() string { return string(*s) }
+
+func (s *Source) Set(v string) error {
+	switch Source(v) {
+	case RSS, RSSNow, Runtime, Sys:
+		*s = Source(v)
+		return nil
+	}
+	return fmt.Errorf("unknown memory source %q (want %q, %q, %q or %q)", v, RSS, RSSNow, Runtime, Sys)
+}
+
+// Reader returns the function that reads this source, in MiB.
+func (s Source) Reader() func() float64 {
+	switch s {
+	case Runtime:
+		return RuntimeMB
+	case RSSNow:
+		return CurrentRSSMB
+	case Sys:
+		return SysMB
+	}
+	return RSSMB
+}
+
+// Label is a short name for console output, e.g. "RSS before: ...".
+func (s Source) Label() string {
+	switch s {
+	case Runtime:
+		return "HeapAlloc"
+	case RSSNow:
+		return "Current RSS"
+	case Sys:
+		return "Sys"
+	}
+	return "RSS"
+}
+
+// RuntimeMB returns MemStats.HeapAlloc in MiB.
+func RuntimeMB() float64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return float64(m.HeapAlloc) / (1024 * 1024)
+}
+
+// SysMB returns MemStats.Sys in MiB.
+func SysMB() float64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return float64(m.Sys) / (1024 * 1024)
+}
@@ -0,0 +1,48 @@
+package memstat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSourceRouting(t *testing.T) {
+	for _, tc := range []struct {
+		flag   string
+		source Source
+		reader func() float64
+		label  string
+	}{
+		{"rss", RSS, RSSMB, "RSS"},
+		{"rss-now", RSSNow, CurrentRSSMB, "Current RSS"},
+		{"runtime", Runtime, RuntimeMB, "HeapAlloc"},
+		{"sys", Sys, SysMB, "Sys"},
+	} {
+		var s Source
+		if err := s.Set(tc.flag); err != nil {
+			t.Fatalf("Set(%q): %v", tc.flag, err)
+		}
+		if s != tc.source {
+			t.Errorf("Set(%q) selected %q, want %q", tc.flag, s, tc.source)
+		}
+		if got, want := reflect.ValueOf(s.Reader()).Pointer(), reflect.ValueOf(tc.reader).Pointer(); got != want {
+			t.Errorf("%q reads from the wrong function", tc.flag)
+		}
+		if got := s.Label(); got != tc.label {
+			t.Errorf("%q label = %q, want %q", tc.flag, got, tc.label)
+		}
+	}
+
+	var s Source
+	if err := s.Set("heap"); err == nil {
+		t.Error("Set accepted an unknown source")
+	}
+	if Default != RSS {
+		t.Errorf("Default = %q; the benchmarks document rss as the default", Default)
+	}
+}
+
+func TestRuntimeReaders(t *testing.T) {
+	if heap, sys := RuntimeMB(), SysMB(); heap <= 0 || sys < heap {
+		t.Errorf("HeapAlloc %.2f MiB, Sys %.2f MiB; want 0 < HeapAlloc <= Sys", heap, sys)
+	}
+}
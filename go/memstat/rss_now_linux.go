@@ -0,0 +1,23 @@
+//go:build linux
+
+package memstat
+
+import (
+	"fmt"
+	"os"
+)
+
+// CurrentRSSMB returns the process's resident set size right now in MiB, read
+// from /proc/self/statm, or 0 if it can't be read. Unlike RSSMB it goes down
+// when pages are returned to the OS.
+func CurrentRSSMB() float64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	var size, resident int64
+	if _, err := fmt.Sscan(string(data), &size, &resident); err != nil {
+		return 0
+	}
+	return float64(resident*int64(os.Getpagesize())) / (1024 * 1024)
+}
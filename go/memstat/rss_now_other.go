@@ -0,0 +1,8 @@
+//go:build !linux
+
+package memstat
+
+// CurrentRSSMB is only implemented on Linux and always returns 0 elsewhere.
+func CurrentRSSMB() float64 {
+	return 0
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package memstat
+
+// RSSMB is not implemented on this platform and always returns 0.
+func RSSMB() float64 {
+	return 0
+}
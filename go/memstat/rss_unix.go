@@ -0,0 +1,28 @@
+//go:build unix
+
+package memstat
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// RSSMB returns the process's peak resident set size in MiB, or 0 if it
+// can't be read.
+func RSSMB() float64 {
+	var rusage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err != nil {
+		return 0
+	}
+
+	rss := float64(rusage.Maxrss)
+
+	// ru_maxrss units:
+	// - macOS (darwin): bytes
+	// - Linux: kilobytes
+	// - BSDs: often kilobytes (varies), but Linux rule works for most non-darwin here.
+	if runtime.GOOS == "darwin" {
+		return rss / (1024 * 1024) // bytes -> MB
+	}
+	return rss / 1024 // KB -> MB
+}
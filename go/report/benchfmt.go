@@ -0,0 +1,115 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// BenchFormatUsage is the usage text benchmarks pass when registering
+// -benchformat.
+const BenchFormatUsage = "Also print results as go test -bench lines, which benchstat reads (e.g. go run X.go -benchformat | benchstat -)"
+
+// benchUnit maps a metric onto one of the standard go test -bench units.
+type benchUnit struct {
+	metric string
+	unit   string
+	scale  float64
+	whole  bool // printed without decimals, as go test does for B/op and allocs/op
+}
+
+// benchUnits are the metrics with a standard unit. Every other metric is
+// written as a custom unit named after itself, which benchstat also accepts.
+var benchUnits = []benchUnit{
+	{"seconds", "ns/op", 1e9, false},
+	{"bytes_per_op", "B/op", 1, true},
+	{"allocs_per_op", "allocs/op", 1, true},
+}
+
+// BenchName is r's name as go test would print it for a sub-benchmark, e.g.
+// "BenchmarkMemBench/target_rss-8": the benchmark in CamelCase, then the
+// result name with whitespace replaced by '_', then GOMAXPROCS.
+func BenchName(r Result) string {
+	var b strings.Builder
+	b.WriteString("Benchmark")
+	for _, part := range strings.Split(r.Benchmark, "_") {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	if r.Name != "" {
+		b.WriteString("/")
+		b.WriteString(strings.Map(func(c rune) rune {
+			if unicode.IsSpace(c) {
+				return '_'
+			}
+			return c
+		}, r.Name))
+	}
+	return fmt.Sprintf("%s-%d", b.String(), runtime.GOMAXPROCS(0))
+}
+
+// BenchLine renders r as one go test -bench result line. Each result is a
+// single measured run, so the iteration count is always 1 and ns/op is the
+// whole run's duration.
+func BenchLine(r Result) string {
+	fields := []string{BenchName(r), "1"}
+	for _, u := range benchUnits {
+		v, ok := r.Metrics[u.metric]
+		if !ok {
+			continue
+		}
+		s := benchValue(v * u.scale)
+		if u.whole {
+			s = fmt.Sprintf("%.0f", v*u.scale)
+		}
+		fields = append(fields, s+" "+u.unit)
+	}
+	var rest []string
+	for m := range r.Metrics {
+		if !slices.ContainsFunc(benchUnits, func(u benchUnit) bool { return u.metric == m }) {
+			rest = append(rest, m)
+		}
+	}
+	slices.Sort(rest)
+	for _, m := range rest {
+		fields = append(fields, benchValue(r.Metrics[m])+" "+m)
+	}
+	return strings.Join(fields, "\t")
+}
+
+// benchValue formats x with the precision go test uses for benchmark
+// metrics: whole numbers from 1000 up, and more decimals the smaller x is.
+func benchValue(x float64) string {
+	switch y := math.Abs(x); {
+	case y == 0 || y >= 999.95 || math.IsNaN(y) || math.IsInf(y, 0):
+		return fmt.Sprintf("%.0f", x)
+	case y >= 99.995:
+		return fmt.Sprintf("%.1f", x)
+	case y >= 9.9995:
+		return fmt.Sprintf("%.2f", x)
+	case y >= 0.99995:
+		return fmt.Sprintf("%.3f", x)
+	case y >= 0.099995:
+		return fmt.Sprintf("%.4f", x)
+	case y >= 0.0099995:
+		return fmt.Sprintf("%.5f", x)
+	case y >= 0.00099995:
+		return fmt.Sprintf("%.6f", x)
+	}
+	return fmt.Sprintf("%.7f", x)
+}
+
+// WriteBench writes the goos/goarch configuration lines benchstat groups by,
+// then one BenchLine per result.
+func WriteBench(w io.Writer, results ...Result) {
+	fmt.Fprintf(w, "goos: %s\ngoarch: %s\n", runtime.GOOS, runtime.GOARCH)
+	for _, r := range results {
+		fmt.Fprintln(w, BenchLine(r))
+	}
+}
@@ -0,0 +1,84 @@
+package report
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Outputs are the places a benchmark main sends its results, as set by the
+// flags RegisterFlags adds. The zero value sends them nowhere.
+type Outputs struct {
+	Path        string // -report: JSON report file results are appended to
+	HistoryPath string // -history: history file the run is recorded in
+	PushURL     string // -push-url: pushgateway results are POSTed to
+	BenchFormat bool   // -benchformat: also print results as go test -bench lines
+}
+
+// RegisterFlags adds -report, -history, -push-url and -benchformat to the
+// command-line flag set. Call it before flag.Parse.
+func RegisterFlags() *Outputs {
+	o := &Outputs{}
+	flag.StringVar(&o.Path, "report", "", "Append results to this JSON report file")
+	flag.StringVar(&o.HistoryPath, "history", "", HistoryUsage)
+	flag.StringVar(&o.PushURL, "push-url", "", PushUsage)
+	flag.BoolVar(&o.BenchFormat, "benchformat", false, BenchFormatUsage)
+	return o
+}
+
+// Enabled reports whether any output is set, for mains that only collect
+// results when something will consume them.
+func (o *Outputs) Enabled() bool {
+	return o.Path != "" || o.HistoryPath != "" || o.PushURL != "" || o.BenchFormat
+}
+
+// Write appends results to the report and history files, pushes them and
+// prints them for benchstat, as each is set; history deltas and bench lines
+// go to w. A failed push only warns on stderr, since the gateway is often
+// optional; report and history errors are returned, the latter prefixed
+// "history: ". Writing no results is a no-op.
+func (o *Outputs) Write(w io.Writer, results ...Result) error {
+	failed, err := o.write(w, results)
+	if err != nil && failed == "History" {
+		return fmt.Errorf("history: %w", err)
+	}
+	return err
+}
+
+// WriteOrExit is Write to stdout for a benchmark main. A report or history
+// error is printed as "Report error: ..." or "History error: ..." and exits
+// with status 1.
+func (o *Outputs) WriteOrExit(results ...Result) {
+	if failed, err := o.write(os.Stdout, results); err != nil {
+		fmt.Fprintf(os.Stderr, "%s error: %v\n", failed, err)
+		os.Exit(1)
+	}
+}
+
+// write does the work of Write and names the output that failed, if any.
+func (o *Outputs) write(w io.Writer, results []Result) (failed string, err error) {
+	if len(results) == 0 {
+		return "", nil
+	}
+	if o.Path != "" {
+		if err := Append(o.Path, results...); err != nil {
+			return "Report", err
+		}
+	}
+	if o.HistoryPath != "" {
+		if err := RecordHistory(w, o.HistoryPath, results...); err != nil {
+			return "History", err
+		}
+	}
+	if o.PushURL != "" {
+		if err := Push(o.PushURL, results...); err != nil {
+			fmt.Fprintf(os.Stderr, "Push error: %v\n", err)
+		}
+	}
+	if o.BenchFormat {
+		fmt.Fprintln(w)
+		WriteBench(w, results...)
+	}
+	return "", nil
+}
@@ -0,0 +1,141 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HistoryUsage is the usage text benchmarks pass when registering -history.
+const HistoryUsage = "Append results to this JSON history file and print each metric's change since the previous run"
+
+// Run is one benchmark invocation recorded in a history file.
+type Run struct {
+	Key      string   `json:"key"`
+	Metadata Metadata `json:"metadata"`
+	Results  []Result `json:"results"`
+}
+
+// History is the on-disk history format, oldest run first. Unlike a report,
+// nothing is ever replaced: every run is kept so trends can be followed.
+type History struct {
+	Runs []Run `json:"runs"`
+}
+
+// ReadHistory loads a history written by AppendHistory.
+func ReadHistory(path string) (History, error) {
+	var h History
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h, err
+	}
+	err = json.Unmarshal(data, &h)
+	return h, err
+}
+
+// RunKey names the current run: `git describe --always --dirty` when run
+// inside a git checkout, otherwise the UTC timestamp.
+func RunKey(now time.Time) string {
+	out, err := exec.Command("git", "describe", "--always", "--dirty").Output()
+	if key := strings.TrimSpace(string(out)); err == nil && key != "" {
+		return key
+	}
+	return now.UTC().Format(time.RFC3339)
+}
+
+// Delta is the change in one metric since the most recent earlier run that
+// reported the same benchmark and name.
+type Delta struct {
+	Benchmark   string
+	Name        string
+	Metric      string
+	Previous    float64
+	Current     float64
+	PreviousKey string
+}
+
+func (d Delta) String() string {
+	s := fmt.Sprintf("%s/%s %s: %.6g -> %.6g", d.Benchmark, d.Name, d.Metric, d.Previous, d.Current)
+	if d.Previous != 0 {
+		s += fmt.Sprintf(" (%+.1f%%)", (d.Current-d.Previous)/d.Previous*100)
+	}
+	return s + " vs " + d.PreviousKey
+}
+
+// AppendHistory records results as a new run at the end of the history at
+// path, creating it if needed, and returns their deltas against earlier runs.
+// Results seen for the first time have no deltas.
+func AppendHistory(path string, results ...Result) (Run, []Delta, error) {
+	h, err := ReadHistory(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return Run{}, nil, err
+	}
+
+	var deltas []Delta
+	for _, r := range results {
+		prev, key, ok := h.latest(r.Benchmark, r.Name)
+		if !ok {
+			continue
+		}
+		metrics := make([]string, 0, len(r.Metrics))
+		for m := range r.Metrics {
+			if _, ok := prev.Metrics[m]; ok {
+				metrics = append(metrics, m)
+			}
+		}
+		slices.Sort(metrics)
+		for _, m := range metrics {
+			deltas = append(deltas, Delta{
+				Benchmark:   r.Benchmark,
+				Name:        r.Name,
+				Metric:      m,
+				Previous:    prev.Metrics[m],
+				Current:     r.Metrics[m],
+				PreviousKey: key,
+			})
+		}
+	}
+
+	meta := CurrentMetadata()
+	run := Run{Key: RunKey(meta.Timestamp), Metadata: meta, Results: results}
+	h.Runs = append(h.Runs, run)
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return Run{}, nil, err
+	}
+	return run, deltas, os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// latest finds the newest recorded result for benchmark and name, with the
+// key of the run it came from.
+func (h History) latest(benchmark, name string) (Result, string, bool) {
+	for i := len(h.Runs) - 1; i >= 0; i-- {
+		for _, r := range h.Runs[i].Results {
+			if r.Benchmark == benchmark && r.Name == name {
+				return r, h.Runs[i].Key, true
+			}
+		}
+	}
+	return Result{}, "", false
+}
+
+// RecordHistory is AppendHistory for benchmark mains: it writes the run key
+// and one line per delta to w.
+func RecordHistory(w io.Writer, path string, results ...Result) error {
+	run, deltas, err := AppendHistory(path, results...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nRecorded run %s in %s\n", run.Key, path)
+	for _, d := range deltas {
+		fmt.Fprintf(w, "  %s\n", d)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PushUsage is the usage text benchmarks pass when registering -push-url.
+const PushUsage = "POST results in Prometheus text format to this pushgateway URL, e.g. http://localhost:9091/metrics/job/bench (failures only warn)"
+
+// pushTimeout bounds a push so an unreachable gateway can't stall a run.
+const pushTimeout = 10 * time.Second
+
+// Exposition renders results in the Prometheus text format, one gauge family
+// per metric named benchmark_<metric> and one sample per result, labelled
+// with its benchmark and name.
+func Exposition(results []Result) []byte {
+	families := map[string][]Result{}
+	for _, r := range results {
+		for m := range r.Metrics {
+			families[m] = append(families[m], r)
+		}
+	}
+	metrics := make([]string, 0, len(families))
+	for m := range families {
+		metrics = append(metrics, m)
+	}
+	slices.Sort(metrics)
+
+	var b bytes.Buffer
+	for _, m := range metrics {
+		name := "benchmark_" + metricName(m)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+		for _, r := range families[m] {
+			fmt.Fprintf(&b, "%s{benchmark=%s,name=%s} %s\n", name,
+				labelValue(r.Benchmark), labelValue(r.Name), strconv.FormatFloat(r.Metrics[m], 'g', -1, 64))
+		}
+	}
+	return b.Bytes()
+}
+
+// metricName replaces every character Prometheus doesn't allow with '_'.
+func metricName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// labelValue quotes s as a label value, escaping \, " and newlines.
+func labelValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// Push POSTs results to a pushgateway at url in the Prometheus text format.
+func Push(url string, results ...Result) error {
+	client := &http.Client{Timeout: pushTimeout}
+	resp, err := client.Post(url, "text/plain; version=0.0.4", bytes.NewReader(Exposition(results)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
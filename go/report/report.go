@@ -0,0 +1,96 @@
+// Package report collects benchmark results into a single JSON document so
+// runs can be compared by machines as well as read on the console.
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Metadata describes the environment a report was produced in.
+type Metadata struct {
+	GoVersion  string    `json:"go_version"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	NumCPU     int       `json:"num_cpu"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// Result is one measured variant of a benchmark, e.g. the "threaded" run of
+// "mandelbrot". Metric names carry their unit ("seconds", "rss_peak_mb").
+type Result struct {
+	Benchmark string             `json:"benchmark"`
+	Name      string             `json:"name"`
+	Metrics   map[string]float64 `json:"metrics"`
+}
+
+// Document is the on-disk report format.
+type Document struct {
+	Metadata Metadata `json:"metadata"`
+	Results  []Result `json:"results"`
+}
+
+// CurrentMetadata describes the running process.
+func CurrentMetadata() Metadata {
+	return Metadata{
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Timestamp:  time.Now().UTC(),
+	}
+}
+
+// Read loads a report written by Write or Append.
+func Read(path string) (Document, error) {
+	var doc Document
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return doc, err
+	}
+	err = json.Unmarshal(data, &doc)
+	return doc, err
+}
+
+// Write replaces path with a report holding results and the current metadata.
+func Write(path string, results []Result) error {
+	doc := Document{Metadata: CurrentMetadata(), Results: results}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Append merges results into the report at path, creating it if needed.
+// A result with the same benchmark and name as an existing one replaces it,
+// so rerunning a single benchmark refreshes its entry.
+func Append(path string, results ...Result) error {
+	doc, err := Read(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	merged := doc.Results[:0]
+	for _, old := range doc.Results {
+		if !contains(results, old) {
+			merged = append(merged, old)
+		}
+	}
+	return Write(path, append(merged, results...))
+}
+
+func contains(results []Result, r Result) bool {
+	for _, x := range results {
+		if x.Benchmark == r.Benchmark && x.Name == r.Name {
+			return true
+		}
+	}
+	return false
+}
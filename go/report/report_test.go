@@ -0,0 +1,225 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestAppendRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	runs := [][]Result{
+		{{Benchmark: "fibonacci", Name: "single_threaded", Metrics: map[string]float64{"seconds": 1.5}}},
+		{{Benchmark: "mandelbrot", Name: "threaded", Metrics: map[string]float64{"seconds": 0.25, "pixels_per_s": 6.4e7}}},
+		{
+			{Benchmark: "server", Name: "load", Metrics: map[string]float64{"rps": 1200}},
+			{Benchmark: "server", Name: "stream", Metrics: map[string]float64{"seconds": 2}},
+		},
+		// A rerun replaces its earlier entry rather than duplicating it.
+		{{Benchmark: "fibonacci", Name: "single_threaded", Metrics: map[string]float64{"seconds": 1.25}}},
+	}
+	for _, results := range runs {
+		if err := Append(path, results...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doc, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		"fibonacci/single_threaded": 1.25,
+		"mandelbrot/threaded":       0.25,
+		"server/load":               0,
+		"server/stream":             2,
+	}
+	if len(doc.Results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(doc.Results), len(want), doc.Results)
+	}
+	for _, r := range doc.Results {
+		key := r.Benchmark + "/" + r.Name
+		seconds, ok := want[key]
+		if !ok {
+			t.Errorf("unexpected result %s", key)
+			continue
+		}
+		if r.Metrics["seconds"] != seconds {
+			t.Errorf("%s seconds = %v, want %v", key, r.Metrics["seconds"], seconds)
+		}
+		delete(want, key)
+	}
+	for key := range want {
+		t.Errorf("result %s missing", key)
+	}
+
+	m := doc.Metadata
+	if m.GoVersion != runtime.Version() || m.OS != runtime.GOOS || m.Arch != runtime.GOARCH || m.NumCPU < 1 || m.Timestamp.IsZero() {
+		t.Errorf("metadata not filled in: %+v", m)
+	}
+}
+
+func TestOutputsWrite(t *testing.T) {
+	dir := t.TempDir()
+	o := &Outputs{
+		Path:        filepath.Join(dir, "report.json"),
+		HistoryPath: filepath.Join(dir, "history.json"),
+		BenchFormat: true,
+	}
+	results := []Result{{Benchmark: "channels", Name: "unbuffered", Metrics: map[string]float64{"seconds": 0.5}}}
+
+	var out strings.Builder
+	if err := o.Write(&out, results...); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := Read(o.Path); err != nil || len(doc.Results) != 1 {
+		t.Errorf("report: %d results, err %v; want 1 result", len(doc.Results), err)
+	}
+	if h, err := ReadHistory(o.HistoryPath); err != nil || len(h.Runs) != 1 {
+		t.Errorf("history: %d runs, err %v; want 1 run", len(h.Runs), err)
+	}
+	if !strings.Contains(out.String(), "BenchmarkChannels/unbuffered") {
+		t.Errorf("bench lines missing from output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := o.Write(&out); err != nil || out.Len() != 0 {
+		t.Errorf("writing no results: err %v, output %q; want a no-op", err, out.String())
+	}
+	if (&Outputs{}).Enabled() || !o.Enabled() {
+		t.Error("Enabled doesn't track whether any output is set")
+	}
+
+	bad := &Outputs{HistoryPath: filepath.Join(dir, "missing", "history.json")}
+	if err := bad.Write(io.Discard, results...); err == nil || !strings.HasPrefix(err.Error(), "history: ") {
+		t.Errorf("unwritable history: err %v, want one prefixed \"history: \"", err)
+	}
+}
+
+func TestRecordHistoryDelta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	first := Result{Benchmark: "fibonacci", Name: "single_threaded", Metrics: map[string]float64{"seconds": 2}}
+	second := Result{Benchmark: "fibonacci", Name: "single_threaded", Metrics: map[string]float64{"seconds": 1.5}}
+
+	var out strings.Builder
+	if err := RecordHistory(&out, path, first); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "->") {
+		t.Errorf("first run printed a delta:\n%s", out.String())
+	}
+	h, err := ReadHistory(path)
+	if err != nil || len(h.Runs) != 1 {
+		t.Fatalf("after one run: %d runs, err %v", len(h.Runs), err)
+	}
+	firstKey := h.Runs[0].Key
+
+	out.Reset()
+	if err := RecordHistory(&out, path, second); err != nil {
+		t.Fatal(err)
+	}
+	want := "fibonacci/single_threaded seconds: 2 -> 1.5 (-25.0%) vs " + firstKey
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("second run output lacks %q:\n%s", want, out.String())
+	}
+	if h, err := ReadHistory(path); err != nil || len(h.Runs) != 2 {
+		t.Errorf("after two runs: %d runs, err %v", len(h.Runs), err)
+	}
+}
+
+func TestPush(t *testing.T) {
+	var body, contentType string
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, contentType = string(b), r.Header.Get("Content-Type")
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	results := []Result{
+		{Benchmark: "server", Name: "load", Metrics: map[string]float64{"rps": 1200, "peak_rss_mb": 48.5}},
+		{Benchmark: "fibonacci", Name: "single_threaded", Metrics: map[string]float64{"seconds": 1.5}},
+	}
+	if err := Push(ts.URL, results...); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Content-Type %q, want the text exposition format", contentType)
+	}
+	for _, want := range []string{
+		"# TYPE benchmark_rps gauge\n",
+		`benchmark_rps{benchmark="server",name="load"} 1200` + "\n",
+		`benchmark_peak_rss_mb{benchmark="server",name="load"} 48.5` + "\n",
+		`benchmark_seconds{benchmark="fibonacci",name="single_threaded"} 1.5` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("pushed body lacks %q:\n%s", want, body)
+		}
+	}
+
+	status = http.StatusInternalServerError
+	if err := Push(ts.URL, results...); err == nil {
+		t.Error("a 500 from the gateway isn't reported as an error")
+	}
+	// Write only warns, so a run still succeeds with the gateway down.
+	if err := (&Outputs{PushURL: ts.URL}).Write(io.Discard, results...); err != nil {
+		t.Errorf("Write with a failing push: %v, want nil", err)
+	}
+}
+
+// parseBenchLine splits a result line by the Go benchmark data format that
+// benchstat reads: a name starting "Benchmark" plus a non-lowercase rune, an
+// iteration count, then value-unit pairs, all separated by whitespace.
+func parseBenchLine(line string) (name string, iters int, values map[string]float64, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || len(fields)%2 != 0 {
+		return "", 0, nil, fmt.Errorf("%d fields, want a name, a count and value-unit pairs", len(fields))
+	}
+	name, ok := strings.CutPrefix(fields[0], "Benchmark")
+	if r, _ := utf8.DecodeRuneInString(name); !ok || unicode.IsLower(r) {
+		return "", 0, nil, fmt.Errorf("name %q doesn't start with Benchmark and a non-lowercase rune", fields[0])
+	}
+	if iters, err = strconv.Atoi(fields[1]); err != nil {
+		return "", 0, nil, fmt.Errorf("iteration count: %w", err)
+	}
+	values = map[string]float64{}
+	for i := 2; i < len(fields); i += 2 {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return "", 0, nil, fmt.Errorf("value for %s: %w", fields[i+1], err)
+		}
+		values[fields[i+1]] = v
+	}
+	return fields[0], iters, values, nil
+}
+
+func TestBenchLineParses(t *testing.T) {
+	r := Result{Benchmark: "mem_bench", Name: "target rss", Metrics: map[string]float64{
+		"seconds":       0.0123456,
+		"bytes_per_op":  2048,
+		"allocs_per_op": 3,
+		"peak_rss_mb":   48.5,
+	}}
+	line := BenchLine(r)
+	name, iters, values, err := parseBenchLine(line)
+	if err != nil {
+		t.Fatalf("%q: %v", line, err)
+	}
+	if want := fmt.Sprintf("BenchmarkMemBench/target_rss-%d", runtime.GOMAXPROCS(0)); name != want || iters != 1 {
+		t.Errorf("name %q, %d iterations; want %q, 1", name, iters, want)
+	}
+	want := map[string]float64{"ns/op": 12345600, "B/op": 2048, "allocs/op": 3, "peak_rss_mb": 48.5}
+	if !maps.Equal(values, want) {
+		t.Errorf("values %v, want %v", values, want)
+	}
+}
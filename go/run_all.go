@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/python-memory-research/go/report"
+)
+
+// benchmarks lists the suite in run order. The scraper is left out because
+// it depends on external sites.
+var benchmarks = []struct {
+	file string
+	args []string
+}{
+	{"1.fibbonaci.go", nil},
+	{"2.mem_bench.go", nil},
+	{"4.server.go", []string{"-mode", "both"}},
+	{"5.mandelbrot.go", nil},
+	{"10.channels.go", nil},
+	{"11.mixed.go", nil},
+	{"12.counters.go", nil},
+	{"13.errgroup.go", nil},
+	{"14.websocket.go", nil},
+	{"15.spawn.go", []string{"-max", "100000"}},
+	{"16.maps.go", []string{"-ops", "50000"}},
+	{"17.select.go", []string{"-max-n", "64"}},
+	{"18.cancel.go", []string{"-max-goroutines", "10000"}},
+}
+
+func runBenchmark(file, reportPath, historyPath, pushURL string, extra []string) error {
+	args := []string{"run", file, "-report", reportPath}
+	if historyPath != "" {
+		args = append(args, "-history", historyPath)
+	}
+	if pushURL != "" {
+		args = append(args, "-push-url", pushURL)
+	}
+	args = append(args, extra...)
+	cmd := exec.Command("go", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func main() {
+	reportPath := flag.String("report", "report.json", "Write the combined JSON report here")
+	historyPath := flag.String("history", "", report.HistoryUsage)
+	pushURL := flag.String("push-url", "", report.PushUsage)
+	benchFormat := flag.Bool("benchformat", false, report.BenchFormatUsage)
+	only := flag.String("only", "", "Comma-separated benchmark files to run (default: all)")
+	flag.Parse()
+
+	selected := map[string]bool{}
+	for _, f := range strings.Split(*only, ",") {
+		if f != "" {
+			selected[f] = true
+		}
+	}
+
+	// Start from an empty report so results from an older run don't leak in.
+	if err := os.Remove(*reportPath); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Report error: %v\n", err)
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, b := range benchmarks {
+		if len(selected) > 0 && !selected[b.file] {
+			continue
+		}
+		fmt.Printf("\n=== %s ===\n", b.file)
+		if err := runBenchmark(b.file, *reportPath, *historyPath, *pushURL, b.args); err != nil {
+			fmt.Fprintf(os.Stderr, "%s failed: %v\n", b.file, err)
+			failed++
+		}
+	}
+
+	doc, err := report.Read(*reportPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Report error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\nWrote %d results to %s\n", len(doc.Results), *reportPath)
+
+	// Printed from the combined report rather than by each benchmark, so
+	// the whole suite shares one goos/goarch header.
+	if *benchFormat {
+		fmt.Println()
+		report.WriteBench(os.Stdout, doc.Results...)
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
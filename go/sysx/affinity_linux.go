@@ -0,0 +1,13 @@
+//go:build linux
+
+package sysx
+
+import "golang.org/x/sys/unix"
+
+// PinToCPU restricts the calling OS thread to cpu. Call runtime.LockOSThread
+// first, otherwise the goroutine may migrate to an unpinned thread.
+func PinToCPU(cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
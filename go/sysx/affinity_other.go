@@ -0,0 +1,8 @@
+//go:build !linux
+
+package sysx
+
+// PinToCPU is only implemented on Linux.
+func PinToCPU(cpu int) error {
+	return ErrUnsupported
+}
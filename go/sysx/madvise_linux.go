@@ -0,0 +1,28 @@
+//go:build linux
+
+package sysx
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Release hands the pages backing b back to the OS with
+// madvise(MADV_DONTNEED) without unmapping them: RSS drops at once and the
+// next access faults in zeroed pages. Only the page-aligned interior of b is
+// released.
+func Release(b []byte) error {
+	page := os.Getpagesize()
+	addr := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	skip := int((uintptr(page) - addr%uintptr(page)) % uintptr(page))
+	if skip >= len(b) {
+		return nil
+	}
+	b = b[skip:]
+	b = b[:len(b)/page*page]
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.Madvise(b, syscall.MADV_DONTNEED)
+}
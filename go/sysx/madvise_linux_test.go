@@ -0,0 +1,36 @@
+//go:build linux
+
+package sysx
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReleaseTouchedRegion(t *testing.T) {
+	page := os.Getpagesize()
+	data := make([]byte, 64*page)
+	for i := range data {
+		data[i] = 0xff
+	}
+	if err := Release(data); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	// Released pages fault back in zeroed; find one whole page inside data.
+	zeroed := 0
+	for off := 0; off+page <= len(data); off += page {
+		if data[off] == 0 && data[off+page-1] == 0 {
+			zeroed++
+		}
+	}
+	if zeroed == 0 {
+		t.Error("no page of the buffer reads back zeroed after Release")
+	}
+
+	// Unaligned and sub-page slices are fine too.
+	for _, b := range [][]byte{data[1:], data[1 : page/2], data[:0]} {
+		if err := Release(b); err != nil {
+			t.Errorf("Release of %d bytes: %v", len(b), err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package sysx
+
+// Release is only implemented on Linux.
+func Release(b []byte) error {
+	return ErrUnsupported
+}
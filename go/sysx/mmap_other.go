@@ -0,0 +1,13 @@
+//go:build !unix
+
+package sysx
+
+// MapAnon is only implemented on Unix.
+func MapAnon(size int) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+// Unmap is only implemented on Unix.
+func Unmap(b []byte) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package sysx
+
+import "syscall"
+
+// MapAnon returns size bytes of private anonymous memory mapped directly from
+// the OS. The Go heap and GC never see it; release it with Unmap.
+func MapAnon(size int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+// Unmap releases memory obtained from MapAnon.
+func Unmap(b []byte) error {
+	return syscall.Munmap(b)
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package sysx
+
+import (
+	"os"
+	"testing"
+
+	"github.com/python-memory-research/go/memstat"
+)
+
+func TestMapAnonTouchRaisesRSS(t *testing.T) {
+	const size = 64 << 20
+	data, err := MapAnon(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Unmap(data)
+	if len(data) != size {
+		t.Fatalf("mapped %d bytes, want %d", len(data), size)
+	}
+
+	before := memstat.CurrentRSSMB()
+	if before == 0 {
+		t.Skip("current RSS isn't readable on this platform")
+	}
+	for off := 0; off < len(data); off += os.Getpagesize() {
+		data[off] = 1
+	}
+	// Allow some slack for pages the kernel may reclaim or merge.
+	if grew := memstat.CurrentRSSMB() - before; grew < 48 {
+		t.Errorf("RSS grew by %.1f MiB after touching a 64 MiB mapping", grew)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build linux
+
+package sysx
+
+// The raw getpriority syscall on Linux returns 20 - nice so that the result
+// is never negative.
+func niceFromPriority(prio int) int {
+	return 20 - prio
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package sysx
+
+// SetNice is only implemented on Unix.
+func SetNice(nice int) error {
+	return ErrUnsupported
+}
+
+// Nice is only implemented on Unix.
+func Nice() (int, error) {
+	return 0, ErrUnsupported
+}
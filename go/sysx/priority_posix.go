@@ -0,0 +1,7 @@
+//go:build unix && !linux
+
+package sysx
+
+func niceFromPriority(prio int) int {
+	return prio
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package sysx
+
+import "syscall"
+
+// SetNice sets the calling process's scheduling niceness. Lower values get
+// more CPU; going below the current value usually needs root or
+// CAP_SYS_NICE.
+func SetNice(nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice)
+}
+
+// Nice returns the calling process's current niceness.
+func Nice() (int, error) {
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		return 0, err
+	}
+	return niceFromPriority(prio), nil
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package sysx
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestSetNice(t *testing.T) {
+	start, err := Nice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start >= 19 {
+		t.Skipf("already at the lowest priority (nice %d)", start)
+	}
+
+	// Lowering priority is always allowed.
+	if err := SetNice(start + 1); err != nil {
+		t.Fatalf("SetNice(%d): %v", start+1, err)
+	}
+	if got, err := Nice(); err != nil || got != start+1 {
+		t.Errorf("Nice() = %d, %v after SetNice(%d)", got, err, start+1)
+	}
+
+	// Raising it back needs privileges; without them it must fail cleanly.
+	err = SetNice(start)
+	if err != nil && !errors.Is(err, syscall.EPERM) && !errors.Is(err, syscall.EACCES) {
+		t.Errorf("SetNice(%d) failed with %v, want success or a permission error", start, err)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package sysx
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenReusePort opens a TCP listener on addr with SO_REUSEPORT set, so
+// several listeners in one or more processes can bind the same port and the
+// kernel spreads new connections across them.
+func ListenReusePort(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: func(_, _ string, c syscall.RawConn) error {
+		var optErr error
+		if err := c.Control(func(fd uintptr) {
+			optErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}); err != nil {
+			return err
+		}
+		return optErr
+	}}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
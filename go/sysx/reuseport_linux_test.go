@@ -0,0 +1,56 @@
+//go:build linux
+
+package sysx
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestReusePortListenersShareAPort(t *testing.T) {
+	first, err := ListenReusePort("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := first.Addr().String()
+	second, err := ListenReusePort(addr)
+	if err != nil {
+		t.Fatalf("second listener on %s: %v", addr, err)
+	}
+	// Without SO_REUSEPORT the port is taken.
+	if ln, err := net.Listen("tcp", addr); err == nil {
+		ln.Close()
+		t.Errorf("plain listener bound %s alongside the reuseport ones", addr)
+	}
+
+	for i, ln := range []net.Listener{first, second} {
+		s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, strconv.Itoa(i))
+		})}
+		go s.Serve(ln)
+		t.Cleanup(func() { s.Close() })
+	}
+
+	// The kernel picks a listener by hashing each connection's addresses, so
+	// fresh connections from new source ports reach both before long.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	seen := map[string]bool{}
+	for tries := 0; tries < 200 && len(seen) < 2; tries++ {
+		resp, err := client.Get("http://" + addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[string(body)] = true
+	}
+	if !seen["0"] || !seen["1"] {
+		t.Errorf("requests reached listeners %v, want both 0 and 1", seen)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package sysx
+
+import "net"
+
+// ListenReusePort is only implemented on Linux.
+func ListenReusePort(addr string) (net.Listener, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,9 @@
+// Package sysx wraps the OS-specific knobs used by the experiments (CPU
+// affinity, anonymous mappings and friends) behind portable functions. On
+// platforms without support they return ErrUnsupported.
+package sysx
+
+import "errors"
+
+// ErrUnsupported is returned on platforms that lack the requested facility.
+var ErrUnsupported = errors.New("sysx: not supported on this platform")
@@ -0,0 +1,44 @@
+package sysx
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// SampleThreads polls ThreadCount and runtime.NumGoroutine every interval
+// until the returned stop function is called, which reports the peaks seen.
+func SampleThreads(interval time.Duration) (stop func() (threads, goroutines int)) {
+	var peakThreads, peakGoroutines int
+	sample := func() {
+		if n, err := ThreadCount(); err == nil {
+			peakThreads = max(peakThreads, n)
+		}
+		peakGoroutines = max(peakGoroutines, runtime.NumGoroutine())
+	}
+	sample()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				sample()
+			}
+		}
+	}()
+
+	return func() (int, int) {
+		close(done)
+		wg.Wait()
+		sample()
+		return peakThreads, peakGoroutines
+	}
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package sysx
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ThreadCount returns the number of OS threads in the process right now,
+// from the Threads line of /proc/self/status.
+func ThreadCount() (int, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if rest, ok := strings.CutPrefix(sc.Text(), "Threads:"); ok {
+			var n int
+			_, err := fmt.Sscan(rest, &n)
+			return n, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("sysx: no Threads line in /proc/self/status")
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package sysx
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThreadCountGrowsUnderBlockingWork(t *testing.T) {
+	before, err := ThreadCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before < 1 {
+		t.Fatalf("ThreadCount() = %d, want at least 1", before)
+	}
+
+	// Each goroutine locked to its thread and parked keeps that thread to
+	// itself, like a goroutine stuck in a blocking syscall, so the runtime
+	// must start more to keep running everything else. Lock more goroutines
+	// than there are threads already, so idle ones can't absorb them all.
+	blocked := before + 8
+	stop := SampleThreads(time.Millisecond)
+	release := make(chan struct{})
+	var parked, done sync.WaitGroup
+	parked.Add(blocked)
+	done.Add(blocked)
+	for i := 0; i < blocked; i++ {
+		go func() {
+			defer done.Done()
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			parked.Done()
+			<-release
+		}()
+	}
+	parked.Wait()
+	time.Sleep(10 * time.Millisecond)
+	threads, goroutines := stop()
+	close(release)
+	done.Wait()
+
+	// Each locked goroutine holds a thread, and another has to be left to
+	// run the sampler.
+	if threads <= blocked {
+		t.Errorf("peak of %d threads with %d locked goroutines, started from %d", threads, blocked, before)
+	}
+	if goroutines < blocked {
+		t.Errorf("peak of %d goroutines, want at least %d", goroutines, blocked)
+	}
+}
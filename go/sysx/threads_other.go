@@ -0,0 +1,12 @@
+//go:build !linux
+
+package sysx
+
+import "runtime/pprof"
+
+// ThreadCount has no live thread count outside Linux; it returns the number
+// of threads the runtime has created so far, which bounds the peak from
+// above.
+func ThreadCount() (int, error) {
+	return pprof.Lookup("threadcreate").Count(), nil
+}
@@ -0,0 +1,31 @@
+// Package tracing wraps runtime/trace behind the benchmarks' shared -trace
+// flag, so a run can be opened with `go tool trace`.
+package tracing
+
+import (
+	"os"
+	"runtime/trace"
+)
+
+// FlagUsage is the usage text benchmarks pass when registering -trace.
+const FlagUsage = "Write a runtime execution trace of the timed region to this file (view with go tool trace)"
+
+// Start begins tracing to path and returns the function that stops the trace
+// and closes the file. An empty path traces nothing and returns a no-op.
+func Start(path string) (stop func() error, err error) {
+	if path == "" {
+		return func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := trace.Start(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		trace.Stop()
+		return f.Close()
+	}, nil
+}
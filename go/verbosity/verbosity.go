@@ -0,0 +1,50 @@
+// Package verbosity backs the benchmarks' shared -quiet and -verbose flags.
+// Explanatory prose goes through Notef and optional detail through Detailf;
+// metrics keep using fmt directly, so they print at every level.
+package verbosity
+
+import "fmt"
+
+// Level is how much beyond the metrics a benchmark prints.
+type Level int
+
+const (
+	Quiet   Level = -1 // metrics only
+	Normal  Level = 0  // metrics and notes
+	Verbose Level = 1  // metrics, notes and per-iteration detail
+)
+
+// Usage texts benchmarks pass when registering -quiet and -verbose.
+const (
+	QuietUsage   = "Print only the metrics, without explanatory notes"
+	VerboseUsage = "Also print extra detail such as per-iteration timings"
+)
+
+var level = Normal
+
+// Set picks the level from the -quiet and -verbose flags; -quiet wins if
+// both are given.
+func Set(quiet, verbose bool) {
+	switch {
+	case quiet:
+		level = Quiet
+	case verbose:
+		level = Verbose
+	default:
+		level = Normal
+	}
+}
+
+// Notef prints explanatory prose unless -quiet is set.
+func Notef(format string, a ...any) {
+	if level >= Normal {
+		fmt.Printf(format, a...)
+	}
+}
+
+// Detailf prints only with -verbose.
+func Detailf(format string, a ...any) {
+	if level >= Verbose {
+		fmt.Printf(format, a...)
+	}
+}
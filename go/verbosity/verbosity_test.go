@@ -0,0 +1,61 @@
+package verbosity
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// capture returns what fn printed to stdout.
+func capture(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// run prints the way a benchmark main does: metrics through fmt, prose
+// through Notef and extra detail through Detailf.
+func run() {
+	fmt.Printf("rps: %d\n", 1234)
+	Detailf("  iteration 1: %dms\n", 5)
+	Notef("\nNote: %s\n", "goroutines run in parallel")
+}
+
+func TestLevels(t *testing.T) {
+	defer Set(false, false)
+	for _, tt := range []struct {
+		quiet, verbose     bool
+		wantNote, wantIter bool
+	}{
+		{true, false, false, false},
+		{false, false, true, false},
+		{false, true, true, true},
+		{true, true, false, false}, // -quiet wins
+	} {
+		Set(tt.quiet, tt.verbose)
+		out := capture(t, run)
+		if !strings.Contains(out, "rps: 1234") {
+			t.Errorf("quiet=%v verbose=%v: metrics missing from %q", tt.quiet, tt.verbose, out)
+		}
+		if got := strings.Contains(out, "Note:"); got != tt.wantNote {
+			t.Errorf("quiet=%v verbose=%v: Note printed %v, want %v", tt.quiet, tt.verbose, got, tt.wantNote)
+		}
+		if got := strings.Contains(out, "iteration 1"); got != tt.wantIter {
+			t.Errorf("quiet=%v verbose=%v: detail printed %v, want %v", tt.quiet, tt.verbose, got, tt.wantIter)
+		}
+	}
+}